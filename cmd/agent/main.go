@@ -157,6 +157,10 @@ Server address: %s
 	// wait for all goroutines to finish and exit the program
 	wg.Wait()
 
+	if err := controller.Close(); err != nil {
+		fmt.Println("error closing the gRPC connection: ", err)
+	}
+
 	fmt.Println("The agent has been shut down gracefully")
 
 	// os.Exit(0)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/plasmatrip/metriq/internal/server/auth"
+)
+
+// Программа для генерации API-ключа. Она генерирует случайный секрет,
+// выводит его для передачи клиенту и запись для файла ключей сервера,
+// в которой хранится только хэш секрета.
+func main() {
+	cl := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+
+	var id string
+	cl.StringVar(&id, "id", "", "id of the API key")
+
+	var scopes string
+	cl.StringVar(&scopes, "scopes", string(auth.ScopeRead), "comma separated scopes of the API key: read, write, admin")
+
+	if err := cl.Parse(os.Args[1:]); err != nil {
+		log.Fatalf("failed to parse flags: %v", err)
+	}
+
+	secret, err := auth.GenerateSecret()
+	if err != nil {
+		log.Fatalf("Ошибка при генерации секрета: %v", err)
+	}
+
+	key := auth.Key{ID: id, SecretHash: auth.HashSecret(secret)}
+	for _, s := range strings.Split(scopes, ",") {
+		key.Scopes = append(key.Scopes, auth.Scope(strings.TrimSpace(s)))
+	}
+
+	// проверяем ключ так же, как сервер при загрузке файла
+	if _, err := auth.NewKeys(key); err != nil {
+		log.Fatalf("Ошибка в параметрах ключа: %v", err)
+	}
+
+	entry, err := json.Marshal(key)
+	if err != nil {
+		log.Fatalf("Ошибка при кодировании ключа: %v", err)
+	}
+
+	fmt.Println("Секрет для клиента:", secret)
+	fmt.Println("Запись для файла ключей:", string(entry))
+}
@@ -14,13 +14,21 @@ import (
 	"os/signal"
 	"syscall"
 	"text/template"
+	"time"
 
 	"github.com/plasmatrip/metriq/internal/backup"
 	"github.com/plasmatrip/metriq/internal/logger"
 	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+	"github.com/plasmatrip/metriq/internal/server/graphite"
+	"github.com/plasmatrip/metriq/internal/server/health"
 	"github.com/plasmatrip/metriq/internal/server/router"
+	"github.com/plasmatrip/metriq/internal/server/rpc"
+	"github.com/plasmatrip/metriq/internal/server/statsd"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/storage"
 	"github.com/plasmatrip/metriq/internal/storage/db"
+	"github.com/plasmatrip/metriq/internal/storage/history"
 	"github.com/plasmatrip/metriq/internal/storage/mem"
 )
 
@@ -81,17 +89,32 @@ func main() {
 	}
 	defer l.Close()
 
+	// the IDs of applied batches and the samples for the aggregation queries are kept
+	// in the database with the metrics, in memory otherwise
+	retention := time.Duration(c.SampleRetention) * time.Second
 	var s storage.Repository
+	var ms *mem.MemStorage
+	var batches dedup.Store
 	if c.DSN == "" {
-		s = mem.NewStorage()
+		ms = mem.NewStorage()
+		ms.SetTypeConflict(c.TypeConflict)
+		s = ms
+		batches = dedup.NewCache(c.BatchIDCacheSize, time.Duration(c.BatchIDTTL)*time.Second)
 	} else {
-		s, err = db.NewPostgresStorage(ctx, c.DSN, l)
+		ps, err := db.NewPostgresStorage(ctx, c.DSN, l)
 		if err != nil {
 			l.Sugar.Infow("database connection error: ", err)
 			return
 			//os.Exit(1)
 		}
-		defer s.Close()
+		ps.SetSampleRetention(retention)
+		ps.SetTypeConflict(c.TypeConflict)
+		if err := ps.MigrateKeys(ctx); err != nil {
+			l.Sugar.Infow("the stored metric keys don't match the type conflict policy: ", err)
+			return
+		}
+		s = ps
+		batches = ps.Batches(time.Duration(c.BatchIDTTL) * time.Second)
 	}
 
 	backup, err := backup.NewBackup(*c, s, l)
@@ -102,26 +125,119 @@ func main() {
 		backup.Start(ctx)
 	}
 
+	// the restored totals are not written values, the samples are kept from the next write
+	if ms != nil {
+		ms.SetSampleRetention(retention)
+	}
+
+	// measure the storage operations, the names of the server metrics are reserved when they are written
+	reserved := ""
+	if c.SelfMetricsInterval > 0 {
+		reserved = c.SelfMetricsPrefix
+	}
+	s = telemetry.NewStorage(s, reserved)
+
+	// keep the recent values of metrics for the dashboard
+	s = history.NewStorage(s, history.DefaultSize)
+
+	var reporter *telemetry.Reporter
+	if c.SelfMetricsInterval > 0 {
+		reporter = telemetry.NewReporter(*c, telemetry.Default, s, l)
+		reporter.Start(ctx)
+	}
+
+	var statsdListener *statsd.Listener
+	if c.StatsdAddr != "" {
+		statsdListener = statsd.NewListener(*c, s, l)
+		if err := statsdListener.Start(ctx); err != nil {
+			l.Sugar.Panic("error starting statsd listener: ", err, " ", c.StatsdAddr)
+		}
+	}
+
+	var graphiteListener *graphite.Listener
+	if c.GraphiteAddr != "" {
+		graphiteListener, err = graphite.NewListener(*c, s, l)
+		if err != nil {
+			l.Sugar.Panic("error initializing graphite listener: ", err)
+		}
+		if err := graphiteListener.Start(ctx); err != nil {
+			l.Sugar.Panic("error starting graphite listener: ", err, " ", c.GraphiteAddr)
+		}
+	}
+
+	var grpcServer *rpc.Server
+	if c.GRPCAddr != "" {
+		grpcServer = rpc.NewServer(*c, s, l)
+		grpcServer.Batches = batches
+		if err := grpcServer.Start(ctx); err != nil {
+			l.Sugar.Panic("error starting gRPC server: ", err, " ", c.GRPCAddr)
+		}
+	}
+
+	// the server is ready while the storage is reachable and the backup file is writable
+	checks := []health.Check{{Name: "storage", Fn: s.Ping}}
+	if c.DSN == "" {
+		checks = append(checks, health.Check{Name: "backup", Fn: backup.Writable})
+	}
+	probe := health.NewProbe(checks...)
+
+	// there is no write timeout, it would cut off the event streams
 	server := http.Server{
-		Addr: c.Host,
+		Addr:              c.Host,
+		TLSConfig:         c.TLS,
+		ReadHeaderTimeout: time.Duration(c.ReadHeaderTimeout) * time.Second,
+		ReadTimeout:       time.Duration(c.ReadTimeout) * time.Second,
+		IdleTimeout:       time.Duration(c.IdleTimeout) * time.Second,
 		Handler: func(next http.Handler) http.Handler {
 			l.Sugar.Infow("The metrics collection server is running. ", "Server address: ", c.Host)
 			l.Sugar.Infow("Server config", "store interval", c.StoreInterval, "backup file", c.FileStoragePath, "DSN", c.DSN, "KEY", c.Key)
 			return next
-		}(router.NewRouter(s, *c, l)),
+		}(router.NewRouter(s, *c, l, probe, batches)),
 	}
 
-	go server.ListenAndServe()
+	if c.TLS != nil {
+		// the certificate is already loaded into the TLS config
+		go server.ListenAndServeTLS("", "")
+	} else {
+		go server.ListenAndServe()
+	}
 
 	// Wait for the context to be canceled
 	<-ctx.Done()
 
+	// report not ready and end the event streams, give the load balancer time to see it,
+	// then let in-flight requests complete
+	probe.Drain()
+	time.Sleep(time.Duration(c.DrainDelay) * time.Second)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(c.ShutdownTimeout)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		l.Sugar.Infow("error shutting down the server", "error", err)
+	}
+
+	// wait for the gRPC server to finish in-flight calls and for the statsd and
+	// graphite listeners to flush the metrics received so far
+	if grpcServer != nil {
+		grpcServer.Wait()
+	}
+	if statsdListener != nil {
+		statsdListener.Wait()
+	}
+	if graphiteListener != nil {
+		graphiteListener.Wait()
+	}
+	if reporter != nil {
+		reporter.Wait()
+	}
+
+	// all writes are done, take the final backup and close the storage
 	err = backup.Save()
 	if err != nil {
 		l.Sugar.Infow("error saving to backup: ", err, " ", c.FileStoragePath)
 	}
 
-	server.Shutdown(context.Background())
+	s.Close()
 
 	l.Sugar.Infow("The server has been shut down gracefully")
 }
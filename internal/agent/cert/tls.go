@@ -0,0 +1,33 @@
+package cert
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/plasmatrip/metriq/internal/pki"
+)
+
+// TLSConfig builds the client TLS configuration. If a CA bundle is given, only
+// server certificates signed by its CAs are trusted instead of the system roots.
+// If a certificate and a key are given, they are presented to the server.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pool, err := pki.LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{certificate}
+	}
+
+	return cfg, nil
+}
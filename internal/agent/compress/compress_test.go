@@ -3,10 +3,16 @@ package compress
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
 	"testing"
 
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/plasmatrip/metriq/internal/compression"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Uncompress(data []byte) ([]byte, error) {
@@ -51,3 +57,74 @@ func TestCompress(t *testing.T) {
 		})
 	}
 }
+
+func TestEncode(t *testing.T) {
+	data := []byte(`[{"id":"metric","type":"gauge","value":1}]`)
+
+	for _, encoding := range compression.Encodings {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := Encode(encoding, compression.DefaultLevel, data)
+			require.NoError(t, err)
+
+			r, err := compression.NewReader(encoding, bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer r.Close()
+
+			uncompressed, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, uncompressed)
+		})
+	}
+
+	// неизвестная кодировка
+	_, err := Encode("lzma", compression.DefaultLevel, data)
+	assert.ErrorIs(t, err, compression.ErrUnsupported)
+}
+
+// newWriter creates a writer for every batch, as the agent did before the writers were pooled.
+func newWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case compression.Gzip:
+		return gzip.NewWriterLevel(w, gzip.DefaultCompression)
+	case compression.Deflate:
+		return zlib.NewWriterLevel(w, zlib.DefaultCompression)
+	case compression.Brotli:
+		return brotli.NewWriter(w), nil
+	case compression.Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, compression.ErrUnsupported
+}
+
+// BenchmarkEncode compares a writer created for every batch of 1000 metrics with the pooled writers of Encode.
+func BenchmarkEncode(b *testing.B) {
+	data := bytes.Repeat([]byte(`{"id":"metric","type":"gauge","value":12.5},`), 1000)
+
+	for _, encoding := range compression.Encodings {
+		b.Run(encoding+"/new", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				var buf bytes.Buffer
+				w, err := newWriter(encoding, &buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := w.Write(data); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(encoding+"/pooled", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Encode(encoding, compression.DefaultLevel, data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
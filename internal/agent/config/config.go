@@ -12,6 +12,7 @@ package config
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/caarlos0/env/v6"
 	"github.com/plasmatrip/metriq/internal/agent/cert"
+	"github.com/plasmatrip/metriq/internal/compression"
 )
 
 const (
@@ -34,6 +36,18 @@ const (
 	startRetryInterval = time.Second * 1
 	maxRetries         = 3
 	rateLimit          = 5
+
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+
+	SchemeHTTP  = "http"
+	SchemeHTTPS = "https"
+
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf"
+
+	// CompressionAuto - сжатие выбирается по ответу сервера на /api/v1/capabilities
+	CompressionAuto = "auto"
 )
 
 type Config struct {
@@ -45,10 +59,20 @@ type Config struct {
 	RateLimit          int    `env:"RATE_LIMIT"`      // количество одновременно исходящих запросов на сервер
 	CryptoKeyPath      string `env:"CRYPTO_KEY"`      // ауть к сертификату
 	CryptoKey          *rsa.PublicKey
+	Transport          string        `env:"TRANSPORT"`         // протокол отправки метрик на сервер: http или grpc
+	Scheme             string        `env:"SCHEME"`            // схема подключения к серверу: http или https
+	TLSCA              string        `env:"TLS_CA"`            // путь к CA, которому доверяем при проверке сертификата сервера
+	TLSCert            string        `env:"TLS_CERT"`          // путь к клиентскому сертификату для mTLS
+	TLSKey             string        `env:"TLS_KEY"`           // путь к ключу клиентского сертификата
+	APIKey             string        `env:"API_KEY"`           // секрет API-ключа, передается серверу в заголовке Authorization
+	Encoding           string        `env:"ENCODING"`          // кодирование пакета метрик при отправке по http: json или protobuf
+	Compression        string        `env:"COMPRESSION"`       // сжатие метрик при отправке по http: auto, gzip, zstd, br или deflate
+	CompressionLevel   int           `env:"COMPRESSION_LEVEL"` // уровень сжатия метрик, 0 - уровень кодировки по умолчанию
 	ClientTimeout      time.Duration // таймаут для http клиента
 	RetryInterval      time.Duration // увеличиваем интервал в сек между попытками повторной отправки метрик на сервер
 	StartRetryInterval time.Duration // начиниаем повторную отправку через сек
 	MaxRetries         int           // максимальное количество попыток повторной отправки метрик на сервер
+	TLS                *tls.Config   // настройки TLS клиента для схемы https
 }
 
 func NewConfig() (*Config, error) {
@@ -89,6 +113,33 @@ func NewConfig() (*Config, error) {
 	var fCryptoKeyPath string
 	cl.StringVar(&fCryptoKeyPath, "crypto-key", "", "the key for encrypting metrics")
 
+	var fTransport string
+	cl.StringVar(&fTransport, "transport", TransportHTTP, "transport for sending metrics to the server: http or grpc")
+
+	var fScheme string
+	cl.StringVar(&fScheme, "scheme", SchemeHTTP, "scheme for connecting to the server: http or https")
+
+	var fTLSCA string
+	cl.StringVar(&fTLSCA, "tls-ca", "", "path to the CA bundle trusted for the server certificate instead of the system roots")
+
+	var fTLSCert string
+	cl.StringVar(&fTLSCert, "tls-cert", "", "path to the client certificate presented to the server")
+
+	var fTLSKey string
+	cl.StringVar(&fTLSKey, "tls-key", "", "path to the private key of the client certificate")
+
+	var fAPIKey string
+	cl.StringVar(&fAPIKey, "api-key", "", "secret of the API key sent to the server as a bearer token")
+
+	var fEncoding string
+	cl.StringVar(&fEncoding, "encoding", EncodingJSON, "encoding of the metric batches sent over http: json or protobuf")
+
+	var fCompression string
+	cl.StringVar(&fCompression, "compression", CompressionAuto, "compression of the metrics sent over http: gzip, zstd, br, deflate or auto to ask the server")
+
+	var fCompressionLevel int
+	cl.IntVar(&fCompressionLevel, "compression-level", 0, "compression level of the metrics, 0 for the default level of the coding")
+
 	// при ошибке парсинга прокидываем ошибку наверх
 	if err := cl.Parse(os.Args[1:]); err != nil {
 		return nil, fmt.Errorf("failed to parse flags: %w", err)
@@ -135,6 +186,80 @@ func NewConfig() (*Config, error) {
 		cfg.CryptoKeyPath = fCryptoKeyPath
 	}
 
+	if _, exist := os.LookupEnv("TRANSPORT"); !exist {
+		cfg.Transport = fTransport
+	}
+
+	if cfg.Transport != TransportHTTP && cfg.Transport != TransportGRPC {
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+
+	if _, exist := os.LookupEnv("SCHEME"); !exist {
+		cfg.Scheme = fScheme
+	}
+
+	if _, exist := os.LookupEnv("TLS_CA"); !exist {
+		cfg.TLSCA = fTLSCA
+	}
+
+	if _, exist := os.LookupEnv("TLS_CERT"); !exist {
+		cfg.TLSCert = fTLSCert
+	}
+
+	if _, exist := os.LookupEnv("TLS_KEY"); !exist {
+		cfg.TLSKey = fTLSKey
+	}
+
+	if _, exist := os.LookupEnv("API_KEY"); !exist {
+		cfg.APIKey = fAPIKey
+	}
+
+	if _, exist := os.LookupEnv("ENCODING"); !exist {
+		cfg.Encoding = fEncoding
+	}
+
+	if cfg.Encoding != EncodingJSON && cfg.Encoding != EncodingProtobuf {
+		return nil, fmt.Errorf("unknown encoding %q", cfg.Encoding)
+	}
+
+	if _, exist := os.LookupEnv("COMPRESSION"); !exist {
+		cfg.Compression = fCompression
+	}
+
+	if cfg.Compression != CompressionAuto && !compression.Supported(cfg.Compression) {
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	if _, exist := os.LookupEnv("COMPRESSION_LEVEL"); !exist {
+		cfg.CompressionLevel = fCompressionLevel
+	}
+
+	// с auto кодировку выбирает сервер, уровень должен подходить для любой из них
+	encodings := []string{cfg.Compression}
+	if cfg.Compression == CompressionAuto {
+		encodings = compression.Encodings
+	}
+	for _, encoding := range encodings {
+		if err := compression.CheckLevel(encoding, cfg.CompressionLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	switch cfg.Scheme {
+	case SchemeHTTP:
+		if cfg.TLSCA != "" || cfg.TLSCert != "" || cfg.TLSKey != "" {
+			return nil, fmt.Errorf("TLS settings require the %q scheme", SchemeHTTPS)
+		}
+	case SchemeHTTPS:
+		var err error
+		cfg.TLS, err = cert.TLSConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS settings: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown scheme %q", cfg.Scheme)
+	}
+
 	if cfg.CryptoKey != nil {
 		var err error
 		cfg.CryptoKey, err = cert.GetPublicKeyFromCert(cfg.CryptoKeyPath)
@@ -71,6 +71,10 @@ func TestConfig_Agent_NewConfig_env(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -88,6 +92,10 @@ func TestConfig_Agent_NewConfig_env(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -106,6 +114,10 @@ func TestConfig_Agent_NewConfig_env(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -127,6 +139,42 @@ func TestConfig_Agent_NewConfig_env(t *testing.T) {
 			want:    Config{},
 			errWant: true,
 		},
+		{
+			name:    "Unknown scheme",
+			env:     map[string]string{"SCHEME": "ftp"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "TLS settings with http scheme",
+			env:     map[string]string{"TLS_CA": "ca.pem"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "Unknown encoding",
+			env:     map[string]string{"ENCODING": "xml"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "Unknown compression",
+			env:     map[string]string{"COMPRESSION": "lzma"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "Compression level out of range",
+			env:     map[string]string{"COMPRESSION": "gzip", "COMPRESSION_LEVEL": "11"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "Compression level of another coding with auto",
+			env:     map[string]string{"COMPRESSION_LEVEL": "15"},
+			want:    Config{},
+			errWant: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -169,6 +217,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -184,6 +236,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -201,6 +257,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -218,6 +278,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -235,6 +299,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -252,6 +320,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -269,6 +341,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -286,6 +362,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -303,6 +383,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -320,6 +404,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -337,6 +425,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -354,6 +446,10 @@ func TestConfig_Agent_NewConfig_Flags(t *testing.T) {
 				MaxRetries:         3,
 				CryptoKeyPath:      "",
 				CryptoKey:          nil,
+				Transport:          "http",
+				Encoding:           "json",
+				Compression:        "auto",
+				Scheme:             "http",
 			},
 			errWant: false,
 		},
@@ -0,0 +1,31 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// newBatchID returns a random ID of a metric batch. The ID is kept while the
+// delivery of the batch is retried, so that the server applies it only once.
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// retryable reports whether the delivery may be retried: the server is not
+// reachable or has not answered in time. In the latter case the batch may
+// already be applied, the batch ID protects it from being applied twice.
+func retryable(err error) bool {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
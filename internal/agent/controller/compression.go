@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/plasmatrip/metriq/internal/agent/config"
+	"github.com/plasmatrip/metriq/internal/compression"
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+// negotiated is the content coding chosen from the capabilities of the server, it is asked once it answers.
+type negotiated struct {
+	mu     sync.Mutex
+	chosen string
+}
+
+// contentEncoding returns the coding of the metrics sent over http: the configured one or, with auto,
+// the first one the server announces at /api/v1/capabilities that the agent supports. A server
+// without the endpoint gets gzip, every version of it decompresses gzip. While the server is not
+// reachable the metrics are sent with gzip and the capabilities are asked again next time.
+func (c Controller) contentEncoding() string {
+	if c.cfg.Compression == "" {
+		return compression.Gzip
+	}
+	if c.cfg.Compression != config.CompressionAuto {
+		return c.cfg.Compression
+	}
+
+	c.encoding.mu.Lock()
+	defer c.encoding.mu.Unlock()
+
+	if c.encoding.chosen != "" {
+		return c.encoding.chosen
+	}
+
+	encoding, err := c.askEncoding()
+	if err != nil {
+		fmt.Println("failed to get the server capabilities: ", err)
+		return compression.Gzip
+	}
+	c.encoding.chosen = encoding
+
+	return encoding
+}
+
+// askEncoding picks the coding from the capabilities of the server.
+func (c Controller) askEncoding() (string, error) {
+	req, err := http.NewRequest(http.MethodGet, c.url("/api/v1/capabilities"), nil)
+	if err != nil {
+		return "", err
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// сервер прежней версии без этого эндпоинта
+	if resp.StatusCode == http.StatusNotFound {
+		return compression.Gzip, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var caps models.Capabilities
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		return "", err
+	}
+
+	for _, encoding := range caps.ContentEncodings {
+		if compression.Supported(encoding) {
+			return encoding, nil
+		}
+	}
+	return compression.Gzip, nil
+}
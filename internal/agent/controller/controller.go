@@ -4,23 +4,26 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math/rand/v2"
 	"net/http"
 	"runtime"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/plasmatrip/metriq/internal/agent/cert"
 	"github.com/plasmatrip/metriq/internal/agent/compress"
 	"github.com/plasmatrip/metriq/internal/agent/config"
+	"github.com/plasmatrip/metriq/internal/codec"
 	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
 	"github.com/plasmatrip/metriq/internal/storage"
 	"github.com/plasmatrip/metriq/internal/types"
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Result struct {
@@ -28,25 +31,73 @@ type Result struct {
 }
 
 type Controller struct {
-	Repo    storage.Repository
-	Client  http.Client
-	cfg     config.Config
-	Works   chan func() error
-	Results chan Result
+	Repo   storage.Repository
+	Client http.Client
+	RPC    pb.MetricsClient
+	conn   *grpc.ClientConn
+	rpcErr error
+	realIP string
+	cfg    config.Config
+	// encoding - кодировка сжатия, выбранная по возможностям сервера
+	encoding *negotiated
+	Works    chan func() error
+	Results  chan Result
 }
 
 // NewController creates a new Controller instance. It takes a Repository and a
 // Config as arguments, and returns a pointer to a new Controller. The returned
 // Controller is initialized with the provided Repository and Config, and has
-// channels for worker functions and results.
+// channels for worker functions and results. If the gRPC transport is
+// configured, the Controller also gets a gRPC client for the server address.
+// The address of the outbound interface is resolved once and reported to the
+// server in the X-Real-IP header of every request. With the https scheme both
+// the HTTP and the gRPC clients use the TLS settings of the configuration.
+// The compression is negotiated with the server on the first send.
 func NewController(repo storage.Repository, cfg config.Config) *Controller {
-	return &Controller{
-		Repo:    repo,
-		Client:  http.Client{Timeout: cfg.ClientTimeout},
-		cfg:     cfg,
-		Works:   make(chan func() error),
-		Results: make(chan Result),
+	c := &Controller{
+		Repo:     repo,
+		Client:   http.Client{Timeout: cfg.ClientTimeout},
+		cfg:      cfg,
+		encoding: &negotiated{},
+		Works:    make(chan func() error),
+		Results:  make(chan Result),
 	}
+
+	creds := insecure.NewCredentials()
+	if cfg.TLS != nil {
+		// клон сохраняет прокси, таймауты и пул соединений транспорта по умолчанию
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg.TLS
+		c.Client.Transport = transport
+		creds = credentials.NewTLS(cfg.TLS)
+	}
+
+	realIP, err := OutboundIP(cfg.Host)
+	if err != nil {
+		fmt.Println("failed to get the outbound interface address: ", err)
+	}
+	c.realIP = realIP
+
+	if cfg.Transport == config.TransportGRPC {
+		conn, err := grpc.NewClient(cfg.Host, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			c.rpcErr = fmt.Errorf("failed to create gRPC client: %w", err)
+		} else {
+			c.conn = conn
+			c.RPC = pb.NewMetricsClient(conn)
+		}
+	}
+
+	return c
+}
+
+// Close closes the connection of the gRPC client, if there is one. It is called
+// once the workers have stopped.
+func (c Controller) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
 }
 
 // SendMetricsWorker starts a goroutine that runs until the given context is
@@ -77,9 +128,12 @@ func (c Controller) SendMetricsWorker(ctx context.Context, wg *sync.WaitGroup, i
 
 // SendMetricsBatch retrieves metrics from the repository, converts them to the
 // models.Metrics format, compresses them, and sends them to the server via a POST
-// request. It handles request retries in case of a connection failure. If a key is
+// request. It handles request retries in case of a connection failure or a timeout,
+// the batch ID sent in the X-Batch-ID header is kept across the retries, so that
+// the server does not apply the batch twice. If a key is
 // present in the configuration, it hashes the request body before sending. Returns
-// an error if any step fails, or nil if the operation succeeds.
+// an error if any step fails, or nil if the operation succeeds. With the gRPC
+// transport configured the batch is sent with SendMetricsGRPC instead.
 func (c Controller) SendMetricsBatch() error {
 	metrics, err := c.Repo.Metrics(context.Background())
 	if len(metrics) == 0 {
@@ -96,14 +150,23 @@ func (c Controller) SendMetricsBatch() error {
 		sMetrics = append(sMetrics, metric.Convert(mName))
 	}
 
-	// marshal data
-	data, err := json.Marshal(sMetrics)
+	if c.cfg.Transport == config.TransportGRPC {
+		return c.SendMetricsGRPC(sMetrics)
+	}
+
+	// marshal data, JSON unless the compact protobuf encoding is configured
+	contentType := codec.JSON
+	if c.cfg.Encoding == config.EncodingProtobuf {
+		contentType = codec.Protobuf
+	}
+	data, err := codec.Marshal(contentType, sMetrics)
 	if err != nil {
 		return err
 	}
 
 	// compress data
-	data, err = compress.Compress(data)
+	encoding := c.contentEncoding()
+	data, err = compress.Encode(encoding, c.cfg.CompressionLevel, data)
 	if err != nil {
 		return err
 	}
@@ -117,13 +180,26 @@ func (c Controller) SendMetricsBatch() error {
 	}
 
 	// create request
-	req, err := http.NewRequest(http.MethodPost, "http://"+c.cfg.Host+"/updates", bytes.NewReader(data))
+	req, err := http.NewRequest(http.MethodPost, c.url("/updates"), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	// the ID stays the same while the request is retried
+	batchID, err := newBatchID()
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Content-Encoding", "application/gzip")
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", encoding)
+	req.Header.Set("X-Batch-ID", batchID)
+	if c.realIP != "" {
+		req.Header.Set("X-Real-IP", c.realIP)
+	}
+	if c.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+	}
 
 	// if there is a key, hash the request body
 	if len(c.cfg.Key) > 0 {
@@ -146,10 +222,14 @@ func (c Controller) SendMetricsBatch() error {
 	wait := c.cfg.StartRetryInterval
 	for {
 		resp, err := c.Client.Do(req)
-		if errors.Is(err, syscall.ECONNREFUSED) && retryCount < c.cfg.MaxRetries {
+		if retryable(err) && retryCount < c.cfg.MaxRetries {
 			time.Sleep(wait)
 			retryCount++
 			wait += c.cfg.RetryInterval
+			// the failed attempt may have read the body
+			if req.Body, err = req.GetBody(); err != nil {
+				return err
+			}
 			continue
 		}
 		if err != nil {
@@ -174,7 +254,8 @@ func (c Controller) SendMetrics() error {
 		}
 
 		// compress data
-		data, err = compress.Compress(data)
+		encoding := c.contentEncoding()
+		data, err = compress.Encode(encoding, c.cfg.CompressionLevel, data)
 		if err != nil {
 			return err
 		}
@@ -188,13 +269,19 @@ func (c Controller) SendMetrics() error {
 		}
 
 		// create request
-		req, err := http.NewRequest(http.MethodPost, "http://"+c.cfg.Host+"/update", bytes.NewReader(data))
+		req, err := http.NewRequest(http.MethodPost, c.url("/update"), bytes.NewReader(data))
 		if err != nil {
 			return err
 		}
 
 		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("Content-Encoding", "application/gzip")
+		req.Header.Set("Content-Encoding", encoding)
+		if c.realIP != "" {
+			req.Header.Set("X-Real-IP", c.realIP)
+		}
+		if c.cfg.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.cfg.APIKey)
+		}
 
 		resp, err := c.Client.Do(req)
 		if err != nil {
@@ -205,6 +292,15 @@ func (c Controller) SendMetrics() error {
 	return nil
 }
 
+// url returns the address of the server endpoint with the configured scheme, http by default.
+func (c Controller) url(path string) string {
+	scheme := c.cfg.Scheme
+	if scheme == "" {
+		scheme = config.SchemeHTTP
+	}
+	return scheme + "://" + c.cfg.Host + path
+}
+
 func (c Controller) UpdateMetrics(ctx context.Context) {
 	var rtm runtime.MemStats
 	runtime.ReadMemStats(&rtm)
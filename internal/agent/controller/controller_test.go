@@ -1,17 +1,35 @@
 package controller
 
 import (
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/plasmatrip/metriq/internal/agent/config"
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/compression"
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	serverConfig "github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/router"
+	"github.com/plasmatrip/metriq/internal/server/rpc"
 	"github.com/plasmatrip/metriq/internal/storage/mem"
 	"github.com/plasmatrip/metriq/internal/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type MockStorage struct {
@@ -30,13 +48,14 @@ func NewMockStorage() *MockStorage {
 func TestService_SendMetrics(t *testing.T) {
 	ctx := context.Background()
 	mock := NewMockStorage()
-	mock.SetMetric(ctx, "metric", types.Metric{MetricType: types.Gauge, Value: 100})
-	mock.SetMetric(ctx, "counter", types.Metric{MetricType: types.Counter, Value: 100})
+	mock.SetMetric(ctx, "metric", types.Metric{MetricType: types.Gauge, Value: float64(100)})
+	mock.SetMetric(ctx, "counter", types.Metric{MetricType: types.Counter, Value: int64(100)})
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		assert.Equal(t, http.MethodPost, r.Method, "Only POST requests are allowed!")
 		assert.Equal(t, r.Header.Get("Content-Type"), "application/json")
-		assert.Equal(t, r.Header.Get("Content-Encoding"), "application/gzip")
+		assert.Equal(t, r.Header.Get("Content-Encoding"), "gzip")
+		assert.Equal(t, "127.0.0.1", r.Header.Get("X-Real-IP"))
 		w.WriteHeader(http.StatusOK)
 	}))
 
@@ -56,6 +75,228 @@ func TestService_SendMetrics(t *testing.T) {
 	})
 }
 
+func TestService_SendMetricsBatchRetry(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockStorage()
+	mock.SetMetric(ctx, "counter", types.Metric{MetricType: types.Counter, Value: int64(100)})
+
+	var (
+		mu       sync.Mutex
+		batchIDs []string
+		bodies   [][]byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+
+		mu.Lock()
+		batchIDs = append(batchIDs, r.Header.Get("X-Batch-ID"))
+		bodies = append(bodies, body)
+		attempt := len(batchIDs)
+		mu.Unlock()
+
+		// первый ответ не успевает до таймаута клиента
+		if attempt == 1 {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	controller := NewController(mock, config.Config{
+		Host:               strings.Split(server.URL, "//")[1],
+		MaxRetries:         1,
+		StartRetryInterval: 10 * time.Millisecond,
+	})
+	controller.Client = *server.Client()
+	controller.Client.Timeout = 50 * time.Millisecond
+
+	require.NoError(t, controller.SendMetricsBatch())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, batchIDs, 2)
+	assert.NotEmpty(t, batchIDs[0])
+	assert.Equal(t, batchIDs[0], batchIDs[1], "the batch ID is kept across retries")
+	assert.Equal(t, bodies[0], bodies[1], "the body is sent again")
+}
+
+func TestService_SendMetricsBatchProtobuf(t *testing.T) {
+	ctx := context.Background()
+	mock := NewMockStorage()
+	mock.SetMetric(ctx, "counter", types.Metric{MetricType: types.Counter, Value: int64(100)})
+
+	var (
+		contentType string
+		metrics     []models.Metrics
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		assert.NoError(t, codec.Decode(codec.ForContentType(contentType), zr, &metrics))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	controller := NewController(mock, config.Config{
+		Host:     strings.Split(server.URL, "//")[1],
+		Encoding: config.EncodingProtobuf,
+	})
+	controller.Client = *server.Client()
+
+	require.NoError(t, controller.SendMetricsBatch())
+
+	assert.Equal(t, codec.Protobuf, contentType)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "counter", metrics[0].ID)
+	assert.Equal(t, int64(100), *metrics[0].Delta)
+}
+
+func TestService_SendMetricsBatchCompression(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	mock := NewMockStorage()
+	mock.SetMetric(ctx, "counter", types.Metric{MetricType: types.Counter, Value: int64(100)})
+
+	t.Run("Negotiated with the server", func(t *testing.T) {
+		stor := mem.NewStorage()
+		var encodings []string
+		r := router.NewRouter(stor, serverConfig.Config{}, log, nil, nil)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method == http.MethodPost {
+				encodings = append(encodings, req.Header.Get("Content-Encoding"))
+			}
+			r.ServeHTTP(w, req)
+		}))
+		defer server.Close()
+
+		controller := NewController(mock, config.Config{
+			Host:        strings.Split(server.URL, "//")[1],
+			Compression: config.CompressionAuto,
+		})
+		controller.Client = *server.Client()
+
+		require.NoError(t, controller.SendMetricsBatch())
+		require.NoError(t, controller.SendMetricsBatch())
+
+		// сервер предпочитает zstd, возможности запрашиваются один раз
+		assert.Equal(t, []string{compression.Zstd, compression.Zstd}, encodings)
+		metric, err := stor.Metric(ctx, types.Counter, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, int64(200), metric.Value)
+	})
+
+	t.Run("Server without capabilities", func(t *testing.T) {
+		var capabilities int
+		var encoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/capabilities" {
+				capabilities++
+				http.NotFound(w, r)
+				return
+			}
+			encoding = r.Header.Get("Content-Encoding")
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		controller := NewController(mock, config.Config{
+			Host:        strings.Split(server.URL, "//")[1],
+			Compression: config.CompressionAuto,
+		})
+		controller.Client = *server.Client()
+
+		require.NoError(t, controller.SendMetricsBatch())
+		require.NoError(t, controller.SendMetricsBatch())
+
+		assert.Equal(t, compression.Gzip, encoding)
+		assert.Equal(t, 1, capabilities)
+	})
+
+	t.Run("Configured compression", func(t *testing.T) {
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NotEqual(t, "/api/v1/capabilities", r.URL.Path)
+			assert.Equal(t, compression.Brotli, r.Header.Get("Content-Encoding"))
+			zr, err := compression.NewReader(compression.Brotli, r.Body)
+			require.NoError(t, err)
+			body, err = io.ReadAll(zr)
+			require.NoError(t, err)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		controller := NewController(mock, config.Config{
+			Host:        strings.Split(server.URL, "//")[1],
+			Compression: compression.Brotli,
+		})
+		controller.Client = *server.Client()
+
+		require.NoError(t, controller.SendMetricsBatch())
+		assert.Contains(t, string(body), `"id":"counter"`)
+	})
+}
+
+func TestService_SendMetricsGRPC(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	stor := mem.NewStorage()
+	srv := rpc.NewServer(serverConfig.Config{Key: "secret"}, stor, log)
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	mock := NewMockStorage()
+	// more metrics than fit into one message, so the batch goes over the stream
+	for i := 0; i < grpcChunkSize+1; i++ {
+		mock.SetMetric(ctx, fmt.Sprintf("counter%d", i), types.Metric{MetricType: types.Counter, Value: int64(i)})
+	}
+
+	controller := NewController(mock, config.Config{Transport: config.TransportGRPC, Key: "secret", ClientTimeout: time.Second})
+	controller.RPC = pb.NewMetricsClient(conn)
+
+	t.Run("Send metrics over stream", func(t *testing.T) {
+		require.NoError(t, controller.SendMetricsBatch())
+		metrics, err := stor.Metrics(ctx)
+		require.NoError(t, err)
+		assert.Len(t, metrics, grpcChunkSize+1)
+	})
+
+	t.Run("Send metrics in one call", func(t *testing.T) {
+		require.NoError(t, controller.SendMetricsGRPC([]models.Metrics{types.Metric{MetricType: types.Counter, Value: int64(5)}.Convert("counter1")}))
+		metric, err := stor.Metric(ctx, types.Counter, "counter1")
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), metric.Value)
+	})
+
+	t.Run("Wrong key", func(t *testing.T) {
+		wrong := NewController(mock, config.Config{Transport: config.TransportGRPC, Key: "wrong", ClientTimeout: time.Second})
+		wrong.RPC = pb.NewMetricsClient(conn)
+		assert.Error(t, wrong.SendMetricsBatch())
+		assert.NoError(t, wrong.Close())
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		require.NoError(t, controller.Close())
+		// повторное закрытие соединения - ошибка, значит оно было закрыто
+		assert.Error(t, controller.Close())
+		// без gRPC закрывать нечего
+		assert.NoError(t, NewController(mock, config.Config{}).Close())
+	})
+}
+
 func TestService_UpdateMetrics(t *testing.T) {
 	mock := NewMockStorage()
 
@@ -0,0 +1,123 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/plasmatrip/metriq/internal/agent/cert"
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+)
+
+// grpcChunkSize is the maximum number of metrics in one gRPC message,
+// larger batches are sent over the StreamMetrics client stream.
+const grpcChunkSize = 100
+
+// SendMetricsGRPC sends the metrics to the server over gRPC. A batch that fits
+// into one message is sent with the unary UpdateMetrics call, larger batches are
+// split into chunks and sent with StreamMetrics. Every message is encrypted and
+// signed the same way as the HTTP request body, the outbound address is sent in
+// the x-real-ip metadata and the API key in the authorization metadata. Calls
+// are retried while the server is unavailable or does not answer in time, with
+// the same batch ID in the x-batch-id metadata.
+func (c Controller) SendMetricsGRPC(metrics []models.Metrics) error {
+	if c.RPC == nil {
+		return c.rpcErr
+	}
+
+	reqs := make([]*pb.UpdateMetricsRequest, 0, len(metrics)/grpcChunkSize+1)
+	for start := 0; start < len(metrics); start += grpcChunkSize {
+		end := min(start+grpcChunkSize, len(metrics))
+
+		req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, end-start)}
+		for _, m := range metrics[start:end] {
+			req.Metrics = append(req.Metrics, pb.FromModel(m))
+		}
+
+		if err := c.secure(req); err != nil {
+			return err
+		}
+		reqs = append(reqs, req)
+	}
+
+	send := func(ctx context.Context) error {
+		if len(reqs) == 1 {
+			_, err := c.RPC.UpdateMetrics(ctx, reqs[0])
+			return err
+		}
+
+		stream, err := c.RPC.StreamMetrics(ctx)
+		if err != nil {
+			return err
+		}
+		for _, req := range reqs {
+			if err := stream.Send(req); err != nil {
+				return err
+			}
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// the ID stays the same while the call is retried
+	batchID, err := newBatchID()
+	if err != nil {
+		return err
+	}
+
+	// in a loop, try to send metrics to the server
+	// number of attempts, interval in seconds between attempts is configured
+	retryCount := 0
+	wait := c.cfg.StartRetryInterval
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.ClientTimeout)
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-batch-id", batchID)
+		if c.realIP != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", c.realIP)
+		}
+		if c.cfg.APIKey != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.cfg.APIKey)
+		}
+		err := send(ctx)
+		cancel()
+		code := status.Code(err)
+		if (code == codes.Unavailable || code == codes.DeadlineExceeded) && retryCount < c.cfg.MaxRetries {
+			time.Sleep(wait)
+			retryCount++
+			wait += c.cfg.RetryInterval
+			continue
+		}
+		return err
+	}
+}
+
+// secure encrypts the metrics of the request and signs it, if the keys are configured.
+func (c Controller) secure(req *pb.UpdateMetricsRequest) error {
+	if c.cfg.CryptoKey != nil {
+		data, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+
+		req.Encrypted, err = cert.EncryptData(data, c.cfg.CryptoKey)
+		if err != nil {
+			return err
+		}
+		req.Metrics = nil
+	}
+
+	if len(c.cfg.Key) > 0 {
+		hash, err := pb.Sum(req, c.cfg.Key)
+		if err != nil {
+			return err
+		}
+		req.Hash = hash
+	}
+
+	return nil
+}
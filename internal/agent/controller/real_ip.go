@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"net"
+)
+
+// OutboundIP returns the address of the local interface used to reach the given
+// host. Dialing UDP sends no packets, it only selects a route and a source address.
+func OutboundIP(host string) (string, error) {
+	conn, err := net.Dial("udp", host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	addr, err := net.ResolveUDPAddr("udp", conn.LocalAddr().String())
+	if err != nil {
+		return "", err
+	}
+
+	return addr.IP.String(), nil
+}
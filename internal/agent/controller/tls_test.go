@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	agentCert "github.com/plasmatrip/metriq/internal/agent/cert"
+	"github.com/plasmatrip/metriq/internal/agent/config"
+	serverCert "github.com/plasmatrip/metriq/internal/server/cert"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+// newTestCA generates a self-signed CA and writes its certificate to the directory.
+func newTestCA(t *testing.T, dir, name string) testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	file := filepath.Join(dir, name+".pem")
+	writePEM(t, file, "CERTIFICATE", der)
+
+	return testCA{cert: cert, key: key, file: file}
+}
+
+// issue generates a certificate for 127.0.0.1 signed by the CA and returns the paths to the certificate and the key.
+func (ca testCA) issue(t *testing.T, dir, name string, usage x509.ExtKeyUsage) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+}
+
+func TestService_SendMetricsTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+	serverCertFile, serverKeyFile := ca.issue(t, dir, "server", x509.ExtKeyUsageServerAuth)
+	clientCertFile, clientKeyFile := ca.issue(t, dir, "agent", x509.ExtKeyUsageClientAuth)
+	foreignCertFile, foreignKeyFile := otherCA.issue(t, dir, "foreign", x509.ExtKeyUsageClientAuth)
+
+	tlsConfig, err := serverCert.TLSConfig(serverCertFile, serverKeyFile, ca.file)
+	require.NoError(t, err)
+
+	var clients []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients = append(clients, r.TLS.PeerCertificates[0].Subject.CommonName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	mock := NewMockStorage()
+	mock.SetMetric(context.Background(), "metric", types.Metric{MetricType: types.Gauge, Value: float64(100)})
+
+	tests := []struct {
+		name    string
+		caFile  string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{name: "Pinned CA with client certificate", caFile: ca.file, cert: clientCertFile, key: clientKeyFile},
+		{name: "No client certificate", caFile: ca.file, wantErr: true},
+		{name: "Client certificate of another CA", caFile: ca.file, cert: foreignCertFile, key: foreignKeyFile, wantErr: true},
+		{name: "Server certificate of an unpinned CA", caFile: otherCA.file, cert: clientCertFile, key: clientKeyFile, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientTLS, err := agentCert.TLSConfig(test.caFile, test.cert, test.key)
+			require.NoError(t, err)
+
+			controller := NewController(mock, config.Config{
+				Host:          strings.TrimPrefix(server.URL, "https://"),
+				Scheme:        config.SchemeHTTPS,
+				TLS:           clientTLS,
+				ClientTimeout: time.Second,
+			})
+			// транспорт по умолчанию сохраняет прокси из окружения
+			transport, ok := controller.Client.Transport.(*http.Transport)
+			require.True(t, ok)
+			assert.NotNil(t, transport.Proxy)
+
+			err = controller.SendMetricsBatch()
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	assert.Equal(t, []string{"agent"}, clients)
+}
@@ -3,6 +3,7 @@ package backup
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -11,6 +12,7 @@ import (
 	"github.com/plasmatrip/metriq/internal/logger"
 	"github.com/plasmatrip/metriq/internal/models"
 	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/storage"
 	"github.com/plasmatrip/metriq/internal/types"
 )
@@ -44,17 +46,19 @@ func (bkp Backup) Start(ctx context.Context) {
 	}
 
 	if bkp.cfg.StoreInterval == 0 {
+		// a pending signal is enough for any number of writes, the storage does not wait to send it
+		c := make(chan struct{}, 1)
+		bkp.stor.SetBackup(c)
 		go func() {
-			c := make(chan struct{})
-			bkp.stor.SetBackup(c)
-		BACKUPER:
 			for {
 				select {
 				case <-c:
-					bkp.Save()
+					if err := bkp.Save(); err != nil {
+						bkp.lg.Sugar.Infow("error saving to backup: ", err)
+					}
 				case <-ctx.Done():
-					bkp.Save()
-					break BACKUPER
+					// the writes after it are saved by the final backup on shutdown
+					return
 				}
 			}
 		}()
@@ -73,7 +77,25 @@ func (bkp Backup) Start(ctx context.Context) {
 
 }
 
+// Save writes all metrics to the backup file, its duration and the size of the file are recorded in the server metrics.
 func (bkp Backup) Save() error {
+	start := time.Now()
+
+	err := bkp.save()
+	if err != nil {
+		telemetry.BackupErrors.Inc()
+		return err
+	}
+
+	telemetry.BackupDuration.Observe(time.Since(start).Seconds())
+	if info, err := os.Stat(bkp.cfg.FileStoragePath); err == nil {
+		telemetry.BackupSize.Set(float64(info.Size()))
+	}
+
+	return nil
+}
+
+func (bkp Backup) save() error {
 	file, err := os.OpenFile(bkp.cfg.FileStoragePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
@@ -88,8 +110,8 @@ func (bkp Backup) Save() error {
 		return err
 	}
 
-	for mName, metric := range metrics {
-		err := encoder.Encode(metric.Convert(mName))
+	for key, metric := range metrics {
+		err := encoder.Encode(metric.Convert(types.KeyName(bkp.cfg.TypeConflict, key, metric.MetricType)))
 		if err != nil {
 			return err
 		}
@@ -98,6 +120,17 @@ func (bkp Backup) Save() error {
 	return nil
 }
 
+// Writable checks that the backup file can be written by creating a temporary file next to it.
+func (bkp Backup) Writable(_ context.Context) error {
+	file, err := os.CreateTemp(filepath.Dir(bkp.cfg.FileStoragePath), ".probe-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
 func (bkp Backup) load() error {
 	var jMetric models.Metrics
 	var value any
@@ -110,6 +143,9 @@ func (bkp Backup) load() error {
 
 	decoder := json.NewDecoder(file)
 
+	// копия хранит имена без типа, тип каждого восстановленного имени нужен для проверки политики
+	loaded := make(map[string]string)
+
 	for {
 		if err := decoder.Decode(&jMetric); err == io.EOF {
 			break
@@ -126,7 +162,14 @@ func (bkp Backup) load() error {
 
 		bkp.lg.Sugar.Infow("load value", "value", value, "type", jMetric.MType, "name", jMetric.ID)
 
-		if err := bkp.stor.SetMetric(context.Background(), jMetric.ID, types.Metric{MetricType: jMetric.MType, Value: value}); err != nil {
+		// копия, сохраненная с отдельными именами для каждого типа, не восстанавливается в одно имя
+		if mType, ok := loaded[jMetric.ID]; ok && mType != jMetric.MType && bkp.cfg.TypeConflict != types.ConflictNamespace {
+			return fmt.Errorf("%w: %s is stored as a gauge and a counter, the %s policy can't keep both", types.ErrTypeConflict, jMetric.ID, bkp.cfg.TypeConflict)
+		}
+		loaded[jMetric.ID] = jMetric.MType
+
+		err := bkp.stor.SetMetric(context.Background(), jMetric.ID, types.Metric{MetricType: jMetric.MType, Value: value})
+		if err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,136 @@
+// Package codec encodes batches of metrics for the /updates endpoints. JSON is
+// the default, protobuf is the compact binary alternative: the batch is the
+// UpdateMetricsRequest message of the gRPC API with the metrics field set.
+// NDJSON, a metric per line, is meant for very large uploads: the handlers read
+// it with Stream and apply the metrics in chunks as they arrive. The encoding
+// is chosen by the Content-Type header of the request. The JSON metrics are
+// decoded strictly: a field models.Metrics does not have is an error.
+package codec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	JSON     = "application/json"
+	Protobuf = "application/x-protobuf"
+	NDJSON   = "application/x-ndjson"
+)
+
+// ErrUnsupported is returned for an unknown codec.
+var ErrUnsupported = errors.New("unsupported codec")
+
+// ForContentType returns the codec of the Content-Type header: protobuf and NDJSON for their media
+// types and JSON for any other, the endpoints have always decoded the body as JSON regardless of the header.
+func ForContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return JSON
+	}
+
+	switch mediaType {
+	case Protobuf, "application/protobuf":
+		return Protobuf
+	case NDJSON, "application/ndjson", "application/jsonl":
+		return NDJSON
+	}
+	return JSON
+}
+
+// Name is the short name of the codec for logs and metrics.
+func Name(codec string) string {
+	switch codec {
+	case Protobuf:
+		return "protobuf"
+	case NDJSON:
+		return "ndjson"
+	}
+	return "json"
+}
+
+// Marshal encodes the batch.
+func Marshal(codec string, metrics []models.Metrics) ([]byte, error) {
+	switch codec {
+	case JSON:
+		return json.Marshal(metrics)
+	case Protobuf:
+		req := &pb.UpdateMetricsRequest{Metrics: make([]*pb.Metric, 0, len(metrics))}
+		for _, m := range metrics {
+			req.Metrics = append(req.Metrics, pb.FromModel(m))
+		}
+		return proto.Marshal(req)
+	case NDJSON:
+		return marshalNDJSON(metrics)
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, codec)
+}
+
+// Decode reads the batch from the reader into dst. Protobuf metrics are not checked,
+// a metric of an unspecified type is decoded without the type.
+func Decode(codec string, r io.Reader, dst *[]models.Metrics) error {
+	switch codec {
+	case JSON:
+		return decodeJSON(r, dst)
+	case Protobuf:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		var req pb.UpdateMetricsRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			return err
+		}
+
+		metrics := (*dst)[:0]
+		for _, m := range req.GetMetrics() {
+			metrics = append(metrics, m.Model())
+		}
+		*dst = metrics
+		return nil
+	case NDJSON:
+		return decodeNDJSON(r, dst)
+	}
+
+	return fmt.Errorf("%w: %s", ErrUnsupported, codec)
+}
+
+// DecodeMetric reads a single JSON metric from the reader.
+func DecodeMetric(r io.Reader, m *models.Metrics) error {
+	return decodeJSON(r, m)
+}
+
+// decodeJSON decodes the JSON strictly, the error of an unknown field is *types.FieldError.
+func decodeJSON(r io.Reader, v any) error {
+	return unknownField(strictDecoder(r).Decode(v))
+}
+
+func strictDecoder(r io.Reader) *json.Decoder {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	return dec
+}
+
+// unknownField describes the field of the error of a field models.Metrics does not have,
+// encoding/json reports it only in the text of the error.
+func unknownField(err error) error {
+	if err == nil {
+		return nil
+	}
+	if field, ok := strings.CutPrefix(err.Error(), `json: unknown field "`); ok {
+		return &types.FieldError{Field: strings.TrimSuffix(field, `"`), Message: "unknown field"}
+	}
+	return err
+}
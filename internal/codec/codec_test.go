@@ -0,0 +1,182 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	"github.com/plasmatrip/metriq/internal/types"
+	"google.golang.org/protobuf/proto"
+)
+
+// batch returns n metrics like the ones the agent sends: mostly runtime gauges and a few counters.
+func batch(n int) []models.Metrics {
+	metrics := make([]models.Metrics, 0, n)
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("RuntimeMetric%d", i)
+		if i%10 == 0 {
+			delta := int64(i * 7)
+			metrics = append(metrics, models.Metrics{ID: id, MType: types.Counter, Delta: &delta})
+			continue
+		}
+		value := float64(i) * 1234.5678
+		metrics = append(metrics, models.Metrics{ID: id, MType: types.Gauge, Value: &value})
+	}
+	return metrics
+}
+
+func TestForContentType(t *testing.T) {
+	assert.Equal(t, JSON, ForContentType(""))
+	assert.Equal(t, JSON, ForContentType("application/json; charset=utf-8"))
+	assert.Equal(t, JSON, ForContentType("text/plain"))
+	assert.Equal(t, Protobuf, ForContentType("application/x-protobuf"))
+	assert.Equal(t, Protobuf, ForContentType("application/protobuf"))
+	assert.Equal(t, NDJSON, ForContentType("application/x-ndjson"))
+	assert.Equal(t, NDJSON, ForContentType("application/ndjson; charset=utf-8"))
+}
+
+func TestRoundTrip(t *testing.T) {
+	metrics := batch(20)
+
+	for _, codec := range []string{JSON, Protobuf, NDJSON} {
+		data, err := Marshal(codec, metrics)
+		require.NoError(t, err)
+
+		var decoded []models.Metrics
+		require.NoError(t, Decode(codec, bytes.NewReader(data), &decoded))
+		assert.Equal(t, metrics, decoded, Name(codec))
+	}
+
+	_, err := Marshal("application/xml", metrics)
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
+
+func TestDecode_UnspecifiedType(t *testing.T) {
+	data, err := proto.Marshal(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "broken"}}})
+	require.NoError(t, err)
+
+	var decoded []models.Metrics
+	require.NoError(t, Decode(Protobuf, bytes.NewReader(data), &decoded))
+	assert.Equal(t, []models.Metrics{{ID: "broken"}}, decoded)
+
+	assert.Error(t, Decode(Protobuf, bytes.NewReader([]byte{0xff}), &decoded))
+}
+
+func TestDecode_UnknownField(t *testing.T) {
+	body := `[{"id":"load","type":"gauge","value":1.5,"unit":"s"}]`
+
+	var decoded []models.Metrics
+	err := Decode(JSON, strings.NewReader(body), &decoded)
+	var fieldErr *types.FieldError
+	require.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "unit", fieldErr.Field)
+
+	var m models.Metrics
+	err = DecodeMetric(strings.NewReader(`{"id":"load","type":"gauge","value":1.5,"unit":"s"}`), &m)
+	require.ErrorAs(t, err, &fieldErr)
+
+	s := NewStream(strings.NewReader("{\"id\":\"load\",\"unit\":\"s\"}\n{\"id\":\"load\"} {}\n"))
+	var lineErr *LineError
+	require.ErrorAs(t, s.Next(&m), &lineErr)
+	assert.ErrorAs(t, lineErr, &fieldErr)
+	assert.ErrorAs(t, s.Next(&m), &lineErr, "data after the metric")
+}
+
+func TestStream(t *testing.T) {
+	body := strings.Join([]string{
+		`{"id":"first","type":"gauge","value":1.5}`,
+		``,
+		`{"id":"broken",`,
+		`{"id":"long","type":"gauge","value":1,"pad":"` + strings.Repeat("x", MaxLineLength) + `"}`,
+		`  {"id":"last","type":"counter","delta":3}  `,
+	}, "\n")
+
+	s := NewStream(strings.NewReader(body))
+	var m models.Metrics
+
+	require.NoError(t, s.Next(&m))
+	assert.Equal(t, "first", m.ID)
+	assert.Equal(t, 0, s.Index())
+
+	// пустая строка пропускается, испорченная строка не прерывает поток
+	var lineErr *LineError
+	require.ErrorAs(t, s.Next(&m), &lineErr)
+	assert.Equal(t, 3, lineErr.Line)
+	assert.Equal(t, 1, s.Index())
+
+	require.ErrorAs(t, s.Next(&m), &lineErr)
+	assert.Equal(t, 4, lineErr.Line)
+	assert.ErrorIs(t, lineErr, ErrLineTooLong)
+
+	// последняя строка без перевода строки
+	require.NoError(t, s.Next(&m))
+	assert.Equal(t, "last", m.ID)
+	assert.Nil(t, m.Value)
+	assert.Equal(t, int64(3), *m.Delta)
+	assert.Equal(t, 3, s.Index())
+
+	assert.ErrorIs(t, s.Next(&m), io.EOF)
+
+	var decoded []models.Metrics
+	assert.Error(t, Decode(NDJSON, strings.NewReader(body), &decoded))
+}
+
+// The benchmarks encode and decode a batch of 1000 metrics and report the size of
+// the payload before and after the gzip compression the agent applies.
+func BenchmarkMarshal(b *testing.B) {
+	metrics := batch(1000)
+
+	for _, codec := range []string{JSON, Protobuf} {
+		b.Run(Name(codec), func(b *testing.B) {
+			var data []byte
+			var err error
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if data, err = Marshal(codec, metrics); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			b.StopTimer()
+			b.ReportMetric(float64(len(data)), "payload-bytes")
+			b.ReportMetric(float64(gzipped(b, data)), "gzip-bytes")
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	metrics := batch(1000)
+
+	for _, codec := range []string{JSON, Protobuf, NDJSON} {
+		b.Run(Name(codec), func(b *testing.B) {
+			data, err := Marshal(codec, metrics)
+			require.NoError(b, err)
+
+			var decoded []models.Metrics
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := Decode(codec, bytes.NewReader(data), &decoded); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func gzipped(b *testing.B, data []byte) int {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	require.NoError(b, err)
+	require.NoError(b, zw.Close())
+	return buf.Len()
+}
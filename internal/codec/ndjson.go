@@ -0,0 +1,138 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+// MaxLineLength - максимальная длина строки NDJSON с одной метрикой
+const MaxLineLength = 64 << 10
+
+// ErrLineTooLong is reported for a line of an NDJSON body longer than MaxLineLength.
+var ErrLineTooLong = errors.New("the line is too long")
+
+// LineError is an NDJSON line that is not a metric, the stream can go on after it.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Stream decodes the metrics of an NDJSON body one line at a time, the memory it needs
+// is bounded by MaxLineLength however long the body is.
+type Stream struct {
+	r     *bufio.Reader
+	line  int
+	index int
+}
+
+// NewStream returns a stream reading the NDJSON body from r.
+func NewStream(r io.Reader) *Stream {
+	return &Stream{r: bufio.NewReaderSize(r, MaxLineLength), index: -1}
+}
+
+// Next decodes the next metric into m, blank lines are skipped. It returns io.EOF at the end of
+// the body, *LineError for a line that is not a metric and other errors of the reader as they are.
+func (s *Stream) Next(m *models.Metrics) error {
+	for {
+		line, err := s.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			s.line++
+			s.index++
+			if err := s.skipLine(); err != nil {
+				return err
+			}
+			return &LineError{Line: s.line, Err: ErrLineTooLong}
+		}
+		if err != nil && (!errors.Is(err, io.EOF) || len(line) == 0) {
+			return err
+		}
+
+		s.line++
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		s.index++
+		*m = models.Metrics{}
+		if err := decodeLine(line, m); err != nil {
+			return &LineError{Line: s.line, Err: err}
+		}
+		return nil
+	}
+}
+
+// decodeLine decodes the metric of a line, anything after it is an error.
+func decodeLine(line []byte, m *models.Metrics) error {
+	dec := strictDecoder(bytes.NewReader(line))
+	if err := dec.Decode(m); err != nil {
+		return unknownField(err)
+	}
+	if dec.InputOffset() != int64(len(line)) {
+		return errors.New("invalid data after the metric")
+	}
+	return nil
+}
+
+// skipLine discards the rest of a line longer than the buffer.
+func (s *Stream) skipLine() error {
+	for {
+		_, err := s.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+}
+
+// Index is the position of the last metric read in the stream, blank lines are not counted.
+func (s *Stream) Index() int {
+	return s.index
+}
+
+// decodeNDJSON reads all the metrics of the body, any invalid line fails it.
+func decodeNDJSON(r io.Reader, dst *[]models.Metrics) error {
+	s := NewStream(r)
+	metrics := (*dst)[:0]
+	for {
+		var m models.Metrics
+		err := s.Next(&m)
+		if errors.Is(err, io.EOF) {
+			*dst = metrics
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, m)
+	}
+}
+
+// marshalNDJSON writes a metric per line.
+func marshalNDJSON(metrics []models.Metrics) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, m := range metrics {
+		if err := enc.Encode(m); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,179 @@
+// Package compression implements the content codings of the HTTP API: gzip,
+// deflate (the zlib format, as HTTP defines it), br and zstd. The server picks
+// the coding of a response with Negotiate from the Accept-Encoding header of
+// the request and decodes the bodies with the coding of their Content-Encoding
+// header, the agent compresses the batches with the coding announced by the
+// server or set in its configuration.
+//
+// The writers and readers keep large internal state, hash tables and windows,
+// so they are pooled and reset for every body instead of being allocated anew.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	Gzip     = "gzip"
+	Deflate  = "deflate"
+	Brotli   = "br"
+	Zstd     = "zstd"
+	Identity = "identity"
+)
+
+// Encodings - поддерживаемые кодировки в порядке предпочтения сервера
+var Encodings = []string{Zstd, Brotli, Gzip, Deflate}
+
+// DefaultLevel - уровень сжатия кодировки по умолчанию
+const DefaultLevel = 0
+
+// maxDecoderMemory - ограничение памяти декодера zstd, окно кадра задается клиентом
+const maxDecoderMemory = 64 << 20
+
+// ErrUnsupported is returned for an unknown content coding.
+var ErrUnsupported = errors.New("unsupported content coding")
+
+// buffers - буферы для сжатия данных целиком, результат копируется из них
+var buffers = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// Writer is a compressing writer, Flush writes the data compressed so far.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Supported reports whether the coding is one of Encodings.
+func Supported(encoding string) bool {
+	for _, e := range Encodings {
+		if e == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckLevel checks the level of the coding: 1-9 for gzip and deflate, 1-11 for br, 1-22 for zstd,
+// DefaultLevel for the default level of the coding.
+func CheckLevel(encoding string, level int) error {
+	max := 0
+	switch encoding {
+	case Gzip, Deflate:
+		max = gzip.BestCompression
+	case Brotli:
+		max = brotli.BestCompression
+	case Zstd:
+		max = 22
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupported, encoding)
+	}
+
+	if level != DefaultLevel && (level < 1 || level > max) {
+		return fmt.Errorf("invalid %s compression level %d, expected 1-%d", encoding, level, max)
+	}
+	return nil
+}
+
+// Compress compresses the data with the coding at the level with a pooled writer.
+func Compress(encoding string, level int, data []byte) ([]byte, error) {
+	pool, err := Writers(encoding, level)
+	if err != nil {
+		return nil, err
+	}
+
+	b := buffers.Get().(*bytes.Buffer)
+	defer buffers.Put(b)
+	b.Reset()
+
+	w := pool.Get(b)
+	defer pool.Put(w)
+
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress data: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress data: %w", err)
+	}
+
+	return bytes.Clone(b.Bytes()), nil
+}
+
+// ParseContentEncoding returns the codings of the Content-Encoding header in the order they were applied,
+// without identity. The agent used to send "application/gzip", it is read as gzip.
+func ParseContentEncoding(header string) ([]string, error) {
+	var codings []string
+	for _, c := range strings.Split(header, ",") {
+		c = strings.ToLower(strings.TrimSpace(c))
+		switch c {
+		case "", Identity:
+			continue
+		case "x-gzip", "application/gzip":
+			c = Gzip
+		}
+		if !Supported(c) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, c)
+		}
+		codings = append(codings, c)
+	}
+	return codings, nil
+}
+
+// Negotiate picks the coding of a response for the Accept-Encoding header: the supported one with
+// the highest q-value, the order of Encodings breaks the ties, * stands for the codings not listed.
+// It returns "" when the response is to be sent as is.
+func Negotiate(acceptEncoding string) string {
+	weights := make(map[string]float64)
+	wildcard := -1.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = Gzip
+		}
+
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(p, "=")
+			if !ok || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || v < 0 || v > 1 {
+				// некорректный вес делает кодировку неприемлемой
+				v = 0
+			}
+			q = v
+		}
+
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, e := range Encodings {
+		q, ok := weights[e]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,135 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		want           string
+	}{
+		{name: "No header", acceptEncoding: "", want: ""},
+		{name: "Only gzip", acceptEncoding: "gzip", want: Gzip},
+		{name: "Server preference breaks the tie", acceptEncoding: "gzip, deflate, br, zstd", want: Zstd},
+		{name: "Highest q-value wins", acceptEncoding: "zstd;q=0.5, br;q=0.8, gzip;q=0.9", want: Gzip},
+		{name: "Zero q-value excludes", acceptEncoding: "zstd;q=0, br", want: Brotli},
+		{name: "Wildcard", acceptEncoding: "*", want: Zstd},
+		{name: "Wildcard for the codings not listed", acceptEncoding: "zstd;q=0, br;q=0, *;q=0.5", want: Gzip},
+		{name: "Everything excluded", acceptEncoding: "*;q=0", want: ""},
+		{name: "Identity only", acceptEncoding: "identity", want: ""},
+		{name: "Unknown codings", acceptEncoding: "compress, lzma", want: ""},
+		{name: "Case and spaces", acceptEncoding: " GZIP ; Q=1 ,DEFLATE;q=0.1", want: Gzip},
+		{name: "Invalid q-value", acceptEncoding: "zstd;q=2, deflate", want: Deflate},
+		{name: "Legacy x-gzip", acceptEncoding: "x-gzip", want: Gzip},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, Negotiate(test.acceptEncoding))
+		})
+	}
+}
+
+func TestCheckLevel(t *testing.T) {
+	tests := []struct {
+		encoding string
+		level    int
+		errWant  bool
+	}{
+		{encoding: Gzip, level: DefaultLevel},
+		{encoding: Gzip, level: 9},
+		{encoding: Gzip, level: 10, errWant: true},
+		{encoding: Deflate, level: -1, errWant: true},
+		{encoding: Brotli, level: 11},
+		{encoding: Brotli, level: 12, errWant: true},
+		{encoding: Zstd, level: 22},
+		{encoding: Zstd, level: 23, errWant: true},
+		{encoding: "lzma", level: DefaultLevel, errWant: true},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s level %d", test.encoding, test.level), func(t *testing.T) {
+			err := CheckLevel(test.encoding, test.level)
+			if test.errWant {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestParseContentEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    []string
+		errWant bool
+	}{
+		{name: "No header", header: "", want: nil},
+		{name: "Identity", header: "identity", want: nil},
+		{name: "Single coding", header: "zstd", want: []string{Zstd}},
+		{name: "Legacy agent", header: "application/gzip", want: []string{Gzip}},
+		{name: "Several codings", header: "deflate, br", want: []string{Deflate, Brotli}},
+		{name: "Unknown coding", header: "compress", errWant: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseContentEncoding(test.header)
+			if test.errWant {
+				assert.ErrorIs(t, err, ErrUnsupported)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"id":"metric","type":"gauge","value":1}`), 100)
+
+	for _, encoding := range Encodings {
+		for _, level := range []int{DefaultLevel, 1, 9} {
+			t.Run(fmt.Sprintf("%s level %d", encoding, level), func(t *testing.T) {
+				// писатели и читатели из пула используются несколько раз подряд
+				for i := 0; i < 3; i++ {
+					compressed, err := Compress(encoding, level, data)
+					require.NoError(t, err)
+					assert.Less(t, len(compressed), len(data))
+
+					r, err := NewReader(encoding, bytes.NewReader(compressed))
+					require.NoError(t, err)
+
+					got, err := io.ReadAll(r)
+					require.NoError(t, err)
+					assert.Equal(t, data, got)
+
+					require.NoError(t, r.Close())
+					// повторное закрытие не возвращает читатель в пул дважды
+					require.NoError(t, r.Close())
+					_, err = r.Read(got)
+					assert.Error(t, err)
+				}
+			})
+		}
+	}
+
+	_, err := Compress("lzma", DefaultLevel, data)
+	assert.ErrorIs(t, err, ErrUnsupported)
+
+	_, err = Compress(Gzip, 10, data)
+	assert.Error(t, err)
+
+	_, err = NewReader("lzma", bytes.NewReader(data))
+	assert.ErrorIs(t, err, ErrUnsupported)
+}
@@ -0,0 +1,192 @@
+package compression
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// resetWriter is a writer of any of the codings, Reset directs it to a new destination.
+type resetWriter interface {
+	Writer
+	Reset(w io.Writer)
+}
+
+// WriterPool keeps the writers of one coding at one level for reuse.
+type WriterPool struct {
+	pool sync.Pool
+}
+
+type poolKey struct {
+	encoding string
+	level    int
+}
+
+// writerPools - пулы писателей по кодировке и уровню сжатия, общие для всех пользователей пакета
+var writerPools sync.Map
+
+// Writers returns the pool of the writers of the coding at the level, DefaultLevel for the default one.
+func Writers(encoding string, level int) (*WriterPool, error) {
+	key := poolKey{encoding: encoding, level: level}
+	if p, ok := writerPools.Load(key); ok {
+		return p.(*WriterPool), nil
+	}
+
+	if err := CheckLevel(encoding, level); err != nil {
+		return nil, err
+	}
+
+	newWriter := writerFactory(encoding, level)
+	p := &WriterPool{pool: sync.Pool{New: func() any { return newWriter() }}}
+
+	actual, _ := writerPools.LoadOrStore(key, p)
+	return actual.(*WriterPool), nil
+}
+
+// writerFactory returns the constructor of the writers of the coding at the checked level.
+func writerFactory(encoding string, level int) func() resetWriter {
+	switch encoding {
+	case Gzip, Deflate:
+		if level == DefaultLevel {
+			level = gzip.DefaultCompression
+		}
+		if encoding == Deflate {
+			return func() resetWriter {
+				zw, _ := zlib.NewWriterLevel(io.Discard, level)
+				return zw
+			}
+		}
+		return func() resetWriter {
+			zw, _ := gzip.NewWriterLevel(io.Discard, level)
+			return zw
+		}
+	case Brotli:
+		if level == DefaultLevel {
+			level = brotli.DefaultCompression
+		}
+		return func() resetWriter {
+			return brotli.NewWriterLevel(io.Discard, level)
+		}
+	default:
+		speed := zstd.SpeedDefault
+		if level != DefaultLevel {
+			speed = zstd.EncoderLevelFromZstd(level)
+		}
+		return func() resetWriter {
+			zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(speed))
+			return zw
+		}
+	}
+}
+
+// Get returns a writer compressing to w.
+func (p *WriterPool) Get(w io.Writer) Writer {
+	zw := p.pool.Get().(resetWriter)
+	zw.Reset(w)
+	return zw
+}
+
+// Put returns the writer to the pool, it must be closed and not used any more.
+func (p *WriterPool) Put(w Writer) {
+	p.pool.Put(w)
+}
+
+// resetReader is a reader of any of the codings, reset directs it to a new source.
+type resetReader struct {
+	io.Reader
+	reset func(r io.Reader) error
+}
+
+// readerPool keeps the readers of a coding, a new one needs the source to read its header.
+type readerPool struct {
+	pool      sync.Pool
+	newReader func(r io.Reader) (resetReader, error)
+}
+
+func (p *readerPool) get(r io.Reader) (*pooledReader, error) {
+	if pr, ok := p.pool.Get().(*pooledReader); ok {
+		if err := pr.zr.reset(r); err != nil {
+			p.pool.Put(pr)
+			return nil, err
+		}
+		pr.open = true
+		return pr, nil
+	}
+
+	zr, err := p.newReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledReader{zr: zr, pool: p, open: true}, nil
+}
+
+// pooledReader returns the reader to its pool on Close.
+type pooledReader struct {
+	zr   resetReader
+	pool *readerPool
+	open bool
+}
+
+func (r *pooledReader) Read(data []byte) (int, error) {
+	if !r.open {
+		return 0, fmt.Errorf("read from a closed reader")
+	}
+	return r.zr.Read(data)
+}
+
+func (r *pooledReader) Close() error {
+	if !r.open {
+		return nil
+	}
+	r.open = false
+	r.pool.pool.Put(r)
+	return nil
+}
+
+// readerPools - пулы читателей по кодировке
+var readerPools = map[string]*readerPool{
+	Gzip: {newReader: func(r io.Reader) (resetReader, error) {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return resetReader{}, err
+		}
+		return resetReader{Reader: zr, reset: zr.Reset}, nil
+	}},
+	Deflate: {newReader: func(r io.Reader) (resetReader, error) {
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return resetReader{}, err
+		}
+		return resetReader{Reader: zr, reset: func(r io.Reader) error { return zr.(zlib.Resetter).Reset(r, nil) }}, nil
+	}},
+	Brotli: {newReader: func(r io.Reader) (resetReader, error) {
+		zr := brotli.NewReader(r)
+		return resetReader{Reader: zr, reset: zr.Reset}, nil
+	}},
+	Zstd: {newReader: func(r io.Reader) (resetReader, error) {
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxDecoderMemory))
+		if err != nil {
+			return resetReader{}, err
+		}
+		return resetReader{Reader: zr, reset: zr.Reset}, nil
+	}},
+}
+
+// NewReader returns a pooled reader decompressing r with the coding, Close returns it to the pool.
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	p, ok := readerPools[encoding]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, encoding)
+	}
+
+	pr, err := p.get(r)
+	if err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
@@ -1,7 +1,9 @@
 package logger
 
 import (
+	"errors"
 	"net/http"
+	"runtime/debug"
 	"time"
 
 	"go.uber.org/zap"
@@ -35,6 +37,11 @@ func (r *loggingResponseWriter) WriteHeader(status int) {
 	r.responseData.status = status
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to flush it.
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func NewLogger() (Logger, error) {
 	zap, err := zap.NewDevelopment()
 	return Logger{zap: zap, Sugar: zap.Sugar()}, err
@@ -74,3 +81,25 @@ func (l *Logger) WithLogging(next http.Handler) http.Handler {
 		l.Sugar.Infoln(logMsg...)
 	})
 }
+
+// WithRecovery recovers a panic of a handler, logs it with the stack trace and answers 500,
+// so that a single broken request does not take the server down. http.ErrAbortHandler is
+// passed on, the server aborts the response with it silently.
+func (l *Logger) WithRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			l.Sugar.Errorw("panic in request handler", "panic", rec, "URI", r.RequestURI, "METHOD", r.Method, "stack", string(debug.Stack()))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,34 @@
+package logger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithRecovery(t *testing.T) {
+	l, err := NewLogger()
+	require.NoError(t, err)
+
+	handler := l.WithRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["panic"]++
+	}))
+
+	w := httptest.NewRecorder()
+	assert.NotPanics(t, func() {
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update", nil))
+	})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// прерванный ответ передается серверу
+	abort := l.WithRecovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+}
@@ -1,8 +1,88 @@
 package models
 
+import "time"
+
 type Metrics struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID         string   `json:"id"`                   // имя метрики
+	MType      string   `json:"type"`                 // параметр, принимающий значение gauge или counter
+	Delta      *int64   `json:"delta,omitempty"`      // значение метрики в случае передачи counter
+	Value      *float64 `json:"value,omitempty"`      // значение метрики в случае передачи gauge
+	Cumulative bool     `json:"cumulative,omitempty"` // delta содержит накопленное источником значение counter, а не приращение
+}
+
+// APIError описывает ошибку в ответах /api/v1
+type APIError struct {
+	Code    string `json:"code"`            // машиночитаемый код ошибки
+	Message string `json:"message"`         // описание ошибки
+	Field   string `json:"field,omitempty"` // поле запроса, к которому относится ошибка
+}
+
+// ErrorResponse - конверт ошибки в ответах /api/v1
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// RejectedMetric - метрика пакета, не принятая сервером
+type RejectedMetric struct {
+	Index int      `json:"index"`        // позиция метрики в пакете
+	ID    string   `json:"id,omitempty"` // имя метрики
+	Error APIError `json:"error"`        // причина отказа
+}
+
+// BatchResult - результат обработки пакета метрик
+type BatchResult struct {
+	Accepted int              `json:"accepted"` // количество принятых метрик
+	Rejected []RejectedMetric `json:"rejected"` // отклоненные метрики с причинами
+	// RejectedCount - количество отклоненных метрик пакета NDJSON, в Rejected перечислены только первые из них
+	RejectedCount int  `json:"rejected_count,omitempty"`
+	Duplicate     bool `json:"duplicate,omitempty"` // пакет с этим идентификатором уже был применен
+}
+
+// SeriesAggregate - результат агрегации одной метрики
+type SeriesAggregate struct {
+	ID      string  `json:"id"`      // имя метрики
+	MType   string  `json:"type"`    // тип метрики
+	Value   float64 `json:"value"`   // значение агрегации
+	Samples int     `json:"samples"` // количество значений в окне
+}
+
+// QueryResult - результат запроса с агрегацией
+type QueryResult struct {
+	Selector    string            `json:"selector"`        // имя или шаблон имен метрик
+	Aggregation string            `json:"aggregation"`     // функция агрегации
+	From        time.Time         `json:"from"`            // начало окна
+	To          time.Time         `json:"to"`              // конец окна
+	Value       *float64          `json:"value,omitempty"` // агрегация по всем выбранным метрикам, если ее можно вычислить
+	Series      []SeriesAggregate `json:"series"`          // агрегация по каждой метрике
+}
+
+// ExprSample - значение метрики в результате выражения
+type ExprSample struct {
+	ID    string  `json:"id"`    // имя метрики
+	Value float64 `json:"value"` // значение
+}
+
+// ExprResult - результат вычисления выражения
+type ExprResult struct {
+	Expr   string       `json:"expr"`             // выражение
+	Type   string       `json:"type"`             // scalar или vector
+	Value  *float64     `json:"value,omitempty"`  // значение, если результат - число
+	Series []ExprSample `json:"series,omitempty"` // значения метрик, если результат - вектор
+}
+
+// Capabilities - возможности сервера, по ним агент выбирает кодирование и сжатие пакетов метрик
+type Capabilities struct {
+	ContentEncodings []string `json:"content_encodings"` // поддерживаемые кодировки сжатия в порядке предпочтения сервера
+	ContentTypes     []string `json:"content_types"`     // поддерживаемые форматы пакетов метрик
+	MaxBodySize      int64    `json:"max_body_size"`     // максимальный размер тела запроса в байтах
+	MaxBatchSize     int      `json:"max_batch_size"`    // максимальное количество метрик в одном пакете
+}
+
+// ExportRow - строка выгрузки метрик
+type ExportRow struct {
+	ID    string    `json:"id" parquet:"id"`                            // имя метрики
+	MType string    `json:"type" parquet:"type"`                        // тип метрики
+	Time  time.Time `json:"time" parquet:"time,timestamp(millisecond)"` // время значения
+	Value *float64  `json:"value,omitempty" parquet:"value,optional"`   // значение gauge
+	Delta *int64    `json:"delta,omitempty" parquet:"delta,optional"`   // значение counter
 }
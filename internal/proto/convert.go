@@ -0,0 +1,78 @@
+package proto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// FromModel converts models.Metrics to its protobuf representation.
+func FromModel(m models.Metrics) *Metric {
+	metric := &Metric{Id: m.ID}
+	switch m.MType {
+	case types.Gauge:
+		metric.Type = Metric_GAUGE
+		if m.Value != nil {
+			metric.Value = *m.Value
+		}
+	case types.Counter:
+		metric.Type = Metric_COUNTER
+		if m.Delta != nil {
+			metric.Delta = *m.Delta
+		}
+	}
+	return metric
+}
+
+// ToModel converts the protobuf metric to models.Metrics.
+func (x *Metric) ToModel() (models.Metrics, error) {
+	if len(x.GetId()) == 0 {
+		return models.Metrics{}, errors.New("the name of the metric is empty")
+	}
+
+	m := x.Model()
+	if m.MType == "" {
+		return models.Metrics{}, errors.New("the type of the metric is not defined")
+	}
+
+	return m, nil
+}
+
+// Model converts the protobuf metric to models.Metrics without checking it, a metric
+// of an unspecified type is converted without the type and the value.
+func (x *Metric) Model() models.Metrics {
+	m := models.Metrics{ID: x.GetId()}
+
+	switch x.GetType() {
+	case Metric_GAUGE:
+		value := x.GetValue()
+		m.MType, m.Value = types.Gauge, &value
+	case Metric_COUNTER:
+		delta := x.GetDelta()
+		m.MType, m.Delta = types.Counter, &delta
+	}
+
+	return m
+}
+
+// Sum computes the base64 HMAC-SHA256 of the request serialized deterministically
+// with an empty hash field.
+func Sum(req *UpdateMetricsRequest, key string) (string, error) {
+	unsigned := proto.Clone(req).(*UpdateMetricsRequest)
+	unsigned.Hash = ""
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(data)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,343 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// ValueType is the type of the result of an expression.
+type ValueType string
+
+const (
+	Scalar ValueType = "scalar"
+	Vector ValueType = "vector"
+)
+
+// Sample is the value of a metric in a vector.
+type Sample struct {
+	ID    string
+	Value float64
+}
+
+// Value is the result of an expression: a number or the values of metrics sorted by name.
+type Value struct {
+	Type   ValueType
+	Scalar float64
+	Vector []Sample
+}
+
+// rangeFunctions - функции над окном значений и вычисляемые хранилищем агрегации
+var rangeFunctions = map[string]storage.Aggregation{
+	"rate":            storage.AggRate,
+	"sum_over_time":   storage.AggSum,
+	"avg_over_time":   storage.AggAvg,
+	"min_over_time":   storage.AggMin,
+	"max_over_time":   storage.AggMax,
+	"count_over_time": storage.AggCount,
+	"last_over_time":  storage.AggLast,
+	"p95_over_time":   storage.AggP95,
+}
+
+// aggregationFunctions - функции, сводящие вектор к одному числу
+var aggregationFunctions = map[string]func(values []float64) float64{
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(values []float64) float64 {
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+type evaluator struct {
+	ctx      context.Context
+	src      Source
+	conflict string
+	now      time.Time
+
+	// current - текущие значения метрик, читаются из хранилища один раз за вычисление
+	current map[string]types.Metric
+}
+
+func (ev *evaluator) eval(node Node) (Value, error) {
+	switch n := node.(type) {
+	case *NumberLiteral:
+		return Value{Type: Scalar, Scalar: n.Value}, nil
+	case *Selector:
+		if n.Window > 0 {
+			return Value{}, &Error{Pos: n.Offset, Msg: "a selector with a window must be an argument of a range function like rate"}
+		}
+		return ev.selectCurrent(n)
+	case *Call:
+		return ev.call(n)
+	case *Unary:
+		v, err := ev.eval(n.Expr)
+		if err != nil {
+			return Value{}, err
+		}
+		return ev.arithmetic(n.Offset, "*", v, Value{Type: Scalar, Scalar: -1})
+	case *Binary:
+		lhs, err := ev.eval(n.LHS)
+		if err != nil {
+			return Value{}, err
+		}
+		rhs, err := ev.eval(n.RHS)
+		if err != nil {
+			return Value{}, err
+		}
+		return ev.arithmetic(n.Offset, n.Op, lhs, rhs)
+	}
+
+	return Value{}, &Error{Pos: node.Pos(), Msg: fmt.Sprintf("unsupported expression %T", node)}
+}
+
+// selectCurrent returns the current values of the selected metrics.
+func (ev *evaluator) selectCurrent(sel *Selector) (Value, error) {
+	if ev.current == nil {
+		metrics, err := ev.src.Metrics(ev.ctx)
+		if err != nil {
+			return Value{}, err
+		}
+		ev.current = metrics
+	}
+
+	v := Value{Type: Vector, Vector: []Sample{}}
+	for key, metric := range ev.current {
+		id := types.KeyName(ev.conflict, key, metric.MetricType)
+		if !(id == sel.Name || (sel.Glob && storage.Match(sel.Name, id))) {
+			continue
+		}
+
+		switch value := metric.Value.(type) {
+		case float64:
+			v.Vector = append(v.Vector, Sample{ID: id, Value: value})
+		case int64:
+			v.Vector = append(v.Vector, Sample{ID: id, Value: float64(value)})
+		}
+	}
+
+	sort.Slice(v.Vector, func(i, j int) bool { return v.Vector[i].ID < v.Vector[j].ID })
+
+	return v, nil
+}
+
+func (ev *evaluator) call(c *Call) (Value, error) {
+	if agg, ok := rangeFunctions[c.Func]; ok {
+		return ev.callRange(c, agg)
+	}
+
+	fn, ok := aggregationFunctions[c.Func]
+	if !ok {
+		return Value{}, &Error{Pos: c.Offset, Msg: fmt.Sprintf("unknown function %q", c.Func)}
+	}
+
+	if len(c.Args) != 1 {
+		return Value{}, &Error{Pos: c.Offset, Msg: fmt.Sprintf("%s expects one argument, got %d", c.Func, len(c.Args))}
+	}
+
+	arg, err := ev.eval(c.Args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	if arg.Type != Vector {
+		return Value{}, &Error{Pos: c.Args[0].Pos(), Msg: fmt.Sprintf("%s expects a selector of metrics, got a scalar", c.Func)}
+	}
+
+	if len(arg.Vector) == 0 && c.Func != "sum" && c.Func != "count" {
+		return Value{}, &Error{Pos: c.Offset, Msg: fmt.Sprintf("%s of no metrics", c.Func)}
+	}
+
+	values := make([]float64, len(arg.Vector))
+	for i, s := range arg.Vector {
+		values[i] = s.Value
+	}
+
+	return Value{Type: Scalar, Scalar: fn(values)}, nil
+}
+
+// callRange asks the source for the aggregation of the values written within the window of the selector.
+func (ev *evaluator) callRange(c *Call, agg storage.Aggregation) (Value, error) {
+	if len(c.Args) != 1 {
+		return Value{}, &Error{Pos: c.Offset, Msg: fmt.Sprintf("%s expects one argument, got %d", c.Func, len(c.Args))}
+	}
+
+	sel, ok := c.Args[0].(*Selector)
+	if !ok || sel.Window == 0 {
+		return Value{}, &Error{Pos: c.Args[0].Pos(), Msg: fmt.Sprintf("%s expects a selector with a window like %s(requests[5m])", c.Func, c.Func)}
+	}
+
+	aggregates, err := ev.src.Query(ev.ctx, storage.Query{
+		Selector:    sel.Name,
+		From:        ev.now.Add(-sel.Window),
+		To:          ev.now,
+		Aggregation: agg,
+	})
+	if err != nil {
+		return Value{}, err
+	}
+
+	v := Value{Type: Vector, Vector: []Sample{}}
+	for _, a := range aggregates {
+		// имя без кавычек выбирает только метрику с этим именем, даже если в нем есть символы шаблона
+		if !sel.Glob && a.ID != sel.Name {
+			continue
+		}
+		v.Vector = append(v.Vector, Sample{ID: a.ID, Value: a.Value})
+	}
+
+	return v, nil
+}
+
+// arithmetic applies the binary operator, see the package documentation for the rules.
+func (ev *evaluator) arithmetic(pos int, op string, lhs, rhs Value) (Value, error) {
+	compare := isComparison(op)
+
+	if lhs.Type == Scalar && rhs.Type == Scalar {
+		result, ok := apply(op, lhs.Scalar, rhs.Scalar)
+		if !ok {
+			return Value{}, &Error{Pos: pos, Msg: fmt.Sprintf("the result of %g %s %g is not a finite number", lhs.Scalar, op, rhs.Scalar)}
+		}
+		return Value{Type: Scalar, Scalar: result}, nil
+	}
+
+	// скаляр и вектор из одной метрики применяются к каждой метрике другого вектора
+	var pairs []pair
+	switch {
+	case rhs.Type == Scalar:
+		pairs = broadcast(lhs.Vector, rhs.Scalar, false)
+	case lhs.Type == Scalar:
+		pairs = broadcast(rhs.Vector, lhs.Scalar, true)
+	case len(rhs.Vector) == 1:
+		pairs = broadcast(lhs.Vector, rhs.Vector[0].Value, false)
+	case len(lhs.Vector) == 1:
+		pairs = broadcast(rhs.Vector, lhs.Vector[0].Value, true)
+	default:
+		pairs = match(lhs.Vector, rhs.Vector)
+	}
+
+	v := Value{Type: Vector, Vector: []Sample{}}
+	for _, p := range pairs {
+		result, ok := apply(op, p.lhs, p.rhs)
+		switch {
+		case !ok:
+			// метрики с бесконечным или неопределенным результатом не попадают в вектор
+			continue
+		case compare && result == 0:
+			continue
+		case compare:
+			// сравнение оставляет значение метрики вектора
+			result = p.value
+		}
+		v.Vector = append(v.Vector, Sample{ID: p.id, Value: result})
+	}
+
+	return v, nil
+}
+
+// pair is a pair of operands for a metric of the result, value is the value of the metric.
+type pair struct {
+	id       string
+	lhs, rhs float64
+	value    float64
+}
+
+func broadcast(vector []Sample, scalar float64, scalarLeft bool) []pair {
+	pairs := make([]pair, 0, len(vector))
+	for _, s := range vector {
+		p := pair{id: s.ID, lhs: s.Value, rhs: scalar, value: s.Value}
+		if scalarLeft {
+			p.lhs, p.rhs = scalar, s.Value
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs
+}
+
+func match(lhs, rhs []Sample) []pair {
+	values := make(map[string]float64, len(rhs))
+	for _, s := range rhs {
+		values[s.ID] = s.Value
+	}
+
+	pairs := make([]pair, 0, len(lhs))
+	for _, s := range lhs {
+		if value, ok := values[s.ID]; ok {
+			pairs = append(pairs, pair{id: s.ID, lhs: s.Value, rhs: value, value: s.Value})
+		}
+	}
+	return pairs
+}
+
+func isComparison(op string) bool {
+	return strings.ContainsAny(op, "=<>")
+}
+
+// apply computes the operation, comparisons yield 1 or 0. It reports false if the result is not a finite number.
+func apply(op string, a, b float64) (float64, bool) {
+	var result float64
+
+	switch op {
+	case "+":
+		result = a + b
+	case "-":
+		result = a - b
+	case "*":
+		result = a * b
+	case "/":
+		result = a / b
+	case "%":
+		result = math.Mod(a, b)
+	default:
+		var ok bool
+		switch op {
+		case "==":
+			ok = a == b
+		case "!=":
+			ok = a != b
+		case "<":
+			ok = a < b
+		case ">":
+			ok = a > b
+		case "<=":
+			ok = a <= b
+		case ">=":
+			ok = a >= b
+		}
+		if ok {
+			result = 1
+		}
+	}
+
+	return result, !math.IsInf(result, 0) && !math.IsNaN(result)
+}
@@ -0,0 +1,141 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+type source struct {
+	metrics    map[string]types.Metric
+	aggregates []storage.Aggregate
+	queries    []storage.Query
+	err        error
+}
+
+func (s *source) Metrics(context.Context) (map[string]types.Metric, error) {
+	return s.metrics, s.err
+}
+
+func (s *source) Query(_ context.Context, q storage.Query) ([]storage.Aggregate, error) {
+	s.queries = append(s.queries, q)
+
+	var results []storage.Aggregate
+	for _, a := range s.aggregates {
+		if storage.Match(q.Selector, a.ID) {
+			results = append(results, a)
+		}
+	}
+	return results, s.err
+}
+
+func TestEvaluate(t *testing.T) {
+	src := &source{
+		metrics: map[string]types.Metric{
+			"HeapInuse": {MetricType: types.Gauge, Value: float64(30)},
+			"HeapSys":   {MetricType: types.Gauge, Value: float64(120)},
+			"http_ok":   {MetricType: types.Counter, Value: int64(90)},
+			"http_err":  {MetricType: types.Counter, Value: int64(10)},
+			"grpc_ok":   {MetricType: types.Counter, Value: int64(5)},
+		},
+		aggregates: []storage.Aggregate{
+			{ID: "errors", MType: types.Counter, Value: 0.5},
+			{ID: "requests", MType: types.Counter, Value: 4},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want Value
+	}{
+		{`1 + 2 * 3`, Value{Type: Scalar, Scalar: 7}},
+		{`(1 + 2) * 3 % 4`, Value{Type: Scalar, Scalar: 1}},
+		{`2 > 1`, Value{Type: Scalar, Scalar: 1}},
+		{`-2 >= 1`, Value{Type: Scalar, Scalar: 0}},
+		{`HeapInuse / HeapSys * 100`, Value{Type: Vector, Vector: []Sample{{ID: "HeapInuse", Value: 25}}}},
+		{`rate(requests[5m]) / rate(errors[5m])`, Value{Type: Vector, Vector: []Sample{{ID: "requests", Value: 8}}}},
+		{`"http_*" * 2`, Value{Type: Vector, Vector: []Sample{{ID: "http_err", Value: 20}, {ID: "http_ok", Value: 180}}}},
+		{`"http_*" > 50`, Value{Type: Vector, Vector: []Sample{{ID: "http_ok", Value: 90}}}},
+		{`"http_*" / HeapSys`, Value{Type: Vector, Vector: []Sample{{ID: "http_err", Value: 10.0 / 120}, {ID: "http_ok", Value: 0.75}}}},
+		{`"*_ok" - "http_*"`, Value{Type: Vector, Vector: []Sample{{ID: "http_ok", Value: 0}}}},
+		{`sum("http_*")`, Value{Type: Scalar, Scalar: 100}},
+		{`avg("*_ok")`, Value{Type: Scalar, Scalar: 47.5}},
+		{`count(missing)`, Value{Type: Scalar, Scalar: 0}},
+		{`max("*") / min("*")`, Value{Type: Scalar, Scalar: 24}},
+		{`sum(rate("*"[1m]))`, Value{Type: Scalar, Scalar: 4.5}},
+		// деление на ноль убирает метрику из результата
+		{`HeapSys / (HeapSys - 120)`, Value{Type: Vector, Vector: []Sample{}}},
+	}
+
+	for _, tt := range tests {
+		got, err := Evaluate(context.Background(), src, types.ConflictReject, tt.expr, time.Now())
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+}
+
+func TestEvaluate_Namespace(t *testing.T) {
+	// у каждого типа свои имена, метрики перечисляются под ключами type/name
+	src := &source{
+		metrics: map[string]types.Metric{
+			"gauge/HeapInuse":  {MetricType: types.Gauge, Value: float64(30)},
+			"gauge/HeapSys":    {MetricType: types.Gauge, Value: float64(120)},
+			"counter/http_ok":  {MetricType: types.Counter, Value: int64(90)},
+			"counter/http_err": {MetricType: types.Counter, Value: int64(10)},
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want Value
+	}{
+		{`HeapInuse / HeapSys * 100`, Value{Type: Vector, Vector: []Sample{{ID: "HeapInuse", Value: 25}}}},
+		{`sum("http_*")`, Value{Type: Scalar, Scalar: 100}},
+		{`count("gauge/*")`, Value{Type: Scalar, Scalar: 0}},
+	}
+
+	for _, tt := range tests {
+		got, err := Evaluate(context.Background(), src, types.ConflictNamespace, tt.expr, time.Now())
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+}
+
+func TestEvaluate_Window(t *testing.T) {
+	src := &source{}
+	now := time.Now()
+
+	_, err := Evaluate(context.Background(), src, types.ConflictReject, `p95_over_time("http_*"[15m])`, now)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Query{{Selector: "http_*", From: now.Add(-15 * time.Minute), To: now, Aggregation: storage.AggP95}}, src.queries)
+}
+
+func TestEvaluate_Errors(t *testing.T) {
+	src := &source{metrics: map[string]types.Metric{"a": {MetricType: types.Gauge, Value: float64(1)}}}
+
+	for _, expr := range []string{
+		`a[5m]`,
+		`rate(a)`,
+		`rate(a[5m], a[5m])`,
+		`sum(1)`,
+		`avg(missing)`,
+		`median(a)`,
+		`1 / 0`,
+	} {
+		_, err := Evaluate(context.Background(), src, types.ConflictReject, expr, time.Now())
+		var qErr *Error
+		assert.ErrorAs(t, err, &qErr, expr)
+	}
+
+	// ошибки хранилища возвращаются как есть
+	src.err = errors.New("storage is down")
+	_, err := Evaluate(context.Background(), src, types.ConflictReject, `a + 1`, time.Now())
+	assert.Equal(t, src.err, err)
+}
@@ -0,0 +1,193 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	// tokRange - окно выборки в квадратных скобках, текст токена без скобок
+	tokRange
+	tokLParen
+	tokRParen
+	tokComma
+	tokAdd
+	tokSub
+	tokMul
+	tokDiv
+	tokMod
+	tokEq
+	tokNe
+	tokLt
+	tokGt
+	tokLe
+	tokGe
+)
+
+var operators = map[tokenKind]string{
+	tokAdd: "+",
+	tokSub: "-",
+	tokMul: "*",
+	tokDiv: "/",
+	tokMod: "%",
+	tokEq:  "==",
+	tokNe:  "!=",
+	tokLt:  "<",
+	tokGt:  ">",
+	tokLe:  "<=",
+	tokGe:  ">=",
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "the end of the expression"
+	case tokRange:
+		return "[" + t.text + "]"
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// lex splits the expression into tokens, the last one is tokEOF.
+func lex(input string) ([]token, error) {
+	var tokens []token
+
+	for pos := 0; pos < len(input); {
+		r, size := utf8.DecodeRuneInString(input[pos:])
+		start := pos
+
+		switch {
+		case unicode.IsSpace(r):
+			pos += size
+			continue
+		case isIdentStart(r):
+			for pos < len(input) {
+				r, size := utf8.DecodeRuneInString(input[pos:])
+				if !isIdentStart(r) && !unicode.IsDigit(r) && r != '.' {
+					break
+				}
+				pos += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: input[start:pos], pos: start})
+			continue
+		case isDigit(input[pos]) || (r == '.' && pos+1 < len(input) && isDigit(input[pos+1])):
+			pos = scanNumber(input, pos)
+			tokens = append(tokens, token{kind: tokNumber, text: input[start:pos], pos: start})
+			continue
+		case r == '"':
+			end := strings.IndexByte(input[pos+1:], '"')
+			if end < 0 {
+				return nil, &Error{Pos: start, Msg: "unterminated string"}
+			}
+			pos += end + 2
+			tokens = append(tokens, token{kind: tokString, text: input[start+1 : pos-1], pos: start})
+			continue
+		case r == '[':
+			end := strings.IndexByte(input[pos+1:], ']')
+			if end < 0 {
+				return nil, &Error{Pos: start, Msg: "unterminated range"}
+			}
+			pos += end + 2
+			tokens = append(tokens, token{kind: tokRange, text: strings.TrimSpace(input[start+1 : pos-1]), pos: start})
+			continue
+		}
+
+		kind, width := operator(input[pos:])
+		if width == 0 {
+			return nil, &Error{Pos: start, Msg: fmt.Sprintf("unexpected character %q", r)}
+		}
+		pos += width
+		tokens = append(tokens, token{kind: kind, text: input[start:pos], pos: start})
+	}
+
+	return append(tokens, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+// operator recognizes the punctuation at the start of the input and returns its width, 0 if there is none.
+func operator(input string) (tokenKind, int) {
+	switch {
+	case strings.HasPrefix(input, "=="):
+		return tokEq, 2
+	case strings.HasPrefix(input, "!="):
+		return tokNe, 2
+	case strings.HasPrefix(input, "<="):
+		return tokLe, 2
+	case strings.HasPrefix(input, ">="):
+		return tokGe, 2
+	}
+
+	switch input[0] {
+	case '(':
+		return tokLParen, 1
+	case ')':
+		return tokRParen, 1
+	case ',':
+		return tokComma, 1
+	case '+':
+		return tokAdd, 1
+	case '-':
+		return tokSub, 1
+	case '*':
+		return tokMul, 1
+	case '/':
+		return tokDiv, 1
+	case '%':
+		return tokMod, 1
+	case '<':
+		return tokLt, 1
+	case '>':
+		return tokGt, 1
+	}
+
+	return tokEOF, 0
+}
+
+// scanNumber returns the end of the number starting at pos: digits with an optional fraction and exponent.
+func scanNumber(input string, pos int) int {
+	digits := func() {
+		for pos < len(input) && isDigit(input[pos]) {
+			pos++
+		}
+	}
+
+	digits()
+	if pos < len(input) && input[pos] == '.' {
+		pos++
+		digits()
+	}
+	if pos < len(input) && (input[pos] == 'e' || input[pos] == 'E') {
+		exp := pos + 1
+		if exp < len(input) && (input[exp] == '+' || input[exp] == '-') {
+			exp++
+		}
+		if exp < len(input) && isDigit(input[exp]) {
+			pos = exp
+			digits()
+		}
+	}
+
+	return pos
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
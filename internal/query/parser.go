@@ -0,0 +1,227 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Node is a node of the syntax tree of an expression.
+type Node interface {
+	Pos() int
+}
+
+// NumberLiteral is a number.
+type NumberLiteral struct {
+	Value  float64
+	Offset int
+}
+
+// Selector selects the metrics by the name or, if Glob is set, by a glob. With a window
+// it selects their values written within the window and is an argument of a range function.
+type Selector struct {
+	Name   string
+	Glob   bool
+	Window time.Duration
+	Offset int
+}
+
+// Call is a call of a range or aggregation function.
+type Call struct {
+	Func   string
+	Args   []Node
+	Offset int
+}
+
+// Unary is the negation of an expression.
+type Unary struct {
+	Expr   Node
+	Offset int
+}
+
+// Binary is an arithmetic operation or a comparison.
+type Binary struct {
+	Op     string
+	LHS    Node
+	RHS    Node
+	Offset int
+}
+
+func (n *NumberLiteral) Pos() int { return n.Offset }
+func (n *Selector) Pos() int      { return n.Offset }
+func (n *Call) Pos() int          { return n.Offset }
+func (n *Unary) Pos() int         { return n.Offset }
+func (n *Binary) Pos() int        { return n.Offset }
+
+// precedence - приоритет бинарных операторов, сравнения выполняются последними
+var precedence = map[tokenKind]int{
+	tokEq:  1,
+	tokNe:  1,
+	tokLt:  1,
+	tokGt:  1,
+	tokLe:  1,
+	tokGe:  1,
+	tokAdd: 2,
+	tokSub: 2,
+	tokMul: 3,
+	tokDiv: 3,
+	tokMod: 3,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses the expression into its syntax tree.
+func Parse(expr string) (Node, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	node, err := p.parseExpr(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+
+	return node, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, what string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected %s, found %s", what, t)}
+	}
+	return t, nil
+}
+
+// parseExpr parses the binary operations of at least the given precedence, all of them are left-associative.
+func (p *parser) parseExpr(minPrec int) (Node, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := p.peek()
+		prec, ok := precedence[op.kind]
+		if !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+
+		rhs, err := p.parseExpr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &Binary{Op: operators[op.kind], LHS: lhs, RHS: rhs, Offset: op.pos}
+	}
+}
+
+func (p *parser) parseUnary() (Node, error) {
+	switch t := p.peek(); t.kind {
+	case tokSub:
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{Expr: expr, Offset: t.pos}, nil
+	case tokAdd:
+		p.next()
+		return p.parseUnary()
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid number %s", t)}
+		}
+		return &NumberLiteral{Value: value, Offset: t.pos}, nil
+	case tokLParen:
+		expr, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(&Selector{Name: t.text, Offset: t.pos})
+	case tokString:
+		return p.parseSelector(&Selector{Name: t.text, Glob: true, Offset: t.pos})
+	}
+
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+}
+
+func (p *parser) parseCall(name token) (Node, error) {
+	p.next()
+
+	call := &Call{Func: name.text, Offset: name.pos}
+	if p.peek().kind == tokRParen {
+		p.next()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr(1)
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		t, err := p.expect(tokRParen, `"," or ")"`)
+		if err == nil {
+			return call, nil
+		}
+		if t.kind != tokComma {
+			return nil, err
+		}
+	}
+}
+
+// parseSelector parses the optional window of the selector.
+func (p *parser) parseSelector(sel *Selector) (Node, error) {
+	if p.peek().kind != tokRange {
+		return sel, nil
+	}
+
+	t := p.next()
+	window, err := time.ParseDuration(t.text)
+	if err != nil || window <= 0 {
+		return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid window %s, expected a positive duration like [5m]", t)}
+	}
+	sel.Window = window
+
+	return sel, nil
+}
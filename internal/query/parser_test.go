@@ -0,0 +1,81 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	node, err := Parse(`rate(requests[5m]) / rate("http_*"[1h30m]) * 100`)
+	require.NoError(t, err)
+
+	// умножение левоассоциативно с делением: (a / b) * 100
+	mul, ok := node.(*Binary)
+	require.True(t, ok)
+	assert.Equal(t, "*", mul.Op)
+	assert.Equal(t, &NumberLiteral{Value: 100, Offset: 45}, mul.RHS)
+
+	div, ok := mul.LHS.(*Binary)
+	require.True(t, ok)
+	assert.Equal(t, "/", div.Op)
+	assert.Equal(t, &Call{Func: "rate", Args: []Node{
+		&Selector{Name: "requests", Window: 5 * time.Minute, Offset: 5},
+	}}, div.LHS)
+	assert.Equal(t, &Call{Func: "rate", Offset: 21, Args: []Node{
+		&Selector{Name: "http_*", Glob: true, Window: 90 * time.Minute, Offset: 26},
+	}}, div.RHS)
+}
+
+func TestParse_Precedence(t *testing.T) {
+	node, err := Parse(`-a + b * 2 > _metriq.load`)
+	require.NoError(t, err)
+
+	cmp := node.(*Binary)
+	assert.Equal(t, ">", cmp.Op)
+	assert.Equal(t, &Selector{Name: "_metriq.load", Offset: 13}, cmp.RHS)
+
+	add := cmp.LHS.(*Binary)
+	assert.Equal(t, "+", add.Op)
+	assert.IsType(t, &Unary{}, add.LHS)
+	assert.Equal(t, "*", add.RHS.(*Binary).Op)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		expr string
+		pos  int
+	}{
+		{`a +`, 3},
+		{`(a + b`, 6},
+		{`rate(a[5x])`, 6},
+		{`rate(a[5m]`, 10},
+		{`a b`, 2},
+		{`"http_*`, 0},
+		{`a # b`, 2},
+		{`sum(a,)`, 6},
+		// цифры других алфавитов не начинают число
+		{`٣`, 0},
+		{`a + ٣`, 4},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		var qErr *Error
+		if assert.ErrorAs(t, err, &qErr, tt.expr) {
+			assert.Equal(t, tt.pos, qErr.Pos, "%s: %v", tt.expr, err)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, expr := range []string{`rate(http_requests[5m])`, `sum(a) / 2.5e3`, `"http_*" > .5`, `٣`, `a[`} {
+		f.Add(expr)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		// разбор любой строки завершается результатом или ошибкой
+		_, _ = Parse(expr)
+	})
+}
@@ -0,0 +1,59 @@
+// Package query implements a small PromQL-like expression language over the
+// stored metrics, for example
+//
+//	rate(requests[5m]) / rate(errors[5m])
+//	HeapInuse / HeapSys * 100
+//	sum("http_*") > 1000
+//
+// A metric is selected by its name or, quoted, by a glob with * and ?. A plain
+// selector yields the current values, a selector with a window like [5m] is
+// aggregated over the values written within the window by one of the range
+// functions: rate and sum, avg, min, max, count, last and p95 with the _over_time
+// suffix, computed by the storage. The aggregation functions sum, avg, min, max
+// and count reduce the selected metrics to a single number.
+//
+// An expression evaluates to a scalar or to a vector of metrics. Arithmetic
+// (+ - * / %) between a vector and a scalar applies to every metric, between two
+// vectors it pairs the metrics by name, a vector of one metric is paired with
+// every metric of the other side. Comparisons (== != < > <= >=) filter a vector,
+// between two scalars they yield 1 or 0.
+package query
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// Source provides the current values of metrics and the aggregations over their recent values,
+// storage.Repository implements it.
+type Source interface {
+	Metrics(ctx context.Context) (map[string]types.Metric, error)
+	Query(ctx context.Context, q storage.Query) ([]storage.Aggregate, error)
+}
+
+// Error is a syntax or type error in an expression, Pos is the offset in bytes it refers to.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+// Evaluate parses the expression and evaluates it at the time now. The current values are
+// listed by the source under the keys of the conflict policy, see types.MetricKey.
+// Errors in the expression are reported as *Error, errors of the source as they are.
+func Evaluate(ctx context.Context, src Source, conflict, expr string, now time.Time) (Value, error) {
+	node, err := Parse(expr)
+	if err != nil {
+		return Value{}, err
+	}
+
+	ev := &evaluator{ctx: ctx, src: src, conflict: conflict, now: now}
+	return ev.eval(node)
+}
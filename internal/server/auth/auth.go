@@ -0,0 +1,156 @@
+// Package auth implements bearer-token authentication with API keys. The keys
+// are loaded from a JSON file, every key has an ID, the SHA-256 hash of its
+// secret and a set of scopes:
+//
+//	{"keys": [
+//		{"id": "agent", "secret_hash": "sha256:<hex>", "scopes": ["write"]},
+//		{"id": "grafana", "secret_hash": "sha256:<hex>", "scopes": ["read"]}
+//	]}
+//
+// Only the hashes are stored on the server, the secret itself is sent by the
+// client in the "Authorization: Bearer <secret>" header. The read scope allows
+// reading metrics, the write scope allows writing them and the admin scope
+// allows everything, including the profiler.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	ScopeAdmin Scope = "admin"
+)
+
+// hashPrefix - префикс алгоритма хэширования секрета
+const hashPrefix = "sha256:"
+
+// ErrUnauthorized is returned for a missing or unknown secret.
+var ErrUnauthorized = errors.New("invalid API key")
+
+// Key is an API key as it is stored in the keys file.
+type Key struct {
+	ID         string  `json:"id"`
+	SecretHash string  `json:"secret_hash"`
+	Scopes     []Scope `json:"scopes"`
+}
+
+// Allows reports whether the key has the scope, the admin scope allows everything.
+func (k Key) Allows(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// Keys is a set of API keys indexed by the hashes of their secrets.
+type Keys struct {
+	byHash map[string]Key
+}
+
+// NewKeys checks the keys and indexes them.
+func NewKeys(keys ...Key) (*Keys, error) {
+	ks := &Keys{byHash: make(map[string]Key, len(keys))}
+	ids := make(map[string]bool, len(keys))
+
+	for _, k := range keys {
+		if k.ID == "" {
+			return nil, errors.New("the API key has no id")
+		}
+		if ids[k.ID] {
+			return nil, fmt.Errorf("duplicate API key id %q", k.ID)
+		}
+		ids[k.ID] = true
+
+		if !strings.HasPrefix(k.SecretHash, hashPrefix) {
+			return nil, fmt.Errorf("the secret hash of the API key %q must start with %q", k.ID, hashPrefix)
+		}
+		if sum, err := hex.DecodeString(strings.TrimPrefix(k.SecretHash, hashPrefix)); err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid secret hash of the API key %q", k.ID)
+		}
+		if _, ok := ks.byHash[strings.ToLower(k.SecretHash)]; ok {
+			return nil, fmt.Errorf("the API key %q has the same secret as another key", k.ID)
+		}
+
+		if len(k.Scopes) == 0 {
+			return nil, fmt.Errorf("the API key %q has no scopes", k.ID)
+		}
+		for _, s := range k.Scopes {
+			if s != ScopeRead && s != ScopeWrite && s != ScopeAdmin {
+				return nil, fmt.Errorf("unknown scope %q of the API key %q", s, k.ID)
+			}
+		}
+
+		ks.byHash[strings.ToLower(k.SecretHash)] = k
+	}
+
+	return ks, nil
+}
+
+// LoadKeys reads the keys file.
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Keys []Key `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	return NewKeys(file.Keys...)
+}
+
+// Authenticate returns the key with the given secret.
+func (ks *Keys) Authenticate(secret string) (Key, error) {
+	if secret == "" {
+		return Key{}, ErrUnauthorized
+	}
+
+	k, ok := ks.byHash[HashSecret(secret)]
+	if !ok {
+		return Key{}, ErrUnauthorized
+	}
+	return k, nil
+}
+
+// HashSecret returns the hash of the secret in the format of the keys file.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hashPrefix + hex.EncodeToString(sum[:])
+}
+
+// GenerateSecret returns a new random secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type ctxKey struct{}
+
+// WithKey returns a copy of the context carrying the authenticated key.
+func WithKey(ctx context.Context, k Key) context.Context {
+	return context.WithValue(ctx, ctxKey{}, k)
+}
+
+// FromContext returns the key the request was authenticated with.
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(ctxKey{}).(Key)
+	return k, ok
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    []Key
+		errWant bool
+	}{
+		{
+			name: "Valid keys",
+			keys: []Key{
+				{ID: "agent", SecretHash: HashSecret("a"), Scopes: []Scope{ScopeWrite}},
+				{ID: "grafana", SecretHash: HashSecret("b"), Scopes: []Scope{ScopeRead}},
+			},
+		},
+		{
+			name:    "Empty id",
+			keys:    []Key{{SecretHash: HashSecret("a"), Scopes: []Scope{ScopeRead}}},
+			errWant: true,
+		},
+		{
+			name: "Duplicate id",
+			keys: []Key{
+				{ID: "agent", SecretHash: HashSecret("a"), Scopes: []Scope{ScopeWrite}},
+				{ID: "agent", SecretHash: HashSecret("b"), Scopes: []Scope{ScopeRead}},
+			},
+			errWant: true,
+		},
+		{
+			name: "Duplicate secret",
+			keys: []Key{
+				{ID: "agent", SecretHash: HashSecret("a"), Scopes: []Scope{ScopeWrite}},
+				{ID: "grafana", SecretHash: HashSecret("a"), Scopes: []Scope{ScopeRead}},
+			},
+			errWant: true,
+		},
+		{
+			name:    "Plain secret instead of hash",
+			keys:    []Key{{ID: "agent", SecretHash: "secret", Scopes: []Scope{ScopeRead}}},
+			errWant: true,
+		},
+		{
+			name:    "Short hash",
+			keys:    []Key{{ID: "agent", SecretHash: "sha256:abcd", Scopes: []Scope{ScopeRead}}},
+			errWant: true,
+		},
+		{
+			name:    "Unknown scope",
+			keys:    []Key{{ID: "agent", SecretHash: HashSecret("a"), Scopes: []Scope{"delete"}}},
+			errWant: true,
+		},
+		{
+			name:    "No scopes",
+			keys:    []Key{{ID: "agent", SecretHash: HashSecret("a")}},
+			errWant: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewKeys(test.keys...)
+			if test.errWant {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	data := `{"keys": [{"id": "agent", "secret_hash": "` + HashSecret("s3cret") + `", "scopes": ["write"]}]}`
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+
+	ks, err := LoadKeys(path)
+	require.NoError(t, err)
+
+	k, err := ks.Authenticate("s3cret")
+	require.NoError(t, err)
+	assert.Equal(t, "agent", k.ID)
+	assert.True(t, k.Allows(ScopeWrite))
+	assert.False(t, k.Allows(ScopeRead))
+
+	_, err = ks.Authenticate("wrong")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = ks.Authenticate("")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	_, err = LoadKeys(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestKey_Allows(t *testing.T) {
+	admin := Key{ID: "root", Scopes: []Scope{ScopeAdmin}}
+	assert.True(t, admin.Allows(ScopeRead))
+	assert.True(t, admin.Allows(ScopeWrite))
+	assert.True(t, admin.Allows(ScopeAdmin))
+
+	reader := Key{ID: "grafana", Scopes: []Scope{ScopeRead}}
+	assert.False(t, reader.Allows(ScopeAdmin))
+}
+
+func TestMiddleware(t *testing.T) {
+	ks, err := NewKeys(
+		Key{ID: "agent", SecretHash: HashSecret("agent-secret"), Scopes: []Scope{ScopeWrite}},
+		Key{ID: "root", SecretHash: HashSecret("root-secret"), Scopes: []Scope{ScopeAdmin}},
+	)
+	require.NoError(t, err)
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name  string
+		scope Scope
+		auth  string
+		want  int
+	}{
+		{name: "No token", scope: ScopeWrite, want: http.StatusUnauthorized},
+		{name: "Unknown token", scope: ScopeWrite, auth: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "Basic scheme", scope: ScopeWrite, auth: "Basic agent-secret", want: http.StatusUnauthorized},
+		{name: "Scope granted", scope: ScopeWrite, auth: "Bearer agent-secret", want: http.StatusOK},
+		{name: "Lower case scheme", scope: ScopeWrite, auth: "bearer agent-secret", want: http.StatusOK},
+		{name: "Scope missing", scope: ScopeRead, auth: "Bearer agent-secret", want: http.StatusForbidden},
+		{name: "Admin", scope: ScopeAdmin, auth: "Bearer root-secret", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := WithAuth(ks)(Require(test.scope)(ok))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.auth != "" {
+				r.Header.Set("Authorization", test.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, test.want, w.Code)
+			if test.want == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		})
+	}
+}
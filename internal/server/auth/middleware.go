@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+)
+
+// bearerToken returns the token of the Authorization header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// WithAuth authenticates the requests carrying a bearer token and puts the key into the
+// request context. Requests with an unknown token are rejected with 401, requests without
+// a token are passed on, Require decides whether the route needs a key.
+func WithAuth(ks *Keys) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := bearerToken(r)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			k, err := ks.Authenticate(token)
+			if err != nil {
+				unauthorized(w)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithKey(r.Context(), k)))
+		})
+	}
+}
+
+// Require rejects the requests without a key with 401 and the requests with a key
+// lacking the scope with 403.
+func Require(scope Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized(w)
+				return
+			}
+
+			if !k.Allows(scope) {
+				http.Error(w, "the API key has no "+string(scope)+" scope", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientKey identifies the client of the request by its API key, if it has one.
+// The cumulative counters use it to tell the sources apart wherever they connect from.
+func ClientKey(r *http.Request) string {
+	if k, ok := FromContext(r.Context()); ok {
+		return "key:" + k.ID
+	}
+	return ""
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="metriq"`)
+	http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+}
@@ -1,25 +1,52 @@
 package compress
 
 import (
-	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
-	"strings"
+	"sync"
 
+	"github.com/plasmatrip/metriq/internal/compression"
 	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 )
 
+// maxPooledBuffer - буферы большего размера не возвращаются в пул
+const maxPooledBuffer = 64 << 10
+
+// compressWriter holds the response back until minSize bytes are written: a shorter response
+// is sent as is, compressing it would only cost time. A flush starts the compression early,
+// the streaming handlers do not know the size of their responses. The compressing writer is
+// taken from the pool of the coding only then, the compressWriter itself and its buffer are
+// pooled too.
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w        http.ResponseWriter
+	encoding string
+	minSize  int
+	pool     *compression.WriterPool
+
+	status  int
+	buf     []byte
+	started bool
+	zw      compression.Writer
 }
 
-func newCompressWriter(w http.ResponseWriter) *compressWriter {
-	w.Header().Set("Content-Encoding", "gzip")
-	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+var compressWriters = sync.Pool{New: func() any { return new(compressWriter) }}
+
+func newCompressWriter(w http.ResponseWriter, encoding string, minSize int, pool *compression.WriterPool) *compressWriter {
+	c := compressWriters.Get().(*compressWriter)
+	c.w, c.encoding, c.minSize, c.pool = w, encoding, minSize, pool
+	return c
+}
+
+// release returns the writer to the pool, it must not be used any more.
+func (c *compressWriter) release() {
+	buf := c.buf[:0]
+	if cap(buf) > maxPooledBuffer {
+		buf = nil
 	}
+	*c = compressWriter{buf: buf}
+	compressWriters.Put(c)
 }
 
 func (c *compressWriter) Header() http.Header {
@@ -27,72 +54,199 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(data []byte) (int, error) {
-	return c.zw.Write(data)
+	if c.started {
+		if c.zw != nil {
+			return c.zw.Write(data)
+		}
+		return c.w.Write(data)
+	}
+
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.buf = append(c.buf, data...)
+	if len(c.buf) >= c.minSize {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
-	c.w.Header().Set("Content-Encoding", "gzip")
-	c.w.WriteHeader(statusCode)
+	if c.started || c.status != 0 {
+		return
+	}
+	c.status = statusCode
 }
 
+// start writes the header and the buffered data, compressed if compress is set and the response has a body to compress.
+func (c *compressWriter) start(compress bool) error {
+	c.started = true
+
+	h := c.w.Header()
+	noBody := c.status == http.StatusNoContent || c.status == http.StatusNotModified
+	if compress && !noBody && h.Get("Content-Encoding") == "" {
+		// иначе тип содержимого определялся бы по сжатым данным
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(c.buf))
+		}
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		c.zw = c.pool.Get(c.w)
+	}
+
+	c.w.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = c.buf[:0]
+	if len(buf) == 0 {
+		return nil
+	}
+	if c.zw != nil {
+		_, err := c.zw.Write(buf)
+		return err
+	}
+	_, err := c.w.Write(buf)
+	return err
+}
+
+// Unwrap returns the original writer, http.ResponseController uses it to reach the connection.
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.w
+}
+
+// Flush writes the compressed data buffered so far to the client, streaming handlers rely on it.
+func (c *compressWriter) Flush() {
+	if !c.started {
+		if c.status == 0 {
+			c.status = http.StatusOK
+		}
+		if err := c.start(true); err != nil {
+			return
+		}
+	}
+	if c.zw != nil {
+		if err := c.zw.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(c.w).Flush()
+}
+
+// Close sends a response shorter than the threshold as is and finishes the compressed one.
 func (c *compressWriter) Close() error {
-	return c.zw.Close()
+	if !c.started {
+		// обработчик ничего не записал, ответ сформирует net/http
+		if c.status == 0 {
+			return nil
+		}
+		if err := c.start(false); err != nil {
+			return err
+		}
+	}
+	if c.zw != nil {
+		err := c.zw.Close()
+		c.pool.Put(c.zw)
+		c.zw = nil
+		return err
+	}
+	return nil
 }
 
+// compressReader decompresses the body with the codings of the request in the reverse order.
 type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+	r       io.ReadCloser
+	readers []io.ReadCloser
+	// single - место для единственной кодировки, с ней срез читателей не выделяется отдельно
+	single [1]io.ReadCloser
 }
 
-func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
-	if err != nil {
-		return nil, err
+func newCompressReader(r io.ReadCloser, codings []string) (*compressReader, error) {
+	cr := &compressReader{r: r}
+	cr.readers = cr.single[:0]
+
+	var body io.Reader = r
+	for i := len(codings) - 1; i >= 0; i-- {
+		zr, err := compression.NewReader(codings[i], body)
+		if err != nil {
+			for _, zr := range cr.readers {
+				zr.Close()
+			}
+			return nil, err
+		}
+		cr.readers = append(cr.readers, zr)
+		body = zr
 	}
 
-	return &compressReader{
-		r:  r,
-		zr: zr,
-	}, nil
+	return cr, nil
 }
 
 func (c *compressReader) Read(data []byte) (int, error) {
-	return c.zr.Read(data)
+	return c.readers[len(c.readers)-1].Read(data)
 }
 
 func (c *compressReader) Close() error {
 	if err := c.r.Close(); err != nil {
 		return err
 	}
-	return c.zr.Close()
+	for _, zr := range c.readers {
+		if err := zr.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// WithCompression устанавливает сжатие
-func WithCompression(log logger.Logger) func(next http.Handler) http.Handler {
+// WithCompression устанавливает сжатие. Кодировка ответа выбирается по Accept-Encoding с учетом q-значений,
+// ответы короче minSize байт не сжимаются, level - уровень сжатия ответов, 0 - уровень кодировки по умолчанию.
+// Распакованное тело запроса ограничено maxSize байтами, чтобы небольшой архив не разворачивался
+// в гигабайты, 0 - без ограничения.
+func WithCompression(log logger.Logger, maxSize int64, minSize int, level int) func(next http.Handler) http.Handler {
 	log.Sugar.Debug("compression started")
 
+	pools := make(map[string]*compression.WriterPool, len(compression.Encodings))
+	for _, encoding := range compression.Encodings {
+		pool, err := compression.Writers(encoding, level)
+		if err != nil {
+			log.Sugar.Infow("invalid compression level, the default one is used", "error", err)
+			pool, _ = compression.Writers(encoding, compression.DefaultLevel)
+		}
+		pools[encoding] = pool
+	}
+
 	return func(next http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			// Устанавливаем сжатие
 			ow := w
-			acceptEncoding := r.Header.Get("Accept-Encoding")
-			supportGzip := strings.Contains(acceptEncoding, "gzip")
-			if supportGzip {
-				cw := newCompressWriter(w)
+			w.Header().Add("Vary", "Accept-Encoding")
+			if encoding := compression.Negotiate(r.Header.Get("Accept-Encoding")); encoding != "" {
+				cw := newCompressWriter(w, encoding, minSize, pools[encoding])
 				defer func() {
 					if err := cw.Close(); err != nil {
 						log.Sugar.Infow("failed to close compress writer", "error", err)
 					}
+					cw.release()
 				}()
 				ow = cw
 			}
 
-			// Проверяем, что клиент отправляет сжатый контент
-			contentEncoding := r.Header.Get("Content-Encoding")
-			sendsGzip := strings.Contains(contentEncoding, "gzip")
-			if sendsGzip {
-				cr, err := newCompressReader(r.Body)
+			// Проверяем, что клиент отправляет сжатый контент
+			codings, err := compression.ParseContentEncoding(r.Header.Get("Content-Encoding"))
+			if err != nil {
+				log.Sugar.Infow("unsupported content encoding", "error", err)
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				return
+			}
+			if len(codings) > 0 {
+				cr, err := newCompressReader(r.Body, codings)
+				if maxErr := new(http.MaxBytesError); errors.As(err, &maxErr) {
+					log.Sugar.Infow("failed to create compress reader", "error", err)
+					http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+					return
+				}
 				if err != nil {
+					telemetry.DecodeErrors.Inc(codings[len(codings)-1])
 					log.Sugar.Infow("failed to create compress reader", "error", err)
 					w.WriteHeader(http.StatusInternalServerError)
 					return
@@ -103,6 +257,9 @@ func WithCompression(log logger.Logger) func(next http.Handler) http.Handler {
 					}
 				}()
 				r.Body = cr
+				if maxSize > 0 {
+					r.Body = http.MaxBytesReader(w, cr, maxSize)
+				}
 			}
 
 			next.ServeHTTP(ow, r)
@@ -0,0 +1,192 @@
+package compress
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/plasmatrip/metriq/internal/compression"
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCompression_Responses(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	long := strings.Repeat("metric ", 200)
+	handler := WithCompression(log, 0, 1024, compression.DefaultLevel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.URL.Query().Get("size") == "short" {
+			io.WriteString(w, "ok")
+			return
+		}
+		// ответ пишется частями, порог превышается не первой записью
+		io.WriteString(w, long[:100])
+		io.WriteString(w, long[100:])
+	}))
+
+	tests := []struct {
+		name           string
+		url            string
+		acceptEncoding string
+		wantEncoding   string
+		wantBody       string
+	}{
+		{name: "Long response with zstd", url: "/", acceptEncoding: "gzip, zstd", wantEncoding: compression.Zstd, wantBody: long},
+		{name: "Long response with q-values", url: "/", acceptEncoding: "zstd;q=0.1, br;q=0.9", wantEncoding: compression.Brotli, wantBody: long},
+		{name: "Long response with deflate", url: "/", acceptEncoding: "deflate", wantEncoding: compression.Deflate, wantBody: long},
+		{name: "Long response without compression", url: "/", acceptEncoding: "", wantEncoding: "", wantBody: long},
+		{name: "Short response is not compressed", url: "/?size=short", acceptEncoding: "gzip", wantEncoding: "", wantBody: "ok"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.url, nil)
+			if test.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, test.wantEncoding, res.Header.Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", res.Header.Get("Vary"))
+			assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+
+			var body io.Reader = res.Body
+			if test.wantEncoding != "" {
+				zr, err := compression.NewReader(test.wantEncoding, res.Body)
+				require.NoError(t, err)
+				defer zr.Close()
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantBody, string(got))
+		})
+	}
+}
+
+func TestWithCompression_Requests(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	data := `[{"id":"metric","type":"gauge","value":1}]`
+	handler := WithCompression(log, 0, 1024, compression.DefaultLevel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	}))
+
+	compress := func(codings ...string) []byte {
+		body := []byte(data)
+		for _, c := range codings {
+			var err error
+			body, err = compression.Compress(c, compression.DefaultLevel, body)
+			require.NoError(t, err)
+		}
+		return body
+	}
+
+	tests := []struct {
+		name            string
+		contentEncoding string
+		body            []byte
+		want            int
+	}{
+		{name: "Plain body", contentEncoding: "", body: []byte(data), want: http.StatusOK},
+		{name: "Legacy gzip", contentEncoding: "application/gzip", body: compress(compression.Gzip), want: http.StatusOK},
+		{name: "Zstd", contentEncoding: "zstd", body: compress(compression.Zstd), want: http.StatusOK},
+		{name: "Brotli", contentEncoding: "br", body: compress(compression.Brotli), want: http.StatusOK},
+		{name: "Deflate", contentEncoding: "deflate", body: compress(compression.Deflate), want: http.StatusOK},
+		{name: "Several codings", contentEncoding: "deflate, zstd", body: compress(compression.Deflate, compression.Zstd), want: http.StatusOK},
+		{name: "Unsupported coding", contentEncoding: "compress", body: []byte(data), want: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(test.body))
+			if test.contentEncoding != "" {
+				r.Header.Set("Content-Encoding", test.contentEncoding)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, test.want, w.Code)
+			if test.want == http.StatusOK {
+				assert.Equal(t, data, w.Body.String())
+			}
+		})
+	}
+}
+
+// BenchmarkWithCompression measures the middleware on responses below and above the threshold
+// and on compressed requests, with the writers, readers and buffers taken from the pools.
+func BenchmarkWithCompression(b *testing.B) {
+	log, err := logger.NewLogger()
+	require.NoError(b, err)
+
+	batch := bytes.Repeat([]byte(`{"id":"metric","type":"gauge","value":12.5},`), 1000)
+	long := strings.Repeat("metric ", 1000)
+
+	tests := []struct {
+		name     string
+		response string
+		encoding string
+	}{
+		{name: "empty response", response: ""},
+		{name: "short response", response: "ok"},
+		{name: "long response", response: long},
+		{name: "gzip request", encoding: compression.Gzip},
+		{name: "zstd request", encoding: compression.Zstd},
+	}
+
+	// запрос и ответ httptest без middleware, от них отсчитываются выделения памяти middleware
+	b.Run("handler only", func(b *testing.B) {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		})
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(nil))
+			r.Header.Set("Accept-Encoding", "gzip")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+		}
+	})
+
+	for _, test := range tests {
+		var body []byte
+		if test.encoding != "" {
+			body, err = compression.Compress(test.encoding, compression.DefaultLevel, batch)
+			require.NoError(b, err)
+		}
+
+		handler := WithCompression(log, 0, 1024, compression.DefaultLevel)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			io.WriteString(w, test.response)
+		}))
+
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewReader(body))
+				r.Header.Set("Accept-Encoding", "gzip")
+				if test.encoding != "" {
+					r.Header.Set("Content-Encoding", test.encoding)
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), r)
+			}
+		})
+	}
+}
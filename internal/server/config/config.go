@@ -2,16 +2,22 @@ package config
 
 import (
 	"crypto/rsa"
+	"crypto/tls"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/plasmatrip/metriq/internal/compression"
+	"github.com/plasmatrip/metriq/internal/server/auth"
 	"github.com/plasmatrip/metriq/internal/server/cert"
+	"github.com/plasmatrip/metriq/internal/types"
 )
 
 const (
@@ -23,21 +29,81 @@ const (
 	retryInterval      = time.Second * 2
 	startRetryInterval = time.Second * 1
 	maxRetries         = 3
+	statsdFlush        = 10
+	graphiteMaxConns   = 100
+	graphiteTimeout    = 60
+	otlpResourceAttrs  = "service.name"
+	shutdownTimeout    = 30
+	drainDelay         = 5
+	selfMetricsPrefix  = "_metriq."
+	maxBodySize        = 10 << 20
+	maxDecompressed    = 100 << 20
+	compressMinSize    = 1024
+	maxBatchSize       = 10000
+	readHeaderTimeout  = 5
+	readTimeout        = 30
+	idleTimeout        = 120
+	batchIDCacheSize   = 10000
+	batchIDTTL         = 600
+	sampleRetention    = 3600
+	// maxDBNameLength - длина столбца id VARCHAR(128) таблиц метрик
+	maxDBNameLength = 128
 )
 
 type Config struct {
-	ConfFile           string `env:"CONFIG"`            // путь к конфигурационному File
-	Host               string `env:"ADDRESS"`           // адрес сервера
-	StoreInterval      int    `env:"STORE_INTERVAL"`    // интервал сохранения метрик
-	FileStoragePath    string `env:"FILE_STORAGE_PATH"` // путь к файлу c метриками
-	Restore            bool   `env:"RESTORE"`           // загружать ли сохраненные метрики
-	DSN                string `env:"DATABASE_DSN"`      // подключение к бд
-	Key                string `env:"KEY"`               // ключ для вычисления хэша по SHA256
-	CryptoKeyPath      string `env:"CRYPTO_KEY"`        // путь к секретному ключу
-	CryptoKey          *rsa.PrivateKey
-	RetryInterval      time.Duration // увеличиваем интервал в сек между попытками повторного коннекта с бд
-	StartRetryInterval time.Duration // начиниаем повторную попытку коннекта с бд через сек
-	MaxRetries         int           // максимальное количество попыток повторного коннекта с бд
+	ConfFile            string `env:"CONFIG"`            // путь к конфигурационному File
+	Host                string `env:"ADDRESS"`           // адрес сервера
+	StoreInterval       int    `env:"STORE_INTERVAL"`    // интервал сохранения метрик
+	FileStoragePath     string `env:"FILE_STORAGE_PATH"` // путь к файлу c метриками
+	Restore             bool   `env:"RESTORE"`           // загружать ли сохраненные метрики
+	DSN                 string `env:"DATABASE_DSN"`      // подключение к бд
+	Key                 string `env:"KEY"`               // ключ для вычисления хэша по SHA256
+	CryptoKeyPath       string `env:"CRYPTO_KEY"`        // путь к секретному ключу
+	CryptoKey           *rsa.PrivateKey
+	TrustedSubnet       string `env:"TRUSTED_SUBNET" json:"trusted_subnet"` // доверенная подсеть агентов в формате CIDR
+	TrustedNet          *net.IPNet
+	StatsdAddr          string            `env:"STATSD_ADDRESS"`           // адрес UDP-слушателя StatsD
+	StatsdFlushInterval int               `env:"STATSD_FLUSH_INTERVAL"`    // интервал в сек сброса агрегированных метрик StatsD
+	GraphiteAddr        string            `env:"GRAPHITE_ADDRESS"`         // адрес TCP-слушателя Graphite
+	GraphiteRules       string            `env:"GRAPHITE_RULES"`           // правила выбора типа метрики Graphite: шаблон=тип через запятую
+	GraphiteMaxConns    int               `env:"GRAPHITE_MAX_CONNS"`       // максимальное количество одновременных соединений Graphite
+	GraphiteReadTimeout int               `env:"GRAPHITE_READ_TIMEOUT"`    // таймаут в сек чтения строки из соединения Graphite
+	GRPCAddr            string            `env:"GRPC_ADDRESS"`             // адрес gRPC-сервера
+	OTLPResourceAttrs   string            `env:"OTLP_RESOURCE_ATTRIBUTES"` // атрибуты ресурса OTLP, добавляемые к имени метрики
+	OTLPLabels          bool              `env:"OTLP_LABELS"`              // добавлять атрибуты OTLP к имени в виде меток {k="v"}, а не префикса
+	TLSCert             string            `env:"TLS_CERT"`                 // путь к сертификату сервера для HTTPS
+	TLSKey              string            `env:"TLS_KEY"`                  // путь к ключу сертификата сервера
+	TLSClientCA         string            `env:"TLS_CLIENT_CA"`            // путь к CA для проверки клиентских сертификатов (mTLS)
+	ShutdownTimeout     int               `env:"SHUTDOWN_TIMEOUT"`         // таймаут в сек завершения обработки запросов при остановке сервера
+	DrainDelay          int               `env:"DRAIN_DELAY"`              // время в сек между сообщением о неготовности в /readyz и остановкой сервера
+	SelfMetricsInterval int               `env:"SELF_METRICS_INTERVAL"`    // интервал в сек записи собственных метрик сервера в хранилище, 0 - не записывать
+	SelfMetricsPrefix   string            `env:"SELF_METRICS_PREFIX"`      // зарезервированный префикс имен собственных метрик сервера
+	MaxBodySize         int64             `env:"MAX_BODY_SIZE"`            // максимальный размер тела запроса в байтах
+	MaxDecompressedSize int64             `env:"MAX_DECOMPRESSED_SIZE"`    // максимальный размер распакованного тела запроса в байтах
+	CompressMinSize     int               `env:"COMPRESS_MIN_SIZE"`        // минимальный размер ответа в байтах, который сжимается
+	CompressLevel       int               `env:"COMPRESS_LEVEL"`           // уровень сжатия ответов, 0 - уровень кодировки по умолчанию
+	MaxBatchSize        int               `env:"MAX_BATCH_SIZE"`           // максимальное количество метрик в одном пакете
+	RateLimit           float64           `env:"RATE_LIMIT"`               // допустимое количество запросов в сек от одного клиента, 0 - без ограничения
+	RateBurst           int               `env:"RATE_BURST"`               // количество запросов клиента, принимаемых сверх RateLimit подряд
+	ReadHeaderTimeout   int               `env:"READ_HEADER_TIMEOUT"`      // таймаут в сек чтения заголовков запроса
+	ReadTimeout         int               `env:"READ_TIMEOUT"`             // таймаут в сек чтения запроса целиком
+	IdleTimeout         int               `env:"IDLE_TIMEOUT"`             // таймаут в сек ожидания следующего запроса в keep-alive соединении
+	APIKeysFile         string            `env:"API_KEYS_FILE"`            // путь к файлу с API-ключами, без него аутентификация отключена
+	BatchIDCacheSize    int               `env:"BATCH_ID_CACHE_SIZE"`      // количество запоминаемых идентификаторов примененных пакетов метрик
+	BatchIDTTL          int               `env:"BATCH_ID_TTL"`             // время в сек хранения идентификатора примененного пакета метрик
+	SampleRetention     int               `env:"SAMPLE_RETENTION"`         // время в сек хранения записанных значений метрик для запросов с агрегацией
+	NameMode            string            `env:"NAME_MODE"`                // reject - отклонять недопустимые имена метрик, sanitize - исправлять их
+	NameChars           string            `env:"NAME_CHARS"`               // класс допустимых символов имени метрики, например [A-Za-z0-9_.]
+	NameMaxLength       int               `env:"NAME_MAX_LENGTH"`          // максимальная длина имени метрики в символах
+	NameReservedPrefix  string            `env:"NAME_RESERVED_PREFIXES"`   // запрещенные для клиентов префиксы имен метрик через запятую
+	NameCase            string            `env:"NAME_CASE"`                // keep, lower или upper - приведение регистра имени метрики
+	TypeConflict        string            `env:"TYPE_CONFLICT"`            // reject, namespace или overwrite - запись метрики другого типа под занятым именем
+	RetryInterval       time.Duration     // увеличиваем интервал в сек между попытками повторного коннекта с бд
+	StartRetryInterval  time.Duration     // начиниаем повторную попытку коннекта с бд через сек
+	MaxRetries          int               // максимальное количество попыток повторного коннекта с бд
+	TLS                 *tls.Config       // настройки TLS сервера, если задан сертификат
+	APIKeys             *auth.Keys        // API-ключи клиентов, если задан файл с ключами
+	Names               *types.NamePolicy // правила имен метрик, применяемые всеми способами приема метрик
 }
 
 func NewConfig() (*Config, error) {
@@ -49,11 +115,6 @@ func NewConfig() (*Config, error) {
 
 	cl := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 
-	// читаем переменные окружения, при ошибке прокидываем ее наверх
-	if err := env.Parse(cfg); err != nil {
-		return nil, fmt.Errorf("failed to read environment variable: %w", err)
-	}
-
 	var fConfig string
 	cl.StringVar(&fConfig, "c", "", "path to the configuration file")
 	cl.StringVar(&fConfig, "config", "", "path to the configuration file")
@@ -79,15 +140,128 @@ func NewConfig() (*Config, error) {
 	var fCryptoKeyPath string
 	cl.StringVar(&fCryptoKeyPath, "crypto-key", "", "the key for encrypting metrics")
 
+	var fTrustedSubnet string
+	cl.StringVar(&fTrustedSubnet, "t", "", "trusted subnet of the agents in CIDR notation, metric writes from other addresses are rejected")
+
+	var fStatsdAddr string
+	cl.StringVar(&fStatsdAddr, "statsd-addr", "", "UDP address of the StatsD listener, disabled if empty")
+
+	var fStatsdFlushInterval int
+	cl.IntVar(&fStatsdFlushInterval, "statsd-flush-interval", statsdFlush, "time interval in seconds for flushing aggregated StatsD metrics")
+
+	var fGraphiteAddr string
+	cl.StringVar(&fGraphiteAddr, "graphite-addr", "", "TCP address of the Graphite plaintext listener, disabled if empty")
+
+	var fGraphiteRules string
+	cl.StringVar(&fGraphiteRules, "graphite-rules", "", "comma separated pattern=type rules for Graphite paths, e.g. *.requests=counter")
+
+	var fGraphiteMaxConns int
+	cl.IntVar(&fGraphiteMaxConns, "graphite-max-conns", graphiteMaxConns, "maximum number of concurrent Graphite connections")
+
+	var fGraphiteReadTimeout int
+	cl.IntVar(&fGraphiteReadTimeout, "graphite-read-timeout", graphiteTimeout, "time in seconds to wait for a line from a Graphite connection")
+
+	var fGRPCAddr string
+	cl.StringVar(&fGRPCAddr, "grpc-addr", "", "address of the gRPC server host:port, disabled if empty")
+
+	var fOTLPResourceAttrs string
+	cl.StringVar(&fOTLPResourceAttrs, "otlp-resource-attrs", otlpResourceAttrs, "comma separated OTLP resource attributes added to metric names")
+
+	var fOTLPLabels bool
+	cl.BoolVar(&fOTLPLabels, "otlp-labels", false, "add OTLP attributes to metric names as {key=\"value\"} labels instead of a dotted prefix")
+
+	var fTLSCert string
+	cl.StringVar(&fTLSCert, "tls-cert", "", "path to the server certificate, HTTPS is enabled if set")
+
+	var fTLSKey string
+	cl.StringVar(&fTLSKey, "tls-key", "", "path to the private key of the server certificate")
+
+	var fTLSClientCA string
+	cl.StringVar(&fTLSClientCA, "tls-client-ca", "", "path to the CA bundle for verifying client certificates, mutual TLS is enabled if set")
+
+	var fShutdownTimeout int
+	cl.IntVar(&fShutdownTimeout, "shutdown-timeout", shutdownTimeout, "time in seconds to wait for in-flight requests on shutdown")
+
+	var fDrainDelay int
+	cl.IntVar(&fDrainDelay, "drain-delay", drainDelay, "time in seconds /readyz reports not ready before the server stops accepting requests")
+
+	var fSelfMetricsInterval int
+	cl.IntVar(&fSelfMetricsInterval, "self-metrics-interval", 0, "time interval in seconds for writing the server's own metrics into the storage, disabled if 0")
+
+	var fSelfMetricsPrefix string
+	cl.StringVar(&fSelfMetricsPrefix, "self-metrics-prefix", selfMetricsPrefix, "name prefix of the server's own metrics, reserved for them when they are written")
+
+	var fMaxBodySize int64
+	cl.Int64Var(&fMaxBodySize, "max-body-size", maxBodySize, "maximum size of a request body in bytes")
+
+	var fMaxDecompressedSize int64
+	cl.Int64Var(&fMaxDecompressedSize, "max-decompressed-size", maxDecompressed, "maximum size of a compressed request body after decompression in bytes")
+
+	var fCompressMinSize int
+	cl.IntVar(&fCompressMinSize, "compress-min-size", compressMinSize, "minimum size of a response in bytes to be compressed")
+
+	var fCompressLevel int
+	cl.IntVar(&fCompressLevel, "compress-level", 0, "compression level of the responses, 1-9 for all the codings or 0 for the default level of each")
+
+	var fMaxBatchSize int
+	cl.IntVar(&fMaxBatchSize, "max-batch-size", maxBatchSize, "maximum number of metrics in one batch")
+
+	var fRateLimit float64
+	cl.Float64Var(&fRateLimit, "rate-limit", 0, "number of requests per second allowed from one client, disabled if 0")
+
+	var fRateBurst int
+	cl.IntVar(&fRateBurst, "rate-burst", 0, "number of requests a client may send at once, defaults to the rate limit")
+
+	var fReadHeaderTimeout int
+	cl.IntVar(&fReadHeaderTimeout, "read-header-timeout", readHeaderTimeout, "time in seconds to read the request headers")
+
+	var fReadTimeout int
+	cl.IntVar(&fReadTimeout, "read-timeout", readTimeout, "time in seconds to read the entire request")
+
+	var fIdleTimeout int
+	cl.IntVar(&fIdleTimeout, "idle-timeout", idleTimeout, "time in seconds to wait for the next request on a keep-alive connection")
+
+	var fAPIKeysFile string
+	cl.StringVar(&fAPIKeysFile, "api-keys", "", "path to the JSON file with the API keys, authentication is disabled if empty")
+
+	var fBatchIDCacheSize int
+	cl.IntVar(&fBatchIDCacheSize, "batch-id-cache-size", batchIDCacheSize, "number of applied batch IDs kept in memory to acknowledge duplicates")
+
+	var fBatchIDTTL int
+	cl.IntVar(&fBatchIDTTL, "batch-id-ttl", batchIDTTL, "time in seconds to remember the ID of an applied batch")
+
+	var fSampleRetention int
+	cl.IntVar(&fSampleRetention, "sample-retention", sampleRetention, "time in seconds to keep the written values of metrics for aggregation queries")
+
+	var fNameMode string
+	cl.StringVar(&fNameMode, "name-mode", types.NameReject, "what to do with the metric names breaking the rules: reject or sanitize")
+
+	var fNameChars string
+	cl.StringVar(&fNameChars, "name-chars", types.DefaultNameChars, "regular expression character class of the characters allowed in metric names")
+
+	var fNameMaxLength int
+	cl.IntVar(&fNameMaxLength, "name-max-length", types.DefaultNameMaxLength, "maximum length of a metric name in characters")
+
+	var fNameReservedPrefix string
+	cl.StringVar(&fNameReservedPrefix, "name-reserved-prefixes", "", "comma separated metric name prefixes the clients may not use")
+
+	var fNameCase string
+	cl.StringVar(&fNameCase, "name-case", types.CaseKeep, "case normalization of metric names: keep, lower or upper")
+
+	var fTypeConflict string
+	cl.StringVar(&fTypeConflict, "type-conflict", types.ConflictReject, "what a write of a metric under a name holding another type does: reject, namespace or overwrite")
+
 	if err := cl.Parse(os.Args[1:]); err != nil {
 		return nil, fmt.Errorf("failed to parse flags: %w", err)
 	}
 
-	if _, exist := os.LookupEnv("CONFIG"); !exist {
-		cfg.ConfFile = fConfig
+	cfg.ConfFile = fConfig
+	if file, exist := os.LookupEnv("CONFIG"); exist {
+		cfg.ConfFile = file
 	}
 
-	// читаем конфигурационный файл
+	// читаем конфигурационный файл, запоминая заданные в нем поля
+	inFile := make(map[string]bool)
 	if cfg.ConfFile != "" {
 		data, err := os.ReadFile(cfg.ConfFile)
 		if err != nil {
@@ -98,36 +272,358 @@ func NewConfig() (*Config, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
 		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal config file: %w", err)
+		}
+		for key := range fields {
+			inFile[strings.ToLower(key)] = true
+		}
+	}
+
+	// читаем переменные окружения после файла, они важнее него, при ошибке прокидываем ее наверх
+	if err := env.Parse(cfg); err != nil {
+		return nil, fmt.Errorf("failed to read environment variable: %w", err)
+	}
+
+	set := make(map[string]bool)
+	cl.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	// fromFlag reports whether the field takes the value of the flag. The environment variable takes
+	// precedence over the flag, the flag takes precedence over the configuration file only if it is set.
+	fromFlag := func(variable, name, key string) bool {
+		if _, exist := os.LookupEnv(variable); exist {
+			return false
+		}
+		return set[name] || !inFile[key]
 	}
 
-	if _, exist := os.LookupEnv("ADDRESS"); !exist {
+	if fromFlag("ADDRESS", "a", "host") {
 		cfg.Host = fHost
 	}
 
-	if _, exist := os.LookupEnv("STORE_INTERVAL"); !exist {
+	if fromFlag("STORE_INTERVAL", "i", "storeinterval") {
 		cfg.StoreInterval = fStoreInterval
 	}
 
-	if _, exist := os.LookupEnv("FILE_STORAGE_PATH"); !exist {
+	if fromFlag("FILE_STORAGE_PATH", "f", "filestoragepath") {
 		cfg.FileStoragePath = fFileStoragePath
 	}
 
-	if _, exist := os.LookupEnv("RESTORE"); !exist {
+	if fromFlag("RESTORE", "r", "restore") {
 		cfg.Restore = fRestore
 	}
 
-	if _, exist := os.LookupEnv("DATABASE_DSN"); !exist {
+	if fromFlag("DATABASE_DSN", "d", "dsn") {
 		cfg.DSN = fDSN
 	}
 
-	if _, exist := os.LookupEnv("KEY"); !exist {
+	if fromFlag("KEY", "k", "key") {
 		cfg.Key = fKey
 	}
 
-	if _, exist := os.LookupEnv("CRYPTO_KEY"); !exist {
+	if fromFlag("CRYPTO_KEY", "crypto-key", "cryptokeypath") {
 		cfg.CryptoKeyPath = fCryptoKeyPath
 	}
 
+	if fromFlag("TRUSTED_SUBNET", "t", "trusted_subnet") {
+		cfg.TrustedSubnet = fTrustedSubnet
+	}
+
+	if cfg.TrustedSubnet != "" {
+		_, trustedNet, err := net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trusted subnet: %w", err)
+		}
+		cfg.TrustedNet = trustedNet
+	}
+
+	if fromFlag("STATSD_ADDRESS", "statsd-addr", "statsdaddr") {
+		cfg.StatsdAddr = fStatsdAddr
+	}
+
+	if fromFlag("STATSD_FLUSH_INTERVAL", "statsd-flush-interval", "statsdflushinterval") {
+		cfg.StatsdFlushInterval = fStatsdFlushInterval
+	}
+
+	if cfg.StatsdFlushInterval <= 0 {
+		cfg.StatsdFlushInterval = statsdFlush
+	}
+
+	if fromFlag("GRAPHITE_ADDRESS", "graphite-addr", "graphiteaddr") {
+		cfg.GraphiteAddr = fGraphiteAddr
+	}
+
+	if fromFlag("GRAPHITE_RULES", "graphite-rules", "graphiterules") {
+		cfg.GraphiteRules = fGraphiteRules
+	}
+
+	if fromFlag("GRAPHITE_MAX_CONNS", "graphite-max-conns", "graphitemaxconns") {
+		cfg.GraphiteMaxConns = fGraphiteMaxConns
+	}
+
+	if fromFlag("GRAPHITE_READ_TIMEOUT", "graphite-read-timeout", "graphitereadtimeout") {
+		cfg.GraphiteReadTimeout = fGraphiteReadTimeout
+	}
+
+	if fromFlag("GRPC_ADDRESS", "grpc-addr", "grpcaddr") {
+		cfg.GRPCAddr = fGRPCAddr
+	}
+
+	if fromFlag("OTLP_RESOURCE_ATTRIBUTES", "otlp-resource-attrs", "otlpresourceattrs") {
+		cfg.OTLPResourceAttrs = fOTLPResourceAttrs
+	}
+
+	if fromFlag("OTLP_LABELS", "otlp-labels", "otlplabels") {
+		cfg.OTLPLabels = fOTLPLabels
+	}
+
+	if fromFlag("TLS_CERT", "tls-cert", "tlscert") {
+		cfg.TLSCert = fTLSCert
+	}
+
+	if fromFlag("TLS_KEY", "tls-key", "tlskey") {
+		cfg.TLSKey = fTLSKey
+	}
+
+	if fromFlag("TLS_CLIENT_CA", "tls-client-ca", "tlsclientca") {
+		cfg.TLSClientCA = fTLSClientCA
+	}
+
+	if cfg.TLSCert != "" || cfg.TLSKey != "" || cfg.TLSClientCA != "" {
+		if cfg.TLSCert == "" || cfg.TLSKey == "" {
+			return nil, fmt.Errorf("both the TLS certificate and key are required")
+		}
+
+		var err error
+		cfg.TLS, err = cert.TLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+	}
+
+	if fromFlag("SHUTDOWN_TIMEOUT", "shutdown-timeout", "shutdowntimeout") {
+		cfg.ShutdownTimeout = fShutdownTimeout
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = shutdownTimeout
+	}
+
+	if fromFlag("DRAIN_DELAY", "drain-delay", "draindelay") {
+		cfg.DrainDelay = fDrainDelay
+	}
+
+	if cfg.DrainDelay < 0 {
+		return nil, fmt.Errorf("the drain delay must not be negative")
+	}
+
+	if fromFlag("SELF_METRICS_INTERVAL", "self-metrics-interval", "selfmetricsinterval") {
+		cfg.SelfMetricsInterval = fSelfMetricsInterval
+	}
+
+	if fromFlag("SELF_METRICS_PREFIX", "self-metrics-prefix", "selfmetricsprefix") {
+		cfg.SelfMetricsPrefix = fSelfMetricsPrefix
+	}
+
+	if cfg.SelfMetricsPrefix == "" {
+		cfg.SelfMetricsPrefix = selfMetricsPrefix
+	}
+
+	if fromFlag("MAX_BODY_SIZE", "max-body-size", "maxbodysize") {
+		cfg.MaxBodySize = fMaxBodySize
+	}
+
+	if cfg.MaxBodySize <= 0 {
+		cfg.MaxBodySize = maxBodySize
+	}
+
+	if fromFlag("MAX_DECOMPRESSED_SIZE", "max-decompressed-size", "maxdecompressedsize") {
+		cfg.MaxDecompressedSize = fMaxDecompressedSize
+	}
+
+	if cfg.MaxDecompressedSize <= 0 {
+		cfg.MaxDecompressedSize = maxDecompressed
+	}
+
+	if fromFlag("COMPRESS_MIN_SIZE", "compress-min-size", "compressminsize") {
+		cfg.CompressMinSize = fCompressMinSize
+	}
+
+	if cfg.CompressMinSize <= 0 {
+		cfg.CompressMinSize = compressMinSize
+	}
+
+	if fromFlag("COMPRESS_LEVEL", "compress-level", "compresslevel") {
+		cfg.CompressLevel = fCompressLevel
+	}
+
+	// кодировка ответа выбирается клиентом, уровень должен подходить для любой из них
+	for _, encoding := range compression.Encodings {
+		if err := compression.CheckLevel(encoding, cfg.CompressLevel); err != nil {
+			return nil, err
+		}
+	}
+
+	if fromFlag("MAX_BATCH_SIZE", "max-batch-size", "maxbatchsize") {
+		cfg.MaxBatchSize = fMaxBatchSize
+	}
+
+	if cfg.MaxBatchSize <= 0 {
+		cfg.MaxBatchSize = maxBatchSize
+	}
+
+	if fromFlag("RATE_LIMIT", "rate-limit", "ratelimit") {
+		cfg.RateLimit = fRateLimit
+	}
+
+	if fromFlag("RATE_BURST", "rate-burst", "rateburst") {
+		cfg.RateBurst = fRateBurst
+	}
+
+	if cfg.RateLimit < 0 {
+		return nil, fmt.Errorf("the rate limit must not be negative")
+	}
+
+	// по умолчанию клиент может отправить подряд столько запросов, сколько допускается в секунду
+	if cfg.RateLimit > 0 && cfg.RateBurst <= 0 {
+		cfg.RateBurst = int(math.Ceil(cfg.RateLimit))
+	}
+
+	if fromFlag("READ_HEADER_TIMEOUT", "read-header-timeout", "readheadertimeout") {
+		cfg.ReadHeaderTimeout = fReadHeaderTimeout
+	}
+
+	if cfg.ReadHeaderTimeout <= 0 {
+		cfg.ReadHeaderTimeout = readHeaderTimeout
+	}
+
+	if fromFlag("READ_TIMEOUT", "read-timeout", "readtimeout") {
+		cfg.ReadTimeout = fReadTimeout
+	}
+
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = readTimeout
+	}
+
+	if fromFlag("IDLE_TIMEOUT", "idle-timeout", "idletimeout") {
+		cfg.IdleTimeout = fIdleTimeout
+	}
+
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = idleTimeout
+	}
+
+	if fromFlag("API_KEYS_FILE", "api-keys", "apikeysfile") {
+		cfg.APIKeysFile = fAPIKeysFile
+	}
+
+	if cfg.APIKeysFile != "" {
+		var err error
+		cfg.APIKeys, err = auth.LoadKeys(cfg.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API keys: %w", err)
+		}
+	}
+
+	if fromFlag("BATCH_ID_CACHE_SIZE", "batch-id-cache-size", "batchidcachesize") {
+		cfg.BatchIDCacheSize = fBatchIDCacheSize
+	}
+
+	if cfg.BatchIDCacheSize <= 0 {
+		cfg.BatchIDCacheSize = batchIDCacheSize
+	}
+
+	if fromFlag("BATCH_ID_TTL", "batch-id-ttl", "batchidttl") {
+		cfg.BatchIDTTL = fBatchIDTTL
+	}
+
+	if cfg.BatchIDTTL <= 0 {
+		cfg.BatchIDTTL = batchIDTTL
+	}
+
+	if fromFlag("SAMPLE_RETENTION", "sample-retention", "sampleretention") {
+		cfg.SampleRetention = fSampleRetention
+	}
+
+	if cfg.SampleRetention <= 0 {
+		cfg.SampleRetention = sampleRetention
+	}
+
+	if fromFlag("NAME_MODE", "name-mode", "namemode") {
+		cfg.NameMode = fNameMode
+	}
+
+	if fromFlag("NAME_CHARS", "name-chars", "namechars") {
+		cfg.NameChars = fNameChars
+	}
+
+	if fromFlag("NAME_MAX_LENGTH", "name-max-length", "namemaxlength") {
+		cfg.NameMaxLength = fNameMaxLength
+	}
+
+	if cfg.NameMaxLength <= 0 {
+		cfg.NameMaxLength = types.DefaultNameMaxLength
+	}
+
+	if fromFlag("TYPE_CONFLICT", "type-conflict", "typeconflict") {
+		cfg.TypeConflict = fTypeConflict
+	}
+
+	if err := types.CheckTypeConflict(cfg.TypeConflict); err != nil {
+		return nil, err
+	}
+
+	// более длинное имя база данных не сохранит, у каждого типа свои имена хранятся с префиксом типа
+	maxNameLength := maxDBNameLength
+	if cfg.TypeConflict == types.ConflictNamespace {
+		maxNameLength -= len(types.MetricKey(types.ConflictNamespace, types.Counter, ""))
+		if cfg.DSN != "" && cfg.NameMaxLength == types.DefaultNameMaxLength {
+			cfg.NameMaxLength = maxNameLength
+		}
+	}
+	if cfg.DSN != "" && cfg.NameMaxLength > maxNameLength {
+		return nil, fmt.Errorf("the metric names stored in the database are limited to %d characters", maxNameLength)
+	}
+
+	if fromFlag("NAME_RESERVED_PREFIXES", "name-reserved-prefixes", "namereservedprefix") {
+		cfg.NameReservedPrefix = fNameReservedPrefix
+	}
+
+	if fromFlag("NAME_CASE", "name-case", "namecase") {
+		cfg.NameCase = fNameCase
+	}
+
+	var reserved []string
+	for _, prefix := range strings.Split(cfg.NameReservedPrefix, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			reserved = append(reserved, prefix)
+		}
+	}
+
+	names, err := types.NewNamePolicy(types.NameRules{
+		Mode:             cfg.NameMode,
+		Chars:            cfg.NameChars,
+		MaxLength:        cfg.NameMaxLength,
+		ReservedPrefixes: reserved,
+		Case:             cfg.NameCase,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid metric name rules: %w", err)
+	}
+	cfg.Names = names
+
+	if cfg.GraphiteMaxConns <= 0 {
+		cfg.GraphiteMaxConns = graphiteMaxConns
+	}
+
+	if cfg.GraphiteReadTimeout <= 0 {
+		cfg.GraphiteReadTimeout = graphiteTimeout
+	}
+
 	if cfg.CryptoKey != nil {
 		var err error
 		cfg.CryptoKey, err = cert.LoadPrivateKey(cfg.CryptoKeyPath)
@@ -1,13 +1,24 @@
 package config
 
 import (
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/plasmatrip/metriq/internal/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// defaultNames - правила имен метрик по умолчанию
+var defaultNames, _ = types.NewNamePolicy(types.NameRules{
+	Mode:      types.NameReject,
+	Chars:     types.DefaultNameChars,
+	MaxLength: types.DefaultNameMaxLength,
+	Case:      types.CaseKeep,
+})
+
 func TestConfig_Server_ParseAddress(t *testing.T) {
 	tests := []struct {
 		name  string
@@ -61,15 +72,38 @@ func TestConfig_Server_NewConfig_env(t *testing.T) {
 			name: "Valid server address",
 			env:  map[string]string{"ADDRESS": "server.com:8585"},
 			want: Config{
-				Host:               "server.com:8585",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "server.com:8585",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -83,18 +117,94 @@ func TestConfig_Server_NewConfig_env(t *testing.T) {
 			name: "Valid store interval",
 			env:  map[string]string{"STORE_INTERVAL": "100"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      100,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       100,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
+		{
+			name: "Valid trusted subnet",
+			env:  map[string]string{"TRUSTED_SUBNET": "192.168.1.0/24"},
+			want: Config{
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				TrustedSubnet:       "192.168.1.0/24",
+				TrustedNet:          &net.IPNet{IP: net.IP{192, 168, 1, 0}, Mask: net.CIDRMask(24, 32)},
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
+			},
+			errWant: false,
+		},
+		{
+			name:    "Invalid trusted subnet",
+			env:     map[string]string{"TRUSTED_SUBNET": "192.168.1.0"},
+			want:    Config{},
+			errWant: true,
+		},
+		{
+			name:    "Compression level out of range of a coding",
+			env:     map[string]string{"COMPRESS_LEVEL": "10"},
+			want:    Config{},
+			errWant: true,
+		},
 		{
 			name:    "Invalid store interval",
 			env:     map[string]string{"STORE_INTERVAL": "ttt"},
@@ -105,15 +215,38 @@ func TestConfig_Server_NewConfig_env(t *testing.T) {
 			name: "Valid file storage",
 			env:  map[string]string{"FILE_STORAGE_PATH": "file.dat"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "file.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "file.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -121,18 +254,120 @@ func TestConfig_Server_NewConfig_env(t *testing.T) {
 			name: "Valid restore",
 			env:  map[string]string{"RESTORE": "false"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            false,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             false,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
+			},
+			errWant: false,
+		},
+		{
+			name:    "TLS certificate without key",
+			env:     map[string]string{"TLS_CERT": "cert.pem"},
+			errWant: true,
+		},
+		{
+			name:    "Missing TLS certificate files",
+			env:     map[string]string{"TLS_CERT": "missing-cert.pem", "TLS_KEY": "missing-key.pem"},
+			errWant: true,
+		},
+		{
+			name: "Rate limit with default burst",
+			env:  map[string]string{"RATE_LIMIT": "2.5", "MAX_BATCH_SIZE": "500"},
+			want: Config{
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        500,
+				RateLimit:           2.5,
+				RateBurst:           3,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
+		{
+			name:    "Negative rate limit",
+			env:     map[string]string{"RATE_LIMIT": "-1"},
+			errWant: true,
+		},
+		{
+			name:    "Unknown name mode",
+			env:     map[string]string{"NAME_MODE": "ignore"},
+			errWant: true,
+		},
+		{
+			name:    "Name character class of several characters",
+			env:     map[string]string{"NAME_CHARS": "[a-z]+"},
+			errWant: true,
+		},
+		{
+			name:    "Names longer than the database column",
+			env:     map[string]string{"DATABASE_DSN": "postgres://localhost/metrics", "NAME_MAX_LENGTH": "256"},
+			errWant: true,
+		},
+		{
+			name:    "Unknown type conflict policy",
+			env:     map[string]string{"TYPE_CONFLICT": "merge"},
+			errWant: true,
+		},
+		{
+			name:    "Names with the type prefix longer than the database column",
+			env:     map[string]string{"DATABASE_DSN": "postgres://localhost/metrics", "TYPE_CONFLICT": "namespace", "NAME_MAX_LENGTH": "125"},
+			errWant: true,
+		},
 	}
 
 	for _, test := range tests {
@@ -166,15 +401,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Valid config",
 			args: []string{},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -182,15 +440,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Valid config",
 			args: []string{"-a", "server.com:8585"},
 			want: Config{
-				Host:               "server.com:8585",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "server.com:8585",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -198,15 +479,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Empty port",
 			args: []string{"-a", "server.com:"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -214,15 +518,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Empty host name",
 			args: []string{"-a", ":8585"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -230,15 +557,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Empty address",
 			args: []string{"-a", ""},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -246,15 +596,38 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 			name: "Only colon",
 			args: []string{"-a", ":"},
 			want: Config{
-				Host:               "localhost:8080",
-				StoreInterval:      300,
-				FileStoragePath:    "backup.dat",
-				Restore:            true,
-				RetryInterval:      2000000000,
-				StartRetryInterval: 1000000000,
-				MaxRetries:         3,
-				CryptoKeyPath:      "",
-				CryptoKey:          nil,
+				Host:                "localhost:8080",
+				StoreInterval:       300,
+				FileStoragePath:     "backup.dat",
+				Restore:             true,
+				RetryInterval:       2000000000,
+				StartRetryInterval:  1000000000,
+				MaxRetries:          3,
+				CryptoKeyPath:       "",
+				CryptoKey:           nil,
+				StatsdFlushInterval: 10,
+				GraphiteMaxConns:    100,
+				GraphiteReadTimeout: 60,
+				OTLPResourceAttrs:   "service.name",
+				ShutdownTimeout:     30,
+				DrainDelay:          5,
+				SelfMetricsPrefix:   "_metriq.",
+				MaxBodySize:         10485760,
+				MaxDecompressedSize: 104857600,
+				CompressMinSize:     1024,
+				MaxBatchSize:        10000,
+				ReadHeaderTimeout:   5,
+				ReadTimeout:         30,
+				IdleTimeout:         120,
+				BatchIDCacheSize:    10000,
+				BatchIDTTL:          600,
+				SampleRetention:     3600,
+				NameMode:            "reject",
+				NameChars:           types.DefaultNameChars,
+				NameMaxLength:       128,
+				NameCase:            "keep",
+				TypeConflict:        "reject",
+				Names:               defaultNames,
 			},
 			errWant: false,
 		},
@@ -288,3 +661,63 @@ func TestConfig_Server_NewConfig_Flags(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_Server_NewConfig_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"trusted_subnet": "192.168.1.0/24", "host": "file.com:8080", "StoreInterval": 10}`), 0o600))
+	_, fileNet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+	_, flagNet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		args          []string
+		env           map[string]string
+		trustedNet    *net.IPNet
+		host          string
+		storeInterval int
+	}{
+		{
+			name:          "Only in the file",
+			args:          []string{"-c", file},
+			trustedNet:    fileNet,
+			host:          "file.com:8080",
+			storeInterval: 10,
+		},
+		{
+			name:          "Flags override the file",
+			args:          []string{"-c", file, "-t", "10.0.0.0/8", "-i", "20"},
+			trustedNet:    flagNet,
+			host:          "file.com:8080",
+			storeInterval: 20,
+		},
+		{
+			name:          "Environment overrides the file",
+			args:          []string{"-c", file},
+			env:           map[string]string{"ADDRESS": "env.com:8080", "TRUSTED_SUBNET": "10.0.0.0/8"},
+			trustedNet:    flagNet,
+			host:          "env.com:8080",
+			storeInterval: 10,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Clearenv()
+			os.Args = append([]string{os.Args[0]}, test.args...)
+			for k, v := range test.env {
+				os.Setenv(k, v)
+			}
+			config, err := NewConfig()
+			require.NoError(t, err)
+			assert.Equal(t, test.trustedNet, config.TrustedNet)
+			assert.Equal(t, test.host, config.Host)
+			assert.Equal(t, test.storeInterval, config.StoreInterval)
+			// поля, которых нет в файле, получают значения флагов по умолчанию
+			assert.Equal(t, "backup.dat", config.FileStoragePath)
+		})
+	}
+
+	os.Clearenv()
+}
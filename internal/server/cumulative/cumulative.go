@@ -0,0 +1,244 @@
+// Package cumulative turns cumulative counters into deltas. Some sources report
+// the running total of a counter instead of its increase since the last report,
+// the tracker keeps the last total of every counter of every source and returns
+// the difference, which is then added to the stored counter as usual. A total
+// lower than the previous one, or one with another start time, means the source
+// has restarted its counter, the whole new total is the increase since the
+// restart. The first total of a counter without a start time only sets the
+// baseline and contributes nothing: the tracker lives in memory, after a restart
+// of the server adding whole totals would count everything twice.
+//
+// The totals of a batch are recorded in two phases: Delta computes the increases
+// and Commit keeps the new totals once the batch is stored, a batch that fails
+// to be stored is rolled back, so that its increases are counted by the next one.
+// Fractional totals are rounded before they are subtracted, the fractions add
+// up instead of being lost by every increase, and so do the fractions of the
+// series reported as deltas, see Batch.Add. A series that is not reported for
+// the TTL, or the least recently reported one when the tracker is full, is
+// forgotten.
+package cumulative
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	// DefaultSize - количество рядов, итоги которых помнит трекер
+	DefaultSize = 100000
+	// DefaultTTL - время хранения итога ряда, который перестал приходить
+	DefaultTTL = time.Hour
+	// sweepInterval - интервал удаления устаревших итогов
+	sweepInterval = time.Minute
+)
+
+type series struct {
+	source string
+	id     string
+	// deltas - ряд приращений, его состояние - неучтенная при округлении дробная часть
+	deltas bool
+}
+
+// state is the last total of a series.
+type state struct {
+	start   uint64
+	total   float64
+	rounded int64
+	seen    time.Time
+}
+
+// Tracker keeps the last totals of cumulative counters.
+type Tracker struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu   sync.Mutex
+	last map[series]state
+	// floor - время последнего вытеснения ряда в нс, первый итог ряда, начавшегося раньше,
+	// мог быть уже учтен до вытеснения
+	floor     uint64
+	lastSweep time.Time
+}
+
+// NewTracker returns a tracker of at most size series, a series not reported for ttl is forgotten.
+func NewTracker(size int, ttl time.Duration) *Tracker {
+	return &Tracker{
+		size: size,
+		ttl:  ttl,
+		now:  time.Now,
+		last: make(map[series]state),
+	}
+}
+
+// change is the total of a series before a batch and the total the batch has recorded.
+type change struct {
+	prev    state
+	existed bool
+	written state
+}
+
+// Batch records the totals of one batch of metrics.
+type Batch struct {
+	t       *Tracker
+	source  string
+	changes map[series]change
+	done    bool
+}
+
+// Begin starts a batch of the totals reported by the source.
+func (t *Tracker) Begin(source string) *Batch {
+	return &Batch{t: t, source: source, changes: make(map[series]change)}
+}
+
+// Delta records the total of the counter and returns its increase since the previous total.
+// start is the start time of the series in nanoseconds, 0 if the source does not report it:
+// the first total of a series started while the tracker was running is the increase as a whole.
+func (b *Batch) Delta(id string, start uint64, total float64) int64 {
+	t := b.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := series{source: b.source, id: id}
+	now := t.now()
+
+	prev, ok := t.last[key]
+	if !ok {
+		t.sweep(now)
+	}
+
+	cur := state{start: start, total: total, rounded: int64(math.Round(total)), seen: now}
+	var delta int64
+	switch {
+	case !ok:
+		if start != 0 && start > t.floor {
+			delta = cur.rounded
+		}
+	case start != prev.start || total < prev.total:
+		delta = cur.rounded
+	default:
+		delta = cur.rounded - prev.rounded
+	}
+
+	b.record(key, prev, ok, cur)
+
+	return delta
+}
+
+// Add adds the increase of a series reported as deltas and returns it rounded, the fraction lost
+// to the rounding is kept and added to the next increase of the series.
+func (b *Batch) Add(id string, delta float64) int64 {
+	t := b.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := series{source: b.source, id: id, deltas: true}
+	now := t.now()
+
+	prev, ok := t.last[key]
+	if !ok {
+		t.sweep(now)
+	}
+
+	sum := prev.total + delta
+	whole := int64(math.Round(sum))
+	b.record(key, prev, ok, state{total: sum - float64(whole), seen: now})
+
+	return whole
+}
+
+// record replaces the state of the series, remembering the one before the batch. The lock must be held.
+func (b *Batch) record(key series, prev state, existed bool, cur state) {
+	c, seen := b.changes[key]
+	if !seen {
+		c = change{prev: prev, existed: existed}
+	}
+	c.written = cur
+	b.changes[key] = c
+	b.t.last[key] = cur
+}
+
+// Commit keeps the totals of the batch once its metrics are stored.
+func (b *Batch) Commit() {
+	b.done = true
+}
+
+// Rollback restores the totals the batch has replaced, unless it is committed, so that the increases
+// of a batch that failed to be stored are counted by the next one. A total replaced by another batch
+// since then is kept.
+func (b *Batch) Rollback() {
+	if b == nil || b.done {
+		return
+	}
+	b.done = true
+
+	t := b.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, c := range b.changes {
+		if t.last[key] != c.written {
+			continue
+		}
+		if c.existed {
+			t.last[key] = c.prev
+		} else {
+			delete(t.last, key)
+		}
+	}
+}
+
+// sweep forgets the series not reported for the TTL and, if the tracker is still full, the least
+// recently reported one. The lock must be held.
+func (t *Tracker) sweep(now time.Time) {
+	full := len(t.last) >= t.size
+	if !full && now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+
+	evicted := false
+	for key, s := range t.last {
+		if now.Sub(s.seen) >= t.ttl {
+			delete(t.last, key)
+			evicted = true
+		}
+	}
+
+	if len(t.last) >= t.size {
+		var oldest series
+		var seen time.Time
+		for key, s := range t.last {
+			if seen.IsZero() || s.seen.Before(seen) {
+				oldest, seen = key, s.seen
+			}
+		}
+		delete(t.last, oldest)
+		evicted = true
+	}
+
+	if evicted {
+		t.floor = uint64(now.UnixNano())
+	}
+}
+
+// ToDeltas replaces the totals of the cumulative counters with their increases. With all
+// set every counter is cumulative, otherwise only the ones flagged as cumulative. The
+// returned batch has to be committed once the metrics are stored.
+func (t *Tracker) ToDeltas(source string, metrics []models.Metrics, all bool) *Batch {
+	b := t.Begin(source)
+	for i, m := range metrics {
+		if m.MType != types.Counter || m.Delta == nil || !(all || m.Cumulative) {
+			continue
+		}
+
+		delta := b.Delta(m.ID, 0, float64(*m.Delta))
+		metrics[i].Delta = &delta
+		metrics[i].Cumulative = false
+	}
+	return b
+}
@@ -0,0 +1,118 @@
+package cumulative
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// delta records one total in its own committed batch.
+func delta(tr *Tracker, source, id string, start uint64, total float64) int64 {
+	b := tr.Begin(source)
+	d := b.Delta(id, start, total)
+	b.Commit()
+	return d
+}
+
+func TestTracker_Delta(t *testing.T) {
+	tr := NewTracker(DefaultSize, DefaultTTL)
+
+	assert.Equal(t, int64(0), delta(tr, "a", "requests", 0, 10))
+	assert.Equal(t, int64(5), delta(tr, "a", "requests", 0, 15))
+	assert.Equal(t, int64(0), delta(tr, "a", "requests", 0, 15))
+	// сброс счетчика источником
+	assert.Equal(t, int64(3), delta(tr, "a", "requests", 0, 3))
+	assert.Equal(t, int64(4), delta(tr, "a", "requests", 0, 7))
+
+	// у другого источника и другой метрики свои итоги
+	assert.Equal(t, int64(0), delta(tr, "b", "requests", 0, 100))
+	assert.Equal(t, int64(0), delta(tr, "a", "errors", 0, 1))
+	assert.Equal(t, int64(1), delta(tr, "b", "requests", 0, 101))
+
+	// ряд с временем начала учитывается целиком, смена времени начала - сброс
+	assert.Equal(t, int64(10), delta(tr, "c", "requests", 100, 10))
+	assert.Equal(t, int64(7), delta(tr, "c", "requests", 200, 7))
+
+	// дробные приращения накапливаются
+	assert.Equal(t, int64(0), delta(tr, "a", "bytes", 0, 0.2))
+	assert.Equal(t, int64(0), delta(tr, "a", "bytes", 0, 0.4))
+	assert.Equal(t, int64(1), delta(tr, "a", "bytes", 0, 0.6))
+	assert.Equal(t, int64(0), delta(tr, "a", "bytes", 0, 0.8))
+	assert.Equal(t, int64(1), delta(tr, "a", "bytes", 0, 1.6))
+}
+
+func TestBatch_Rollback(t *testing.T) {
+	tr := NewTracker(DefaultSize, DefaultTTL)
+	delta(tr, "a", "requests", 0, 10)
+
+	// итог неудавшегося пакета забывается, его приращение учитывает следующий
+	b := tr.Begin("a")
+	assert.Equal(t, int64(5), b.Delta("requests", 0, 15))
+	assert.Equal(t, int64(0), b.Delta("new", 0, 3))
+	b.Rollback()
+	assert.Equal(t, int64(10), delta(tr, "a", "requests", 0, 20))
+	assert.NotContains(t, tr.last, series{source: "a", id: "new"})
+
+	// откат после фиксации ничего не меняет
+	b = tr.Begin("a")
+	b.Delta("requests", 0, 25)
+	b.Commit()
+	b.Rollback()
+	assert.Equal(t, int64(5), delta(tr, "a", "requests", 0, 30))
+}
+
+func TestTracker_Evict(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tr := NewTracker(2, time.Hour)
+	tr.now = func() time.Time { return now }
+
+	delta(tr, "a", "first", 0, 1)
+	now = now.Add(time.Second)
+	delta(tr, "a", "second", 0, 1)
+	now = now.Add(time.Second)
+	delta(tr, "a", "third", 0, 1)
+
+	// трекер полон, забыт ряд, который приходил раньше всех
+	assert.Len(t, tr.last, 2)
+	assert.NotContains(t, tr.last, series{source: "a", id: "first"})
+
+	// ряд, начавшийся до вытеснения, считается известным и задает только базу
+	assert.Equal(t, int64(0), delta(tr, "a", "first", uint64(now.Add(-time.Minute).UnixNano()), 5))
+
+	// устаревшие ряды забываются
+	now = now.Add(2 * time.Hour)
+	delta(tr, "a", "fourth", 0, 1)
+	assert.Len(t, tr.last, 1)
+}
+
+func TestTracker_ToDeltas(t *testing.T) {
+	ptr := func(v int64) *int64 { return &v }
+	value := 1.5
+
+	tr := NewTracker(DefaultSize, DefaultTTL)
+	tr.ToDeltas("a", []models.Metrics{
+		{ID: "total", MType: types.Counter, Delta: ptr(10), Cumulative: true},
+	}, false).Commit()
+
+	metrics := []models.Metrics{
+		{ID: "total", MType: types.Counter, Delta: ptr(25), Cumulative: true},
+		{ID: "plain", MType: types.Counter, Delta: ptr(2)},
+		{ID: "gauge", MType: types.Gauge, Value: &value},
+	}
+	tr.ToDeltas("a", metrics, false).Commit()
+
+	assert.Equal(t, int64(15), *metrics[0].Delta)
+	assert.False(t, metrics[0].Cumulative)
+	assert.Equal(t, int64(2), *metrics[1].Delta)
+	assert.Equal(t, value, *metrics[2].Value)
+
+	// в режиме all накопленными считаются все счетчики
+	tr.ToDeltas("a", []models.Metrics{{ID: "plain", MType: types.Counter, Delta: ptr(2)}}, true).Commit()
+	metrics = []models.Metrics{{ID: "plain", MType: types.Counter, Delta: ptr(7)}}
+	tr.ToDeltas("a", metrics, true).Commit()
+	assert.Equal(t, int64(5), *metrics[0].Delta)
+}
@@ -0,0 +1,141 @@
+// Package dedup remembers the IDs of the metric batches applied recently. The
+// agent attaches a unique ID to every batch and keeps it when it retries the
+// delivery, so a batch the server has already applied, e.g. before the response
+// timed out on the agent side, is acknowledged again without adding its counters
+// twice. The ID is recorded together with the batch: a batch that fails to
+// apply leaves no ID behind, and a retry arriving while the first attempt is
+// still in progress waits for its outcome, it is applied only if the first
+// attempt has failed.
+package dedup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSize - количество запоминаемых идентификаторов пакетов по умолчанию
+	DefaultSize = 10000
+	// DefaultTTL - время хранения идентификатора пакета по умолчанию
+	DefaultTTL = 10 * time.Minute
+)
+
+// ErrStore wraps the errors of the store of the IDs, the errors of applying the batch are returned as they are.
+var ErrStore = errors.New("failed to record the batch ID")
+
+// Store keeps the IDs of applied batches. It is implemented by Cache in memory
+// and by the Postgres storage.
+type Store interface {
+	// Apply applies the batch with apply unless its ID is already known, it returns false for
+	// a duplicate. The ID is recorded only if apply succeeds. apply must do its writes with
+	// the context it is given, the Postgres store runs them in the transaction recording the ID.
+	Apply(ctx context.Context, id string, apply func(ctx context.Context) error) (bool, error)
+}
+
+type entry struct {
+	id      string
+	expires time.Time
+}
+
+// Cache is a Store in memory bounded both by the number of IDs and by their age.
+type Cache struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	expires map[string]time.Time
+	// pending - пакеты, которые применяются сейчас, канал закрывается по завершении
+	pending map[string]chan struct{}
+	// идентификаторы в порядке добавления, он же порядок истечения срока хранения,
+	// head - начало очереди
+	queue []entry
+	head  int
+}
+
+// NewCache returns a cache keeping at most size IDs for ttl each, the defaults
+// are used for the values that are not positive.
+func NewCache(size int, ttl time.Duration) *Cache {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		expires: make(map[string]time.Time, size),
+		pending: make(map[string]chan struct{}),
+	}
+}
+
+func (c *Cache) Apply(ctx context.Context, id string, apply func(ctx context.Context) error) (bool, error) {
+	for {
+		c.mu.Lock()
+		c.evict(c.now())
+		if _, ok := c.expires[id]; ok {
+			c.mu.Unlock()
+			return false, nil
+		}
+
+		// повтор пакета, который еще применяется, ждет результата первой попытки
+		if done, ok := c.pending[id]; ok {
+			c.mu.Unlock()
+			select {
+			case <-done:
+				continue
+			case <-ctx.Done():
+				return false, fmt.Errorf("%w: %w", ErrStore, ctx.Err())
+			}
+		}
+
+		done := make(chan struct{})
+		c.pending[id] = done
+		c.mu.Unlock()
+
+		err := apply(ctx)
+
+		c.mu.Lock()
+		delete(c.pending, id)
+		if err == nil {
+			c.add(id, c.now())
+		}
+		c.mu.Unlock()
+		close(done)
+
+		return true, err
+	}
+}
+
+// add records the ID of an applied batch, the lock must be held.
+func (c *Cache) add(id string, now time.Time) {
+	c.evict(now)
+	expires := now.Add(c.ttl)
+	c.expires[id] = expires
+	c.queue = append(c.queue, entry{id: id, expires: expires})
+}
+
+// evict drops the expired IDs and the oldest ones over the size.
+func (c *Cache) evict(now time.Time) {
+	for c.head < len(c.queue) && (len(c.queue)-c.head >= c.size || !now.Before(c.queue[c.head].expires)) {
+		e := c.queue[c.head]
+		// идентификатор мог истечь и быть добавлен заново
+		if c.expires[e.id].Equal(e.expires) {
+			delete(c.expires, e.id)
+		}
+		c.queue[c.head] = entry{}
+		c.head++
+	}
+
+	// сдвигаем очередь, когда ее начало занимает больше половины
+	if c.head > len(c.queue)/2 {
+		c.queue = append(c.queue[:0], c.queue[c.head:]...)
+		c.head = 0
+	}
+}
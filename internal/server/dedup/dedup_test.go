@@ -0,0 +1,111 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_Apply(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCache(3, time.Minute)
+	c.now = func() time.Time { return now }
+
+	applied := 0
+	apply := func(context.Context) error {
+		applied++
+		return nil
+	}
+
+	ok, err := c.Apply(ctx, "a", apply)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, _ = c.Apply(ctx, "a", apply)
+	assert.False(t, ok, "a duplicate is not applied")
+	assert.Equal(t, 1, applied)
+
+	// идентификатор пакета, который не удалось применить, не запоминается
+	errApply := errors.New("apply")
+	ok, err = c.Apply(ctx, "b", func(context.Context) error { return errApply })
+	assert.True(t, ok)
+	require.ErrorIs(t, err, errApply)
+	ok, _ = c.Apply(ctx, "b", apply)
+	assert.True(t, ok)
+	assert.Equal(t, 2, applied)
+
+	// срок хранения истек
+	now = now.Add(time.Minute)
+	ok, _ = c.Apply(ctx, "a", apply)
+	assert.True(t, ok)
+}
+
+func TestCache_Apply_pending(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(3, time.Minute)
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	first := make(chan error)
+	go func() {
+		_, err := c.Apply(ctx, "a", func(context.Context) error {
+			close(started)
+			<-finish
+			return errors.New("apply")
+		})
+		first <- err
+	}()
+	<-started
+
+	// повтор ждет результата первой попытки и применяется, так как она не удалась
+	second := make(chan bool)
+	go func() {
+		ok, _ := c.Apply(ctx, "a", func(context.Context) error { return nil })
+		second <- ok
+	}()
+	close(finish)
+	require.Error(t, <-first)
+	assert.True(t, <-second)
+
+	// пока пакет применяется, повтор прерывается по контексту
+	started, finish = make(chan struct{}), make(chan struct{})
+	go func() {
+		_, err := c.Apply(ctx, "b", func(context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		})
+		first <- err
+	}()
+	<-started
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err := c.Apply(cctx, "b", func(context.Context) error { return nil })
+	require.ErrorIs(t, err, ErrStore)
+	close(finish)
+	require.NoError(t, <-first)
+}
+
+func TestCache_Size(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(3, time.Hour)
+	apply := func(context.Context) error { return nil }
+
+	for i := 0; i < 10; i++ {
+		ok, err := c.Apply(ctx, strconv.Itoa(i), apply)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.LessOrEqual(t, len(c.expires), 3)
+	}
+
+	// самые старые идентификаторы вытеснены, последние помнятся
+	ok, _ := c.Apply(ctx, "0", apply)
+	assert.True(t, ok)
+	ok, _ = c.Apply(ctx, "9", apply)
+	assert.False(t, ok)
+}
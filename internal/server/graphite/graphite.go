@@ -0,0 +1,271 @@
+// Package graphite implements a TCP listener compatible with the Graphite
+// carbon plaintext protocol. Every line has the form "path value timestamp",
+// the dotted path becomes the metric ID and the configured rules decide
+// whether the value is stored as a counter or a gauge. The timestamp is
+// accepted for compatibility only, the repository keeps the latest values.
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	batchSize     = 500
+	flushInterval = time.Second
+)
+
+type Listener struct {
+	cfg   config.Config
+	stor  storage.Repository
+	lg    logger.Logger
+	rules Rules
+	ln    net.Listener
+	sem   chan struct{}
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex
+	closed bool
+	conns  map[net.Conn]struct{}
+	batch  []models.Metrics
+}
+
+func NewListener(cfg config.Config, stor storage.Repository, lg logger.Logger) (*Listener, error) {
+	rules, err := ParseRules(cfg.GraphiteRules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Listener{
+		cfg:   cfg,
+		stor:  stor,
+		lg:    lg,
+		rules: rules,
+		sem:   make(chan struct{}, cfg.GraphiteMaxConns),
+		conns: make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Start binds the TCP socket and starts accepting connections. When the
+// context is canceled the listener and all open connections are closed and
+// the metrics received so far are flushed to the repository.
+func (l *Listener) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", l.cfg.GraphiteAddr)
+	if err != nil {
+		return err
+	}
+	l.ln = ln
+
+	l.wg.Add(2)
+	go l.accept()
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.flush(ctx)
+			case <-ctx.Done():
+				l.stop()
+				return
+			}
+		}
+	}()
+
+	l.lg.Sugar.Infow("The Graphite listener is running", "address", ln.Addr().String())
+
+	return nil
+}
+
+// Addr returns the address the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Wait blocks until all connections are closed and the final flush is done.
+func (l *Listener) Wait() {
+	l.wg.Wait()
+}
+
+func (l *Listener) stop() {
+	if err := l.ln.Close(); err != nil {
+		l.lg.Sugar.Infow("error closing graphite listener", "error: ", err)
+	}
+
+	l.mu.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+	l.mu.Unlock()
+}
+
+func (l *Listener) accept() {
+	defer l.wg.Done()
+
+	var handlers sync.WaitGroup
+	defer func() {
+		// the last flush happens once every connection is done
+		handlers.Wait()
+		l.flush(context.Background())
+	}()
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.lg.Sugar.Infow("error accepting graphite connection", "error: ", err)
+			continue
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			l.lg.Sugar.Infow("graphite connection limit reached", "remote", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+
+		l.mu.Lock()
+		if l.closed {
+			// the listener was stopped while this connection was being accepted
+			l.mu.Unlock()
+			conn.Close()
+			<-l.sem
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() {
+				l.mu.Lock()
+				delete(l.conns, conn)
+				l.mu.Unlock()
+				conn.Close()
+				<-l.sem
+			}()
+			l.handle(conn)
+		}()
+	}
+}
+
+func (l *Listener) handle(conn net.Conn) {
+	timeout := time.Duration(l.cfg.GraphiteReadTimeout) * time.Second
+	scanner := bufio.NewScanner(conn)
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			l.lg.Sugar.Infow("error setting graphite read deadline", "error: ", err)
+			return
+		}
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+				l.lg.Sugar.Infow("error reading graphite connection", "remote", conn.RemoteAddr().String(), "error: ", err)
+			}
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		metric, err := l.parseLine(line)
+		if err != nil {
+			l.lg.Sugar.Infow("error parsing graphite line", "error: ", err)
+			continue
+		}
+
+		l.add(metric)
+	}
+}
+
+// parseLine parses a "path value timestamp" line into a metric.
+func (l *Listener) parseLine(line string) (models.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return models.Metrics{}, fmt.Errorf("invalid graphite line %q: expected path value timestamp", line)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return models.Metrics{}, fmt.Errorf("invalid graphite line %q: %w", line, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return models.Metrics{}, fmt.Errorf("invalid graphite line %q: value is not finite", line)
+	}
+
+	if _, err := strconv.ParseFloat(fields[2], 64); err != nil && fields[2] != "N" {
+		return models.Metrics{}, fmt.Errorf("invalid graphite line %q: wrong timestamp", line)
+	}
+
+	// правила типа сопоставляются с исходным путем, сохраняется имя по правилам имен
+	name, err := l.cfg.Names.Apply(fields[0])
+	if err != nil {
+		return models.Metrics{}, fmt.Errorf("invalid graphite line %q: %w", line, err)
+	}
+
+	metric := models.Metrics{ID: name, MType: l.rules.MetricType(fields[0])}
+	switch metric.MType {
+	case types.Counter:
+		delta := int64(math.Round(value))
+		metric.Delta = &delta
+	case types.Gauge:
+		metric.Value = &value
+	}
+
+	return metric, nil
+}
+
+func (l *Listener) add(metric models.Metrics) {
+	l.mu.Lock()
+	l.batch = append(l.batch, metric)
+	full := len(l.batch) >= batchSize
+	l.mu.Unlock()
+
+	if full {
+		l.flush(context.Background())
+	}
+}
+
+func (l *Listener) flush(ctx context.Context) {
+	l.mu.Lock()
+	batch := l.batch
+	l.batch = nil
+	l.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	// метрика другого типа под занятым именем теряется одна, а не весь пакет
+	conflicts, err := storage.SetMetricsApart(ctx, l.stor, batch)
+	if len(conflicts) > 0 {
+		l.lg.Sugar.Infow("graphite metrics of another type dropped", "names", conflicts)
+	}
+	if err != nil {
+		l.lg.Sugar.Infow("error saving graphite metrics", "error: ", err)
+	}
+}
@@ -0,0 +1,169 @@
+package graphite
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		path    string
+		want    string
+		errWant bool
+	}{
+		{
+			name:  "No rules",
+			rules: "",
+			path:  "servers.web1.load",
+			want:  types.Gauge,
+		},
+		{
+			name:  "Counter rule",
+			rules: "*.requests=counter",
+			path:  "servers.web1.requests",
+			want:  types.Counter,
+		},
+		{
+			name:  "First match wins",
+			rules: "servers.db*=gauge, servers.*=counter",
+			path:  "servers.db1.requests",
+			want:  types.Gauge,
+		},
+		{
+			name:  "No match",
+			rules: "*.requests=counter",
+			path:  "servers.web1.load",
+			want:  types.Gauge,
+		},
+		{
+			name:    "Wrong type",
+			rules:   "*.requests=histogram",
+			errWant: true,
+		},
+		{
+			name:    "Missing type",
+			rules:   "*.requests",
+			errWant: true,
+		},
+		{
+			name:    "Wrong pattern",
+			rules:   "[.requests=counter",
+			errWant: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := ParseRules(test.rules)
+			if test.errWant {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, rules.MetricType(test.path))
+		})
+	}
+}
+
+func TestListener(t *testing.T) {
+	stor := mem.NewStorage()
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	l, err := NewListener(config.Config{
+		GraphiteAddr:        "127.0.0.1:0",
+		GraphiteRules:       "*.requests=counter",
+		GraphiteMaxConns:    1,
+		GraphiteReadTimeout: 10,
+	}, stor, log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	_, err = fmt.Fprintf(conn, "web1.requests 5 %d\nweb1.requests 2 %d\nweb1.load 0.75 %d\nbroken line\n", now, now, now)
+	require.NoError(t, err)
+
+	// the second connection exceeds the limit and is closed by the server
+	extra, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer extra.Close()
+	require.NoError(t, extra.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = extra.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	assert.Eventually(t, func() bool {
+		metric, err := stor.Metric(context.Background(), types.Gauge, "web1.load")
+		return err == nil && metric.Value == 0.75
+	}, 3*time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	counter, err := stor.Metric(context.Background(), types.Counter, "web1.requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), counter.Value)
+}
+
+func TestListener_TypeConflict(t *testing.T) {
+	stor := mem.NewStorage()
+	// под именем уже хранится counter, строка с gauge конфликтует
+	require.NoError(t, stor.SetMetric(context.Background(), "web1.load", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	l, err := NewListener(config.Config{
+		GraphiteAddr:        "127.0.0.1:0",
+		GraphiteRules:       "*.requests=counter",
+		GraphiteMaxConns:    1,
+		GraphiteReadTimeout: 10,
+	}, stor, log)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	now := time.Now().Unix()
+	_, err = fmt.Fprintf(conn, "web1.requests 5 %d\nweb1.load 0.75 %d\nweb1.cpu 0.5 %d\n", now, now, now)
+	require.NoError(t, err)
+
+	// конфликтующая строка теряется одна, остальные записываются
+	assert.Eventually(t, func() bool {
+		metric, err := stor.Metric(context.Background(), types.Gauge, "web1.cpu")
+		return err == nil && metric.Value == 0.5
+	}, 3*time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	counter, err := stor.Metric(context.Background(), types.Counter, "web1.requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), counter.Value)
+
+	load, err := stor.Metric(context.Background(), types.Counter, "web1.load")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), load.Value)
+}
@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// rule maps Graphite paths matching a glob pattern to a metric type.
+type rule struct {
+	pattern string
+	mType   string
+}
+
+// Rules decide whether a Graphite path is stored as a counter or a gauge.
+// The first matching rule wins, paths that match no rule are gauges.
+type Rules []rule
+
+// ParseRules parses a comma separated list of pattern=type rules, e.g.
+// "*.requests=counter,servers.*.errors=counter". Patterns use path.Match
+// syntax, so "*" also matches the dots of a Graphite path.
+func ParseRules(s string) (Rules, error) {
+	var rules Rules
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		pattern, mType, ok := strings.Cut(item, "=")
+		if !ok || pattern == "" {
+			return nil, fmt.Errorf("invalid graphite rule %q: expected pattern=type", item)
+		}
+
+		mType = strings.ToLower(strings.TrimSpace(mType))
+		if err := types.CheckMetricType(mType); err != nil {
+			return nil, fmt.Errorf("invalid graphite rule %q: %w", item, err)
+		}
+
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid graphite rule %q: %w", item, err)
+		}
+
+		rules = append(rules, rule{pattern: pattern, mType: mType})
+	}
+
+	return rules, nil
+}
+
+// MetricType returns the metric type for the given Graphite path.
+func (rs Rules) MetricType(name string) string {
+	for _, r := range rs {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return r.mType
+		}
+	}
+	return types.Gauge
+}
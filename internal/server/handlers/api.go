@@ -0,0 +1,122 @@
+// This file contains the shared parts of the versioned /api/v1 handlers.
+// Unlike the legacy routes, which answer with plain text produced by http.Error,
+// every /api/v1 error is a JSON envelope {"error": {"code", "message", "field"}}
+// with a consistent status code: 400 for invalid requests, 404 for unknown metrics
+// and routes, 405 for unsupported methods, 409 for metrics of another type stored under
+// the name, 500 for storage failures and 501 for features
+// the configured storage does not support.
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	errCodeInvalidJSON    = "invalid_json"
+	errCodeInvalidType    = "invalid_type"
+	errCodeInvalidName    = "invalid_name"
+	errCodeInvalidValue   = "invalid_value"
+	errCodeNotFound       = "not_found"
+	errCodeNotAllowed     = "method_not_allowed"
+	errCodeInternalError  = "internal_error"
+	errCodeNotImplemented = "not_implemented"
+	errCodeTooLarge       = "payload_too_large"
+	errCodeTypeConflict   = "type_conflict"
+)
+
+// validateMetric checks the type, the name and the value of a metric received
+// in a JSON request and describes the first problem found. The name of a valid
+// metric is replaced with the one the name policy normalizes it to.
+func (h *Handlers) validateMetric(m *models.Metrics) *models.APIError {
+	if err := types.CheckMetricType(m.MType); err != nil {
+		return &models.APIError{Code: errCodeInvalidType, Message: err.Error(), Field: "type"}
+	}
+
+	if len(m.ID) == 0 {
+		return &models.APIError{Code: errCodeInvalidName, Message: "the name of the metric is empty", Field: "id"}
+	}
+
+	name, err := h.config.Names.Apply(m.ID)
+	if err != nil {
+		return &models.APIError{Code: errCodeInvalidName, Message: err.Error(), Field: "id"}
+	}
+
+	if err := types.CheckFields(*m); err != nil {
+		return fieldError(errCodeInvalidValue, err)
+	}
+
+	if apiErr := checkCumulative(*m); apiErr != nil {
+		return apiErr
+	}
+
+	m.ID = name
+	return nil
+}
+
+// writeJSON marshals the response, signs it if there is a key and writes it with the given status.
+func (h *Handlers) writeJSON(w http.ResponseWriter, status int, v any) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// если есть ключ, хэшируем ответ
+	if len(h.config.Key) > 0 {
+		hash, err := h.Sum(resp)
+		if err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("HashSHA256", hash)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+// writeError logs the error and writes it as a JSON envelope.
+func (h *Handlers) writeError(w http.ResponseWriter, status int, apiErr models.APIError) {
+	h.lg.Sugar.Infow("error in request handler", "error: ", apiErr.Message, "code", apiErr.Code, "field", apiErr.Field)
+	h.writeJSON(w, status, models.ErrorResponse{Error: apiErr})
+}
+
+// writeDecodeError answers a request whose body could not be read or decoded.
+func (h *Handlers) writeDecodeError(w http.ResponseWriter, err error) {
+	status := bodyStatus(err)
+	if status == http.StatusRequestEntityTooLarge {
+		h.writeError(w, status, models.APIError{Code: errCodeTooLarge, Message: err.Error()})
+		return
+	}
+	h.writeError(w, status, *fieldError(errCodeInvalidJSON, err))
+}
+
+// fieldError describes the error with the code, the field of *types.FieldError is reported with it.
+func fieldError(code string, err error) *models.APIError {
+	apiErr := &models.APIError{Code: code, Message: err.Error()}
+	var fieldErr *types.FieldError
+	if errors.As(err, &fieldErr) {
+		apiErr.Message = fieldErr.Message
+		apiErr.Field = fieldErr.Field
+	}
+	return apiErr
+}
+
+// APINotFound answers unknown /api/v1 routes.
+func (h *Handlers) APINotFound(w http.ResponseWriter, r *http.Request) {
+	h.writeError(w, http.StatusNotFound, models.APIError{Code: errCodeNotFound, Message: "route not found: " + r.URL.Path})
+}
+
+// APIMethodNotAllowed answers /api/v1 routes requested with an unsupported method.
+func (h *Handlers) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	h.writeError(w, http.StatusMethodNotAllowed, models.APIError{Code: errCodeNotAllowed, Message: "method not allowed: " + r.Method})
+}
@@ -0,0 +1,23 @@
+// The APICapabilities function handles GET /api/v1/capabilities. It reports the
+// content codings the server decompresses, in the order it prefers them, the
+// formats of the metric batches it decodes and the limits of a batch, so that
+// the agent can choose how to send its metrics. The route is public like /ping,
+// an agent may hold a key with the write scope only.
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/compression"
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+func (h *Handlers) APICapabilities(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, models.Capabilities{
+		ContentEncodings: compression.Encodings,
+		ContentTypes:     []string{codec.JSON, codec.Protobuf},
+		MaxBodySize:      h.config.MaxBodySize,
+		MaxBatchSize:     h.config.MaxBatchSize,
+	})
+}
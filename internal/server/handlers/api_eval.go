@@ -0,0 +1,47 @@
+// The APIEval function handles GET /api/v1/eval. It evaluates the expression of
+// the expr parameter, for example rate(requests[5m]) / rate(errors[5m]), against
+// the current values of metrics and the values written recently, see the query
+// package for the language. The result is a number or the values of metrics.
+// An invalid expression is reported with 400 and the position of the error.
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/query"
+)
+
+func (h *Handlers) APIEval(w http.ResponseWriter, r *http.Request) {
+	expr := r.URL.Query().Get("expr")
+	if expr == "" {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: "the expression is empty", Field: "expr"})
+		return
+	}
+
+	v, err := query.Evaluate(r.Context(), h.Repo, h.config.TypeConflict, expr, time.Now())
+	var qErr *query.Error
+	if errors.As(err, &qErr) {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: qErr.Error(), Field: "expr"})
+		return
+	}
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+
+	result := models.ExprResult{Expr: expr, Type: string(v.Type)}
+	switch v.Type {
+	case query.Scalar:
+		result.Value = &v.Scalar
+	case query.Vector:
+		result.Series = make([]models.ExprSample, 0, len(v.Vector))
+		for _, s := range v.Vector {
+			result.Series = append(result.Series, models.ExprSample{ID: s.ID, Value: s.Value})
+		}
+	}
+
+	h.writeJSON(w, http.StatusOK, result)
+}
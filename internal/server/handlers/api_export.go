@@ -0,0 +1,310 @@
+// The APIExport function handles GET /api/v1/export. It downloads the metrics
+// as a file in the format given by the format parameter: csv, ndjson or parquet.
+// By default every metric is exported with its current value and the time of the
+// export, with history=true every value recorded recently is exported with the
+// time it was written, if the storage keeps the history. The metrics can be
+// limited to a name prefix (prefix) and the recorded values to a time range (from
+// and to, in RFC 3339), a range without history=true is rejected with 400. The
+// rows are written to the client as they are produced, in portions, so the
+// response is never held in memory as a whole. An export that fails after the
+// headers are sent can't change its status: a CSV or NDJSON file then ends with
+// a row marking it incomplete, a parquet file is left without its footer.
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// exportFlushRows - количество строк выгрузки, после которого они отправляются клиенту
+const exportFlushRows = 1000
+
+// exporter writes the rows of an export in one of the formats.
+type exporter interface {
+	Write(row models.ExportRow) error
+	// Flush writes the buffered rows to the response.
+	Flush() error
+	Close() error
+	// Abort ends an export that failed after rows rows, so the file is not taken for a whole one.
+	Abort(rows int, err error) error
+}
+
+// exportIncomplete - строка, которой заканчивается прерванная выгрузка
+type exportIncomplete struct {
+	Incomplete bool   `json:"incomplete"`
+	Rows       int    `json:"rows"`
+	Error      string `json:"error"`
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	new         func(w io.Writer) exporter
+}{
+	"csv":     {"text/csv", newCSVExporter},
+	"ndjson":  {"application/x-ndjson", newNDJSONExporter},
+	"parquet": {"application/vnd.apache.parquet", newParquetExporter},
+}
+
+func (h *Handlers) APIExport(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	format, ok := exportFormats[params.Get("format")]
+	if !ok {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: "the format must be csv, ndjson or parquet", Field: "format"})
+		return
+	}
+
+	var from, to time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := params.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: "the time must be in RFC 3339: " + err.Error(), Field: p.name})
+			return
+		}
+		*p.t = t
+	}
+
+	withHistory := params.Get("history") == "true"
+	// текущие значения экспортируются со временем выгрузки, интервал к ним не применим
+	for name, t := range map[string]time.Time{"from": from, "to": to} {
+		if !withHistory && !t.IsZero() {
+			h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: "the time range applies to the history only, history=true is expected", Field: name})
+			return
+		}
+	}
+	hist, ok := h.Repo.(historian)
+	if withHistory && !ok {
+		h.writeError(w, http.StatusNotImplemented, models.APIError{Code: errCodeNotImplemented, Message: "the storage does not keep the history of metrics"})
+		return
+	}
+
+	metrics, err := h.Repo.Metrics(r.Context())
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+
+	prefix := params.Get("prefix")
+	keys := make([]string, 0, len(metrics))
+	for key, metric := range metrics {
+		if strings.HasPrefix(types.KeyName(h.config.TypeConflict, key, metric.MetricType), prefix) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	name := params.Get("format")
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="metrics-%s.%s"`, time.Now().UTC().Format("20060102T150405Z"), name))
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	exp := format.new(w)
+	inRange := func(t time.Time) bool {
+		return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+	}
+
+	rows := 0
+	write := func(row models.ExportRow) error {
+		if err := exp.Write(row); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows != 0 {
+			return nil
+		}
+		if err := exp.Flush(); err != nil {
+			return err
+		}
+		// не все обертки ответа умеют отправлять данные досрочно, тогда они уйдут в конце
+		if err := rc.Flush(); err != nil && err != http.ErrNotSupported {
+			return err
+		}
+		return nil
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if err := r.Context().Err(); err != nil {
+			return
+		}
+
+		metric := metrics[key]
+		id := types.KeyName(h.config.TypeConflict, key, metric.MetricType)
+		if !withHistory {
+			err = write(exportRow(id, metric.MetricType, now, metric.Value))
+		} else {
+			for _, p := range hist.History(metric.MetricType, id) {
+				if !inRange(p.Time) {
+					continue
+				}
+				if err = write(exportRow(id, metric.MetricType, p.Time, p.Value)); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			// заголовки уже отправлены, выгрузка помечается неполной
+			h.lg.Sugar.Infow("export aborted", "rows", rows, "error: ", err)
+			if err := exp.Abort(rows, err); err != nil {
+				h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			}
+			return
+		}
+	}
+
+	if err := exp.Close(); err != nil {
+		h.lg.Sugar.Infow("export aborted", "rows", rows, "error: ", err)
+	}
+}
+
+// exportRow makes the row of the value, gauges are exported as values and counters as deltas.
+func exportRow(id, mType string, t time.Time, value any) models.ExportRow {
+	row := models.ExportRow{ID: id, MType: mType, Time: t}
+
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int64:
+		f = float64(v)
+	}
+
+	switch mType {
+	case types.Gauge:
+		row.Value = &f
+	case types.Counter:
+		d, ok := value.(int64)
+		if !ok {
+			d = int64(f)
+		}
+		row.Delta = &d
+	}
+
+	return row
+}
+
+type csvExporter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func newCSVExporter(w io.Writer) exporter {
+	return &csvExporter{w: csv.NewWriter(w)}
+}
+
+func (e *csvExporter) writeHeader() error {
+	if e.header {
+		return nil
+	}
+	e.header = true
+	return e.w.Write([]string{"id", "type", "time", "value", "delta"})
+}
+
+func (e *csvExporter) Write(row models.ExportRow) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	var value, delta string
+	if row.Value != nil {
+		value = strconv.FormatFloat(*row.Value, 'g', -1, 64)
+	}
+	if row.Delta != nil {
+		delta = strconv.FormatInt(*row.Delta, 10)
+	}
+
+	return e.w.Write([]string{row.ID, row.MType, row.Time.Format(time.RFC3339Nano), value, delta})
+}
+
+func (e *csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// Close writes the header even if there are no rows.
+func (e *csvExporter) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+// Abort writes a row with the # id and the error in the type column after the written rows.
+func (e *csvExporter) Abort(rows int, err error) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if err := e.w.Write([]string{"#", "incomplete export after " + strconv.Itoa(rows) + " rows: " + err.Error(), "", "", ""}); err != nil {
+		return err
+	}
+	return e.Flush()
+}
+
+type ndjsonExporter struct {
+	enc *json.Encoder
+}
+
+func newNDJSONExporter(w io.Writer) exporter {
+	return &ndjsonExporter{enc: json.NewEncoder(w)}
+}
+
+func (e *ndjsonExporter) Write(row models.ExportRow) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonExporter) Flush() error { return nil }
+
+func (e *ndjsonExporter) Close() error { return nil }
+
+// Abort writes an exportIncomplete line after the written rows.
+func (e *ndjsonExporter) Abort(rows int, err error) error {
+	return e.enc.Encode(exportIncomplete{Incomplete: true, Rows: rows, Error: err.Error()})
+}
+
+// parquetExporter writes a row group on every flush.
+type parquetExporter struct {
+	w *parquet.GenericWriter[models.ExportRow]
+}
+
+func newParquetExporter(w io.Writer) exporter {
+	return &parquetExporter{w: parquet.NewGenericWriter[models.ExportRow](w)}
+}
+
+func (e *parquetExporter) Write(row models.ExportRow) error {
+	_, err := e.w.Write([]models.ExportRow{row})
+	return err
+}
+
+func (e *parquetExporter) Flush() error {
+	return e.w.Flush()
+}
+
+func (e *parquetExporter) Close() error {
+	return e.w.Close()
+}
+
+// Abort leaves the file without the footer, no parquet reader takes it for a whole one.
+func (e *parquetExporter) Abort(int, error) error {
+	return nil
+}
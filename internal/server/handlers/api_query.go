@@ -0,0 +1,69 @@
+// The APIQuery function handles GET /api/v1/query. It aggregates the values of
+// the metrics selected by the name parameter, an exact name or a glob with * and ?,
+// written within the window parameter (a duration like 15m, 5m by default) up to now.
+// The agg parameter is one of sum, avg, min, max, count, last, rate and p95; gauges
+// are aggregated over their values and counters over their increments. The result
+// holds the aggregation of every selected metric and, for sum, avg, min, max and
+// count, of all of them together. The aggregation is computed by the storage.
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/storage"
+)
+
+// defaultQueryWindow - окно запроса с агрегацией, если оно не задано
+const defaultQueryWindow = 5 * time.Minute
+
+func (h *Handlers) APIQuery(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	selector := params.Get("name")
+	if selector == "" {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidName, Message: "the name of the metric is empty", Field: "name"})
+		return
+	}
+
+	agg, err := storage.ParseAggregation(params.Get("agg"))
+	if err != nil {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: "agg"})
+		return
+	}
+
+	window := defaultQueryWindow
+	if v := params.Get("window"); v != "" {
+		window, err = time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: "the window must be a positive duration like 15m", Field: "window"})
+			return
+		}
+	}
+
+	to := time.Now()
+	q := storage.Query{Selector: selector, From: to.Add(-window), To: to, Aggregation: agg}
+
+	aggregates, err := h.Repo.Query(r.Context(), q)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+
+	result := models.QueryResult{
+		Selector:    selector,
+		Aggregation: string(agg),
+		From:        q.From,
+		To:          q.To,
+		Series:      make([]models.SeriesAggregate, 0, len(aggregates)),
+	}
+	for _, a := range aggregates {
+		result.Series = append(result.Series, models.SeriesAggregate{ID: a.ID, MType: a.MType, Value: a.Value, Samples: a.Samples})
+	}
+	if value, ok := storage.Combine(agg, aggregates); ok {
+		result.Value = &value
+	}
+
+	h.writeJSON(w, http.StatusOK, result)
+}
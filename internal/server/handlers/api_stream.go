@@ -0,0 +1,127 @@
+// The APIStream function handles GET /api/v1/stream. It pushes metric updates
+// to the client as Server-Sent Events as soon as they are written to the
+// repository. Updates can be limited to metrics with the given names (name,
+// may be repeated) and/or with a name prefix (prefix). Every event carries
+// its ID, a client that reconnects with the Last-Event-ID header (or the
+// last_event_id query parameter) first receives the updates it has missed,
+// as long as they are still in the bounded buffer of the repository.
+// A comment line is sent as a heartbeat while there are no updates, so that
+// proxies do not close an idle connection. The stream ends when the server
+// starts draining, otherwise it would hold up the shutdown.
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/storage/history"
+)
+
+// heartbeatInterval - интервал отправки heartbeat в потоке событий
+var heartbeatInterval = 15 * time.Second
+
+// streamer is implemented by repositories that publish write events.
+type streamer interface {
+	Subscribe(lastID uint64) ([]history.Event, <-chan history.Event, func())
+}
+
+func (h *Handlers) APIStream(w http.ResponseWriter, r *http.Request) {
+	stream, ok := h.Repo.(streamer)
+	if !ok {
+		h.writeError(w, http.StatusNotImplemented, models.APIError{Code: errCodeNotImplemented, Message: "the storage does not publish updates"})
+		return
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("last_event_id")
+	}
+
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		lastID, err = strconv.ParseUint(lastEventID, 10, 64)
+		if err != nil {
+			h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: "Last-Event-ID"})
+			return
+		}
+	}
+
+	names := r.URL.Query()["name"]
+	prefix := r.URL.Query().Get("prefix")
+	match := func(e history.Event) bool {
+		if len(names) == 0 && prefix == "" {
+			return true
+		}
+		return slices.Contains(names, e.Metric.ID) || (prefix != "" && strings.HasPrefix(e.Metric.ID, prefix))
+	}
+
+	missed, events, cancel := stream.Subscribe(lastID)
+	defer cancel()
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(e history.Event) error {
+		if !match(e) {
+			return nil
+		}
+		data, err := json.Marshal(e.Metric)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: metric\ndata: %s\n\n", e.ID, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	for _, e := range missed {
+		if err := send(e); err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.Probe.Draining():
+			// the server is shutting down, the client will reconnect to another instance
+			return
+		case e, ok := <-events:
+			if !ok {
+				// the client has fallen behind, it will reconnect and resume from the last event
+				return
+			}
+			err = send(e)
+		case <-heartbeat.C:
+			if _, err = fmt.Fprint(w, ": heartbeat\n\n"); err == nil {
+				err = rc.Flush()
+			}
+		}
+		if err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			return
+		}
+	}
+}
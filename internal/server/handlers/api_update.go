@@ -0,0 +1,72 @@
+// The APIUpdate function handles POST /api/v1/update. It accepts a single metric
+// in JSON, validates its type, name and value and stores it in the repository.
+// The total of a cumulative counter is stored as its increase since the previous
+// report of the source. The stored metric is returned in the response body.
+// Errors are reported as a JSON envelope with the code, the message and the
+// offending field.
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+func (h *Handlers) APIUpdate(w http.ResponseWriter, r *http.Request) {
+	var jMetric models.Metrics
+
+	if err := codec.DecodeMetric(r.Body, &jMetric); err != nil {
+		telemetry.DecodeErrors.Inc("json")
+		h.writeDecodeError(w, err)
+		return
+	}
+
+	metrics := []models.Metrics{jMetric}
+	if err := markCumulative(r, metrics); err != nil {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: counterModeHeader})
+		return
+	}
+
+	if apiErr := h.validateMetric(&metrics[0]); apiErr != nil {
+		h.writeError(w, http.StatusBadRequest, *apiErr)
+		return
+	}
+
+	totals := h.toDeltas(r, metrics)
+	defer totals.Rollback()
+	jMetric = metrics[0]
+
+	var value any
+	switch jMetric.MType {
+	case types.Counter:
+		value = *jMetric.Delta
+	case types.Gauge:
+		value = *jMetric.Value
+	}
+
+	if err := h.Repo.SetMetric(r.Context(), jMetric.ID, types.Metric{MetricType: jMetric.MType, Value: value}); err != nil {
+		if errors.Is(err, telemetry.ErrReservedName) {
+			h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidName, Message: err.Error(), Field: "id"})
+			return
+		}
+		if errors.Is(err, types.ErrTypeConflict) {
+			h.writeError(w, http.StatusConflict, models.APIError{Code: errCodeTypeConflict, Message: err.Error(), Field: "type"})
+			return
+		}
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+	totals.Commit()
+
+	metric, err := h.Repo.Metric(r.Context(), jMetric.MType, jMetric.ID)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, metric.Convert(jMetric.ID))
+}
@@ -0,0 +1,150 @@
+// The APIUpdates function handles POST /api/v1/updates. It accepts an array of
+// metrics in JSON and, unlike JSONUpdates, does not abort the whole batch on
+// the first invalid item: every item is validated on its own, the valid ones
+// are stored in the repository with a single SetMetrics call and the response
+// reports the number of accepted metrics together with the rejected ones, their
+// position in the batch and the reason. A storage failure rejects the whole
+// batch with a 500 error envelope. When the type conflicts are rejected, a metric
+// whose name holds a metric of another type, stored or earlier in the batch, is
+// rejected on its own with the type_conflict code; only a metric of another type
+// written concurrently under the name still fails the whole batch with 409. A
+// streamed batch is checked by the storage only, a conflict fails it as a whole.
+// A batch delivered again with the same
+// X-Batch-ID is acknowledged with the same result marked as a duplicate. The
+// totals of cumulative counters are stored as their increases. A batch sent
+// with the application/x-protobuf content type is decoded from protobuf, one
+// sent with application/x-ndjson is streamed in chunks, see ndjson.go.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+func (h *Handlers) APIUpdates(w http.ResponseWriter, r *http.Request) {
+	c := codec.ForContentType(r.Header.Get("Content-Type"))
+	if c == codec.NDJSON {
+		h.apiUpdatesStream(w, r)
+		return
+	}
+
+	var jMetrics []models.Metrics
+	if err := codec.Decode(c, r.Body, &jMetrics); err != nil {
+		telemetry.DecodeErrors.Inc(codec.Name(c))
+		h.writeDecodeError(w, err)
+		return
+	}
+
+	if h.config.MaxBatchSize > 0 && len(jMetrics) > h.config.MaxBatchSize {
+		h.writeError(w, http.StatusRequestEntityTooLarge, models.APIError{
+			Code:    errCodeTooLarge,
+			Message: fmt.Sprintf("the batch has %d metrics, at most %d are allowed", len(jMetrics), h.config.MaxBatchSize),
+		})
+		return
+	}
+
+	if err := markCumulative(r, jMetrics); err != nil {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: counterModeHeader})
+		return
+	}
+
+	result := models.BatchResult{Rejected: []models.RejectedMetric{}}
+	valid := make([]models.Metrics, 0, len(jMetrics))
+	// позиции допустимых метрик в пакете
+	indexes := make([]int, 0, len(jMetrics))
+
+	for i := range jMetrics {
+		jMetric := &jMetrics[i]
+		if apiErr := h.validateMetric(jMetric); apiErr != nil {
+			result.Rejected = append(result.Rejected, models.RejectedMetric{Index: i, ID: jMetric.ID, Error: *apiErr})
+			continue
+		}
+		valid = append(valid, *jMetric)
+		indexes = append(indexes, i)
+	}
+
+	valid, err := h.rejectConflicts(r.Context(), valid, indexes, &result)
+	if err != nil {
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+		return
+	}
+
+	if len(valid) > 0 {
+		applied, err := h.applyBatch(w, r, func(ctx context.Context) error {
+			return h.setMetrics(ctx, r, valid)
+		})
+		if err != nil {
+			if errors.Is(err, errInvalidBatchID) {
+				h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: batchIDHeader})
+				return
+			}
+			if errors.Is(err, telemetry.ErrReservedName) {
+				h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidName, Message: err.Error(), Field: "id"})
+				return
+			}
+			if errors.Is(err, types.ErrTypeConflict) {
+				h.writeError(w, http.StatusConflict, models.APIError{Code: errCodeTypeConflict, Message: err.Error(), Field: "type"})
+				return
+			}
+			h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: err.Error()})
+			return
+		}
+		result.Duplicate = !applied
+	}
+
+	if len(result.Rejected) > 0 {
+		sort.Slice(result.Rejected, func(i, j int) bool { return result.Rejected[i].Index < result.Rejected[j].Index })
+		h.lg.Sugar.Infow("metrics rejected in batch", "rejected", len(result.Rejected), "accepted", len(valid))
+	}
+
+	result.Accepted = len(valid)
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+// rejectConflicts moves the metrics the storage would refuse for a metric of another type under their
+// name to the rejected ones of the result, when the type conflicts are rejected, and returns the rest.
+// indexes are the positions of the metrics in the batch.
+func (h *Handlers) rejectConflicts(ctx context.Context, metrics []models.Metrics, indexes []int, result *models.BatchResult) ([]models.Metrics, error) {
+	if len(metrics) == 0 || (h.config.TypeConflict != "" && h.config.TypeConflict != types.ConflictReject) {
+		return metrics, nil
+	}
+
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.ID)
+	}
+	stored, err := h.Repo.MetricsOf(ctx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	// тип, занимающий имя: хранимый или первой метрики пакета с этим именем
+	held := make(map[string]string, len(metrics))
+	for name, metric := range stored {
+		held[name] = metric.MetricType
+	}
+
+	accepted := metrics[:0]
+	for i, metric := range metrics {
+		mType, ok := held[metric.ID]
+		if ok && mType != metric.MType {
+			result.Rejected = append(result.Rejected, models.RejectedMetric{Index: indexes[i], ID: metric.ID, Error: models.APIError{
+				Code:    errCodeTypeConflict,
+				Message: fmt.Sprintf("%s: %s is a %s", types.ErrTypeConflict, metric.ID, mType),
+				Field:   "type",
+			}})
+			continue
+		}
+		held[metric.ID] = metric.MType
+		accepted = append(accepted, metric)
+	}
+	return accepted, nil
+}
@@ -0,0 +1,57 @@
+// The APIValue and APIValueByPath functions return the current value of
+// a metric: APIValue handles POST /api/v1/value with the metric id and type in
+// a JSON body, APIValueByPath handles GET /api/v1/value/{metricType}/{metricName}.
+// Both answer with the metric in JSON. An invalid request is reported with 400,
+// a metric that is not stored under the requested type with 404.
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+func (h *Handlers) APIValue(w http.ResponseWriter, r *http.Request) {
+	var jMetric models.Metrics
+
+	if err := codec.DecodeMetric(r.Body, &jMetric); err != nil {
+		telemetry.DecodeErrors.Inc("json")
+		h.writeDecodeError(w, err)
+		return
+	}
+
+	h.apiValue(w, r, jMetric.MType, jMetric.ID)
+}
+
+func (h *Handlers) APIValueByPath(w http.ResponseWriter, r *http.Request) {
+	h.apiValue(w, r, r.PathValue("metricType"), r.PathValue("metricName"))
+}
+
+func (h *Handlers) apiValue(w http.ResponseWriter, r *http.Request, mType, mName string) {
+	if err := types.CheckMetricType(mType); err != nil {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidType, Message: err.Error(), Field: "type"})
+		return
+	}
+
+	if len(mName) == 0 {
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidName, Message: "the name of the metric is empty", Field: "id"})
+		return
+	}
+
+	mName = h.config.Names.Normalize(mName)
+	metric, err := h.Repo.Metric(r.Context(), mType, mName)
+	if err != nil {
+		h.writeError(w, http.StatusNotFound, models.APIError{
+			Code:    errCodeNotFound,
+			Message: fmt.Sprintf("%s metric %s not found", mType, mName),
+			Field:   "id",
+		})
+		return
+	}
+
+	h.writeJSON(w, http.StatusOK, metric.Convert(mName))
+}
@@ -0,0 +1,57 @@
+// The agent sends the ID of a metric batch in the X-Batch-ID header and keeps it
+// when it retries the delivery. The batch handlers apply the batch together with
+// recording its ID, a batch that fails to apply leaves no ID behind. A batch
+// whose ID is already recorded is acknowledged with the usual response and the
+// X-Batch-Duplicate header, but its metrics are not applied again. Requests
+// without the header are always applied.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	batchIDHeader        = "X-Batch-ID"
+	batchDuplicateHeader = "X-Batch-Duplicate"
+	// maxBatchIDLength - максимальная длина идентификатора пакета
+	maxBatchIDLength = 128
+)
+
+var errInvalidBatchID = errors.New("invalid batch ID")
+
+// batchID returns the ID of the batch sent with the request, it fails with errInvalidBatchID if the ID is too long.
+func batchID(r *http.Request) (string, error) {
+	id := r.Header.Get(batchIDHeader)
+	if len(id) > maxBatchIDLength {
+		return "", fmt.Errorf("%w: longer than %d characters", errInvalidBatchID, maxBatchIDLength)
+	}
+	return id, nil
+}
+
+// applyBatch applies the batch with apply unless the ID of the request is already recorded and
+// reports whether it was applied. It fails with errInvalidBatchID if the ID is too long, with
+// dedup.ErrStore if the ID cannot be recorded and with the error of apply as it is.
+func (h *Handlers) applyBatch(w http.ResponseWriter, r *http.Request, apply func(ctx context.Context) error) (bool, error) {
+	id, err := batchID(r)
+	if err != nil {
+		return false, err
+	}
+	if id == "" || h.Batches == nil {
+		return true, apply(r.Context())
+	}
+
+	applied, err := h.Batches.Apply(r.Context(), id, apply)
+	if err != nil {
+		return applied, err
+	}
+
+	if !applied {
+		h.lg.Sugar.Infow("duplicate batch acknowledged", "batch id", id)
+		w.Header().Set(batchDuplicateHeader, "true")
+	}
+
+	return applied, nil
+}
@@ -0,0 +1,19 @@
+// The request bodies are limited by the server: the limits middleware caps the
+// body as it is received and the compression middleware caps it after
+// decompression. Reading past either limit fails with *http.MaxBytesError,
+// the handlers answer such requests with 413 instead of 400.
+package handlers
+
+import (
+	"errors"
+	"net/http"
+)
+
+// bodyStatus returns the status code for an error returned while reading the request body.
+func bodyStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
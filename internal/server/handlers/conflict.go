@@ -0,0 +1,21 @@
+// A write of a metric under a name that holds a metric of another type is rejected
+// by the repository with types.ErrTypeConflict, unless the server keeps the names
+// of every type apart or lets the new type overwrite the stored metric. The handlers
+// answer such writes with 409, the /api/v1 ones with the type_conflict error code.
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// storeStatus returns the status code for an error returned by the repository on a write:
+// a metric of another type stored under the name is a conflict, other errors get the status.
+func storeStatus(err error, status int) int {
+	if errors.Is(err, types.ErrTypeConflict) {
+		return http.StatusConflict
+	}
+	return status
+}
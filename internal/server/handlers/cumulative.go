@@ -0,0 +1,98 @@
+// A source that reports the running totals of its counters instead of their
+// increases either flags such counters with "cumulative": true or sends the
+// X-Counter-Mode: cumulative header, which makes every counter of the request
+// cumulative. The server keeps the last total of every counter of every source
+// and adds only the difference to the stored counter. The source is named by the
+// X-Source-ID header, without it by the API key of the request and without a key
+// by the address of the client.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/auth"
+	"github.com/plasmatrip/metriq/internal/server/cumulative"
+	"github.com/plasmatrip/metriq/internal/server/limits"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	counterModeHeader = "X-Counter-Mode"
+	sourceIDHeader    = "X-Source-ID"
+
+	counterModeDelta      = "delta"
+	counterModeCumulative = "cumulative"
+)
+
+var errInvalidCounterMode = errors.New("invalid counter mode")
+
+// markCumulative flags every counter of the request as cumulative if the request asks for
+// the cumulative mode. It fails with errInvalidCounterMode if the mode is unknown.
+func markCumulative(r *http.Request, metrics []models.Metrics) error {
+	switch mode := r.Header.Get(counterModeHeader); mode {
+	case "", counterModeDelta:
+		return nil
+	case counterModeCumulative:
+		for i := range metrics {
+			if metrics[i].MType == types.Counter {
+				metrics[i].Cumulative = true
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %q, expected %q or %q", errInvalidCounterMode, mode, counterModeDelta, counterModeCumulative)
+	}
+}
+
+// checkCumulative checks that only counters are flagged as cumulative and that their totals are not negative.
+func checkCumulative(m models.Metrics) *models.APIError {
+	if !m.Cumulative {
+		return nil
+	}
+
+	if m.MType != types.Counter {
+		return &models.APIError{Code: errCodeInvalidValue, Message: "only counters can be cumulative", Field: "cumulative"}
+	}
+
+	if m.Delta != nil && *m.Delta < 0 {
+		return &models.APIError{Code: errCodeInvalidValue, Message: "the total of a cumulative counter is negative", Field: "delta"}
+	}
+
+	return nil
+}
+
+// toDeltas replaces the totals of the cumulative counters with their increases since the
+// previous report of the source. It must be called only for metrics that are going to be applied,
+// the returned batch is committed once they are stored and rolled back otherwise.
+func (h *Handlers) toDeltas(r *http.Request, metrics []models.Metrics) *cumulative.Batch {
+	return h.counters.ToDeltas(source(r), metrics, false)
+}
+
+// setMetrics stores the metrics with the totals of the cumulative counters replaced with their
+// increases, the totals are kept only if the metrics are stored.
+func (h *Handlers) setMetrics(ctx context.Context, r *http.Request, metrics []models.Metrics) error {
+	totals := h.toDeltas(r, metrics)
+	defer totals.Rollback()
+
+	if err := h.Repo.SetMetrics(ctx, metrics); err != nil {
+		return err
+	}
+
+	totals.Commit()
+	return nil
+}
+
+// source names the source of the request for the cumulative counters.
+func source(r *http.Request) string {
+	if id := r.Header.Get(sourceIDHeader); id != "" {
+		return "source:" + id
+	}
+	if key := auth.ClientKey(r); key != "" {
+		return key
+	}
+	return limits.ClientIP(r)
+}
@@ -6,8 +6,14 @@
 package handlers
 
 import (
+	"time"
+
 	"github.com/plasmatrip/metriq/internal/logger"
 	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/cumulative"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+	"github.com/plasmatrip/metriq/internal/server/health"
+	"github.com/plasmatrip/metriq/internal/server/otlp"
 	"github.com/plasmatrip/metriq/internal/storage"
 )
 
@@ -27,17 +33,40 @@ import (
 //   is crucial for debugging and monitoring the application, providing insights into the flow
 //   of requests and any issues that arise during execution.
 //
+// - Probe: The readiness probe reported by /readyz. By default it only checks that the storage
+//   is reachable, the server replaces it with a probe that also checks the backup file and
+//   is switched to draining on shutdown.
+//
+// - Batches: The IDs of the metric batches applied recently, duplicates delivered again by
+//   a retrying agent are acknowledged without applying them. By default the IDs are kept in
+//   memory, with a database the server keeps them in a table.
+//
+// - counters: The last totals of the cumulative counters reported by every source, used to
+//   turn them into the increases added to the stored counters.
+//
 // The Handlers struct is instantiated via the NewHandlers function, which requires a repository,
 // configuration, and logger as parameters to initialize a new instance. The handlers utilize
 // these components to effectively manage the lifecycle of HTTP requests, ensuring data integrity,
 // adherence to configuration, and comprehensive logging throughout the application's runtime.
 
 type Handlers struct {
-	Repo   storage.Repository
-	config config.Config
-	lg     logger.Logger
+	Repo     storage.Repository
+	config   config.Config
+	lg       logger.Logger
+	otlp     *otlp.Converter
+	Probe    *health.Probe
+	Batches  dedup.Store
+	counters *cumulative.Tracker
 }
 
 func NewHandlers(repo storage.Repository, config config.Config, lg logger.Logger) *Handlers {
-	return &Handlers{Repo: repo, config: config, lg: lg}
+	return &Handlers{
+		Repo:     repo,
+		config:   config,
+		lg:       lg,
+		otlp:     otlp.NewConverter(config.OTLPResourceAttrs, config.OTLPLabels),
+		Probe:    health.NewProbe(health.Check{Name: "storage", Fn: repo.Ping}),
+		Batches:  dedup.NewCache(config.BatchIDCacheSize, time.Duration(config.BatchIDTTL)*time.Second),
+		counters: cumulative.NewTracker(cumulative.DefaultSize, cumulative.DefaultTTL),
+	}
 }
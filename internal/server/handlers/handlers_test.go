@@ -1,22 +1,41 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/parquet-go/parquet-go"
+	"github.com/plasmatrip/metriq/internal/codec"
 	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
 	"github.com/plasmatrip/metriq/internal/server/compress"
 	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage/history"
 	"github.com/plasmatrip/metriq/internal/storage/mem"
 	"github.com/plasmatrip/metriq/internal/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestPingHandler(t *testing.T) {
@@ -182,6 +201,21 @@ func TestJSONUpdateHandler(t *testing.T) {
 			want: http.StatusBadRequest,
 			data: map[string]interface{}{"metric": 42, "delta": "aa"},
 		},
+		{
+			name: "Counter without delta",
+			want: http.StatusBadRequest,
+			data: map[string]interface{}{"id": "counter", "type": "counter"},
+		},
+		{
+			name: "Gauge with delta",
+			want: http.StatusBadRequest,
+			data: map[string]interface{}{"id": "metric", "type": "gauge", "value": 10, "delta": 1},
+		},
+		{
+			name: "Unknown field",
+			want: http.StatusBadRequest,
+			data: map[string]interface{}{"id": "metric", "type": "gauge", "value": 10, "unit": "s"},
+		},
 	}
 
 	storage := mem.NewStorage()
@@ -245,6 +279,11 @@ func TestUpdateHandler(t *testing.T) {
 			url:  "/update/counter/metric/aa",
 			want: http.StatusBadRequest,
 		},
+		{
+			name: "Not finite value",
+			url:  "/update/gauge/metric/NaN",
+			want: http.StatusBadRequest,
+		},
 	}
 	h := NewHandlers(mem.NewStorage(), config.Config{}, logger.Logger{})
 	mux := http.NewServeMux()
@@ -266,6 +305,1035 @@ func TestUpdateHandler(t *testing.T) {
 	}
 }
 
+func TestMetricNamePolicy(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	reject, err := types.NewNamePolicy(types.NameRules{Chars: "[a-z0-9_.]", MaxLength: 16, ReservedPrefixes: []string{"sys."}, Case: types.CaseLower})
+	require.NoError(t, err)
+	sanitize, err := types.NewNamePolicy(types.NameRules{Mode: types.NameSanitize, Chars: "[a-z0-9_.]", MaxLength: 16, Case: types.CaseLower})
+	require.NoError(t, err)
+
+	t.Run("Reject", func(t *testing.T) {
+		storage := mem.NewStorage()
+		h := NewHandlers(storage, config.Config{Names: reject}, log)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/update/{metricType}/{metricName}/{metricValue}", h.Update)
+		mux.HandleFunc("/value/{metricType}/{metricName}", h.Value)
+		mux.HandleFunc("/updates/", h.JSONUpdates)
+		mux.HandleFunc("/api/v1/updates", h.APIUpdates)
+		serv := httptest.NewServer(mux)
+		defer serv.Close()
+
+		post := func(url, body string) (int, string) {
+			res, err := serv.Client().Post(serv.URL+url, "application/json", bytes.NewBufferString(body))
+			require.NoError(t, err)
+			defer res.Body.Close()
+			resp, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			return res.StatusCode, string(resp)
+		}
+
+		// имя приводится к нижнему регистру при записи и при чтении
+		code, _ := post("/update/gauge/Load/1.5", "")
+		assert.Equal(t, http.StatusOK, code)
+		res, err := serv.Client().Get(serv.URL + "/value/gauge/LOAD")
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		code, _ = post("/update/gauge/cpu-load/1", "")
+		assert.Equal(t, http.StatusBadRequest, code)
+
+		code, _ = post("/updates/", `[{"id":"requests","type":"counter","delta":1},{"id":"sys.load","type":"gauge","value":1}]`)
+		assert.Equal(t, http.StatusBadRequest, code)
+		_, err = storage.Metric(context.Background(), types.Counter, "requests")
+		assert.Error(t, err)
+
+		code, body := post("/api/v1/updates", `[{"id":"Requests","type":"counter","delta":1},{"id":"a_very_long_metric_name","type":"gauge","value":1}]`)
+		require.Equal(t, http.StatusOK, code)
+		var result models.BatchResult
+		require.NoError(t, json.Unmarshal([]byte(body), &result))
+		assert.Equal(t, 1, result.Accepted)
+		require.Len(t, result.Rejected, 1)
+		assert.Equal(t, "a_very_long_metric_name", result.Rejected[0].ID)
+		assert.Equal(t, errCodeInvalidName, result.Rejected[0].Error.Code)
+
+		metric, err := storage.Metric(context.Background(), types.Counter, "requests")
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), metric.Value)
+	})
+
+	t.Run("Sanitize", func(t *testing.T) {
+		storage := mem.NewStorage()
+		h := NewHandlers(storage, config.Config{Names: sanitize}, log)
+
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"id":"CPU Load","type":"gauge","value":0.5}`))
+		w := httptest.NewRecorder()
+		h.APIUpdate(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var metric models.Metrics
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&metric))
+		assert.Equal(t, "cpu_load", metric.ID)
+
+		r = httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"id":"a_very_long_metric_name","type":"counter","delta":2}`))
+		w = httptest.NewRecorder()
+		h.JSONUpdate(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		_, err := storage.Metric(context.Background(), "", "a_very_long_metr")
+		assert.NoError(t, err)
+	})
+}
+
+func TestTypeConflict(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	newServer := func(conflict string) *httptest.Server {
+		storage := mem.NewStorage()
+		storage.SetTypeConflict(conflict)
+		h := NewHandlers(storage, config.Config{TypeConflict: conflict}, log)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/update/{metricType}/{metricName}/{metricValue}", h.Update)
+		mux.HandleFunc("/value/{metricType}/{metricName}", h.Value)
+		mux.HandleFunc("/updates/", h.JSONUpdates)
+		mux.HandleFunc("/api/v1/update", h.APIUpdate)
+		mux.HandleFunc("/api/v1/updates", h.APIUpdates)
+		return httptest.NewServer(mux)
+	}
+	request := func(serv *httptest.Server, method, url, body string) (int, string) {
+		r, err := http.NewRequest(method, serv.URL+url, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		r.Header.Set("Content-Type", "application/json")
+		res, err := serv.Client().Do(r)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		resp, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(resp)
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		serv := newServer(types.ConflictReject)
+		defer serv.Close()
+
+		code, _ := request(serv, http.MethodPost, "/update/counter/load/5", "")
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = request(serv, http.MethodPost, "/update/gauge/load/1.5", "")
+		assert.Equal(t, http.StatusConflict, code)
+
+		code, _ = request(serv, http.MethodPost, "/updates/", `[{"id":"load","type":"gauge","value":1.5}]`)
+		assert.Equal(t, http.StatusConflict, code)
+
+		code, body := request(serv, http.MethodPost, "/api/v1/update", `{"id":"load","type":"gauge","value":1.5}`)
+		assert.Equal(t, http.StatusConflict, code)
+		var apiErr struct {
+			Error models.APIError `json:"error"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &apiErr))
+		assert.Equal(t, errCodeTypeConflict, apiErr.Error.Code)
+
+		// конфликтующие метрики пакета отклоняются по одной, остальные записываются
+		code, body = request(serv, http.MethodPost, "/api/v1/updates",
+			`[{"id":"load","type":"gauge","value":1.5},{"id":"free","type":"gauge","value":2},{"id":"free","type":"counter","delta":1}]`)
+		require.Equal(t, http.StatusOK, code)
+		var result models.BatchResult
+		require.NoError(t, json.Unmarshal([]byte(body), &result))
+		assert.Equal(t, 1, result.Accepted)
+		require.Len(t, result.Rejected, 2)
+		assert.Equal(t, 0, result.Rejected[0].Index)
+		assert.Equal(t, 2, result.Rejected[1].Index)
+		assert.Equal(t, errCodeTypeConflict, result.Rejected[1].Error.Code)
+		code, body = request(serv, http.MethodGet, "/value/gauge/free", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "2", body)
+
+		// метрика другого типа под именем не найдена
+		code, _ = request(serv, http.MethodGet, "/value/gauge/load", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		code, body = request(serv, http.MethodGet, "/value/counter/load", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "5", body)
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		serv := newServer(types.ConflictNamespace)
+		defer serv.Close()
+
+		code, _ := request(serv, http.MethodPost, "/update/counter/load/5", "")
+		require.Equal(t, http.StatusOK, code)
+		code, _ = request(serv, http.MethodPost, "/update/gauge/load/1.5", "")
+		require.Equal(t, http.StatusOK, code)
+
+		code, body := request(serv, http.MethodGet, "/value/counter/load", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "5", body)
+		code, body = request(serv, http.MethodGet, "/value/gauge/load", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "1.5", body)
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		serv := newServer(types.ConflictOverwrite)
+		defer serv.Close()
+
+		code, _ := request(serv, http.MethodPost, "/update/counter/load/5", "")
+		require.Equal(t, http.StatusOK, code)
+		code, _ = request(serv, http.MethodPost, "/update/gauge/load/1.5", "")
+		require.Equal(t, http.StatusOK, code)
+
+		code, _ = request(serv, http.MethodGet, "/value/counter/load", "")
+		assert.Equal(t, http.StatusNotFound, code)
+		code, body := request(serv, http.MethodGet, "/value/gauge/load", "")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "1.5", body)
+	})
+}
+
+func TestOTLPMetricsHandler(t *testing.T) {
+	jsonBody := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"api"}}]},
+		"scopeMetrics":[{"metrics":[{"name":"requests","sum":{"aggregationTemporality":1,"isMonotonic":true,
+		"dataPoints":[{"asInt":"3"}]}}]}]}]}`
+
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+				Name: "load",
+				Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+					Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 0.5},
+				}}}},
+			}}}},
+		}},
+	}
+	protoBody, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        int
+	}{
+		{
+			name:        "JSON encoding",
+			contentType: "application/json",
+			body:        []byte(jsonBody),
+			want:        http.StatusOK,
+		},
+		{
+			name:        "Protobuf encoding",
+			contentType: "application/x-protobuf",
+			body:        protoBody,
+			want:        http.StatusOK,
+		},
+		{
+			name:        "Wrong body",
+			contentType: "application/json",
+			body:        []byte("{wrong"),
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "Unsupported content type",
+			contentType: "text/plain",
+			body:        []byte("requests 1"),
+			want:        http.StatusUnsupportedMediaType,
+		},
+	}
+
+	storage := mem.NewStorage()
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	h := NewHandlers(storage, config.Config{OTLPResourceAttrs: "service.name"}, log)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/metrics", h.OTLPMetrics)
+	serv := httptest.NewServer(mux)
+	defer serv.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodPost, serv.URL+"/v1/metrics", bytes.NewReader(test.body))
+			require.NoError(t, err)
+			request.Header.Set("Content-Type", test.contentType)
+
+			res, err := serv.Client().Do(request)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, test.want, res.StatusCode)
+			if test.want == http.StatusOK {
+				assert.Equal(t, test.contentType, res.Header.Get("Content-Type"))
+			}
+		})
+	}
+
+	counter, err := storage.Metric(context.Background(), types.Counter, "api.requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counter.Value)
+
+	gauge, err := storage.Metric(context.Background(), types.Gauge, "load")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, gauge.Value)
+}
+
+func TestAPIUpdateHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+		err  models.APIError
+	}{
+		{
+			name: "Valid gauge",
+			body: `{"id":"metric","type":"gauge","value":10}`,
+			want: http.StatusOK,
+		},
+		{
+			name: "Empty name",
+			body: `{"id":"","type":"gauge","value":10}`,
+			want: http.StatusBadRequest,
+			err:  models.APIError{Code: errCodeInvalidName, Field: "id"},
+		},
+		{
+			name: "Counter without delta",
+			body: `{"id":"counter","type":"counter","value":10}`,
+			want: http.StatusBadRequest,
+			err:  models.APIError{Code: errCodeInvalidValue, Field: "delta"},
+		},
+		{
+			name: "Wrong type",
+			body: `{"id":"metric","type":"wrong","value":10}`,
+			want: http.StatusBadRequest,
+			err:  models.APIError{Code: errCodeInvalidType, Field: "type"},
+		},
+		{
+			name: "Gauge with delta",
+			body: `{"id":"metric","type":"gauge","value":10,"delta":1}`,
+			want: http.StatusBadRequest,
+			err:  models.APIError{Code: errCodeInvalidValue, Field: "delta"},
+		},
+		{
+			name: "Unknown field",
+			body: `{"id":"metric","type":"gauge","value":10,"unit":"s"}`,
+			want: http.StatusBadRequest,
+			err:  models.APIError{Code: errCodeInvalidJSON, Field: "unit"},
+		},
+	}
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	h := NewHandlers(mem.NewStorage(), config.Config{}, log)
+	serv := httptest.NewServer(http.HandlerFunc(h.APIUpdate))
+	defer serv.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := serv.Client().Post(serv.URL, "application/json", bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, test.want, res.StatusCode)
+			assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+			if test.want != http.StatusOK {
+				var resp models.ErrorResponse
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+				assert.Equal(t, test.err.Code, resp.Error.Code)
+				assert.Equal(t, test.err.Field, resp.Error.Field)
+				assert.NotEmpty(t, resp.Error.Message)
+			}
+		})
+	}
+}
+
+func TestAPIUpdatesHandler(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	h := NewHandlers(storage, config.Config{}, log)
+	serv := httptest.NewServer(http.HandlerFunc(h.APIUpdates))
+	defer serv.Close()
+
+	body := `[
+		{"id":"load","type":"gauge","value":0.5},
+		{"id":"","type":"gauge","value":1},
+		{"id":"requests","type":"counter","delta":3},
+		{"id":"broken","type":"wrong","delta":3}
+	]`
+
+	res, err := serv.Client().Post(serv.URL, "application/json", bytes.NewBufferString(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var result models.BatchResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, 2, result.Accepted)
+	require.Len(t, result.Rejected, 2)
+	assert.Equal(t, 1, result.Rejected[0].Index)
+	assert.Equal(t, errCodeInvalidName, result.Rejected[0].Error.Code)
+	assert.Equal(t, 3, result.Rejected[1].Index)
+	assert.Equal(t, "broken", result.Rejected[1].ID)
+	assert.Equal(t, errCodeInvalidType, result.Rejected[1].Error.Code)
+
+	metric, err := storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metric.Value)
+
+	_, err = storage.Metric(context.Background(), "", "broken")
+	assert.Error(t, err)
+}
+
+func TestAPIQueryHandler(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	storage := mem.NewStorage()
+	storage.SetSampleRetention(time.Hour)
+	for _, v := range []int64{2, 3} {
+		require.NoError(t, storage.SetMetric(ctx, "http_requests", types.Metric{MetricType: types.Counter, Value: v}))
+	}
+	require.NoError(t, storage.SetMetric(ctx, "http_errors", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+
+	h := NewHandlers(storage, config.Config{}, log)
+
+	get := func(query string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/query?"+query, nil)
+		w := httptest.NewRecorder()
+		h.APIQuery(w, r)
+		return w.Result()
+	}
+
+	res := get("name=http_*&agg=sum&window=15m")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var result models.QueryResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, "sum", result.Aggregation)
+	assert.Equal(t, 15*time.Minute, result.To.Sub(result.From))
+	require.NotNil(t, result.Value)
+	assert.Equal(t, 6.0, *result.Value)
+	assert.Equal(t, []models.SeriesAggregate{
+		{ID: "http_errors", MType: types.Counter, Value: 1, Samples: 1},
+		{ID: "http_requests", MType: types.Counter, Value: 5, Samples: 2},
+	}, result.Series)
+
+	tests := []struct {
+		query string
+		field string
+	}{
+		{"agg=sum", "name"},
+		{"name=x&agg=median", "agg"},
+		{"name=x&agg=sum&window=-1m", "window"},
+		{"name=x&agg=sum&window=soon", "window"},
+	}
+	for _, tt := range tests {
+		res := get(tt.query)
+		var apiErr models.ErrorResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&apiErr))
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, tt.query)
+		assert.Equal(t, tt.field, apiErr.Error.Field, tt.query)
+	}
+}
+
+func TestAPIEvalHandler(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	storage := mem.NewStorage()
+	require.NoError(t, storage.SetMetric(ctx, "HeapInuse", types.Metric{MetricType: types.Gauge, Value: float64(30)}))
+	require.NoError(t, storage.SetMetric(ctx, "HeapSys", types.Metric{MetricType: types.Gauge, Value: float64(120)}))
+
+	h := NewHandlers(storage, config.Config{}, log)
+
+	get := func(expr string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/eval?expr="+url.QueryEscape(expr), nil)
+		w := httptest.NewRecorder()
+		h.APIEval(w, r)
+		return w.Result()
+	}
+
+	res := get("HeapInuse / HeapSys * 100")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var result models.ExprResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, models.ExprResult{
+		Expr:   "HeapInuse / HeapSys * 100",
+		Type:   "vector",
+		Series: []models.ExprSample{{ID: "HeapInuse", Value: 25}},
+	}, result)
+
+	res = get("count(\"Heap*\") + 1")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	result = models.ExprResult{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	require.NotNil(t, result.Value)
+	assert.Equal(t, 3.0, *result.Value)
+
+	for _, expr := range []string{"", "HeapSys +", "rate(HeapSys)"} {
+		res := get(expr)
+		var apiErr models.ErrorResponse
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&apiErr))
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode, expr)
+		assert.Equal(t, "expr", apiErr.Error.Field, expr)
+	}
+
+	// у каждого типа свои имена, метрики выбираются по имени без типа
+	storage = mem.NewStorage()
+	storage.SetTypeConflict(types.ConflictNamespace)
+	require.NoError(t, storage.SetMetric(ctx, "HeapInuse", types.Metric{MetricType: types.Gauge, Value: float64(30)}))
+	require.NoError(t, storage.SetMetric(ctx, "HeapSys", types.Metric{MetricType: types.Gauge, Value: float64(120)}))
+	h = NewHandlers(storage, config.Config{TypeConflict: types.ConflictNamespace}, log)
+
+	res = get("HeapInuse / HeapSys * 100")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	result = models.ExprResult{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, []models.ExprSample{{ID: "HeapInuse", Value: 25}}, result.Series)
+}
+
+func TestAPIExportHandler(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	storage := history.NewStorage(mem.NewStorage(), history.DefaultSize)
+	require.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(0.5)}))
+	require.NoError(t, storage.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(3)}))
+	require.NoError(t, storage.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(4)}))
+
+	h := NewHandlers(storage, config.Config{}, log)
+
+	get := func(query string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/export?"+query, nil)
+		w := httptest.NewRecorder()
+		h.APIExport(w, r)
+		return w.Result()
+	}
+
+	res := get("format=csv")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/csv", res.Header.Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="metrics-.+\.csv"$`, res.Header.Get("Content-Disposition"))
+	records, err := csv.NewReader(res.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+	assert.Equal(t, []string{"id", "type", "time", "value", "delta"}, records[0])
+	assert.Equal(t, []string{"PollCount", "counter"}, records[1][:2])
+	assert.Equal(t, []string{"load", "gauge"}, records[2][:2])
+	assert.Equal(t, []string{"0.5", ""}, records[2][3:])
+	assert.Equal(t, []string{"", "7"}, records[3][3:])
+
+	// история значений с фильтром по префиксу
+	res = get("format=ndjson&history=true&prefix=req")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var rows []models.ExportRow
+	dec := json.NewDecoder(res.Body)
+	for dec.More() {
+		var row models.ExportRow
+		require.NoError(t, dec.Decode(&row))
+		rows = append(rows, row)
+	}
+	require.Len(t, rows, 2)
+	assert.Equal(t, int64(3), *rows[0].Delta)
+	assert.Equal(t, int64(7), *rows[1].Delta)
+
+	res = get("format=parquet&history=true&from=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	rows, err = parquet.Read[models.ExportRow](bytes.NewReader(body), int64(len(body)))
+	require.NoError(t, err)
+	// PollCount увеличивается хранилищем и в историю не попадает
+	require.Len(t, rows, 3)
+	assert.Equal(t, "load", rows[0].ID)
+	assert.Equal(t, 0.5, *rows[0].Value)
+	assert.Equal(t, int64(7), *rows[2].Delta)
+
+	// записанные значения вне интервала не выгружаются
+	res = get("format=ndjson&history=true&to=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+	defer res.Body.Close()
+	body, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Empty(t, body)
+
+	// к текущим значениям интервал не применяется
+	res = get("format=csv&to=" + url.QueryEscape(time.Now().Add(-time.Hour).Format(time.RFC3339)))
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	var apiErr models.ErrorResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&apiErr))
+	assert.Equal(t, "to", apiErr.Error.Field)
+
+	for query, status := range map[string]int{
+		"format=xml":                  http.StatusBadRequest,
+		"format=csv&from=yesterday":   http.StatusBadRequest,
+		"format=csv&history=false&to": http.StatusOK,
+	} {
+		res := get(query)
+		res.Body.Close()
+		assert.Equal(t, status, res.StatusCode, query)
+	}
+
+	h = NewHandlers(mem.NewStorage(), config.Config{}, log)
+	res = get("format=csv&history=true")
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+}
+
+func TestExporter_Abort(t *testing.T) {
+	errFailed := errors.New("storage failed")
+
+	var buf bytes.Buffer
+	exp := newNDJSONExporter(&buf)
+	require.NoError(t, exp.Write(models.ExportRow{ID: "load", MType: types.Gauge}))
+	require.NoError(t, exp.Abort(1, errFailed))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"incomplete":true,"rows":1,"error":"storage failed"}`, lines[1])
+
+	buf.Reset()
+	exp = newCSVExporter(&buf)
+	require.NoError(t, exp.Abort(0, errFailed))
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "#", records[1][0])
+	assert.Contains(t, records[1][1], "storage failed")
+}
+
+func TestUpdatesProtobuf(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	h := NewHandlers(storage, config.Config{}, log)
+
+	delta := int64(3)
+	body, err := proto.Marshal(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		pb.FromModel(models.Metrics{ID: "requests", MType: types.Counter, Delta: &delta}),
+		{Id: "broken"},
+	}})
+	require.NoError(t, err)
+
+	post := func(handler http.HandlerFunc) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		r.Header.Set("Content-Type", codec.Protobuf)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	// в /api/v1/updates метрика без типа отклоняется отдельно от пакета
+	res := post(h.APIUpdates)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var result models.BatchResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, 1, result.Accepted)
+	require.Len(t, result.Rejected, 1)
+	assert.Equal(t, errCodeInvalidType, result.Rejected[0].Error.Code)
+
+	// /updates отклоняет весь пакет
+	res = post(h.JSONUpdates)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	metric, err := storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metric.Value)
+}
+
+func TestUpdatesNDJSON(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	// пакет больше MaxBatchSize применяется частями по две метрики
+	h := NewHandlers(storage, config.Config{MaxBatchSize: 2}, log)
+
+	post := func(handler http.HandlerFunc, body string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", codec.NDJSON)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+	value := func(name string) any {
+		metric, err := storage.Metric(context.Background(), "", name)
+		require.NoError(t, err)
+		return metric.Value
+	}
+
+	body := `{"id":"requests","type":"counter","delta":1}
+{"id":"requests","type":"counter","delta":2}
+
+{"id":"load","type":"gauge","value":0.5}
+not a metric
+{"id":"broken","type":"wrong","delta":3}
+{"id":"requests","type":"counter","delta":4}
+`
+
+	// в /api/v1/updates отклоняются только неверные строки
+	res := post(h.APIUpdates, body)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var result models.BatchResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, 4, result.Accepted)
+	assert.Equal(t, 2, result.RejectedCount)
+	require.Len(t, result.Rejected, 2)
+	assert.Equal(t, 3, result.Rejected[0].Index)
+	assert.Equal(t, errCodeInvalidJSON, result.Rejected[0].Error.Code)
+	assert.Equal(t, 4, result.Rejected[1].Index)
+	assert.Equal(t, "broken", result.Rejected[1].ID)
+	assert.Equal(t, errCodeInvalidType, result.Rejected[1].Error.Code)
+	assert.Equal(t, int64(7), value("requests"))
+	assert.Equal(t, 0.5, value("load"))
+
+	// /updates останавливается на первой неверной строке, примененные части остаются
+	res = post(h.JSONUpdates, body)
+	msg, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Contains(t, string(msg), "3 metrics applied")
+	assert.Equal(t, int64(10), value("requests"))
+
+	res = post(h.JSONUpdates, "{\"id\":\"requests\",\"type\":\"counter\",\"delta\":5}\n")
+	msg, err = io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, `"1 metrics received"`, string(msg))
+	assert.Equal(t, int64(15), value("requests"))
+
+	postID := func(handler http.HandlerFunc, body, id string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", codec.NDJSON)
+		r.Header.Set(batchIDHeader, id)
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	// пакет с идентификатором, остановленный на неверной строке, не применяется и не запоминается
+	res = postID(h.JSONUpdates, "{\"id\":\"requests\",\"type\":\"counter\",\"delta\":1}\nnot a metric\n", "ndjson-1")
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	assert.Equal(t, int64(15), value("requests"))
+
+	res = postID(h.JSONUpdates, "{\"id\":\"requests\",\"type\":\"counter\",\"delta\":1}\n", "ndjson-1")
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Empty(t, res.Header.Get(batchDuplicateHeader))
+	assert.Equal(t, int64(16), value("requests"))
+
+	// пакет с идентификатором длиннее maxBufferedStream отклоняется целиком
+	buffered := maxBufferedStream
+	maxBufferedStream = 2
+	defer func() { maxBufferedStream = buffered }()
+	for _, handler := range []http.HandlerFunc{h.JSONUpdates, h.APIUpdates} {
+		res = postID(handler, strings.Repeat("{\"id\":\"requests\",\"type\":\"counter\",\"delta\":1}\n", 3), "ndjson-2")
+		res.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	}
+	assert.Equal(t, int64(16), value("requests"))
+}
+
+func TestWithHashing(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	h := NewHandlers(storage, config.Config{Key: "secret"}, log)
+	handler := h.WithHashing(http.HandlerFunc(h.JSONUpdates))
+
+	// тело больше maxSpoolMemory хранится во временном файле
+	var b strings.Builder
+	for b.Len() <= maxSpoolMemory {
+		b.WriteString(`{"id":"requests","type":"counter","delta":1}` + "\n")
+	}
+	body := b.String()
+	lines := strings.Count(body, "\n")
+
+	post := func(body, hash string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header.Set("Content-Type", codec.NDJSON)
+		r.Header.Set("HashSHA256", hash)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	hm := hmac.New(sha256.New, []byte("secret"))
+	hm.Write([]byte(body))
+	hash := base64.StdEncoding.EncodeToString(hm.Sum(nil))
+
+	// тело с неверной подписью не применяется совсем
+	assert.Equal(t, http.StatusBadRequest, post(body+"\n", hash))
+	_, err = storage.Metric(context.Background(), types.Counter, "requests")
+	assert.Error(t, err)
+
+	assert.Equal(t, http.StatusOK, post(body, hash))
+	metric, err := storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(lines), metric.Value)
+
+	// без ограничения тела хранимое для проверки тело ограничено maxSpoolSize
+	size := maxSpoolSize
+	maxSpoolSize = maxSpoolMemory
+	defer func() { maxSpoolSize = size }()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post(body, hash))
+	metric, err = storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(lines), metric.Value)
+}
+
+func TestBatchDuplicates(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	h := NewHandlers(storage, config.Config{}, log)
+
+	post := func(handler http.HandlerFunc, batchID string) *http.Response {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[{"id":"requests","type":"counter","delta":3}]`))
+		if batchID != "" {
+			r.Header.Set(batchIDHeader, batchID)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w.Result()
+	}
+
+	for i, handler := range []http.HandlerFunc{h.JSONUpdates, h.APIUpdates} {
+		res := post(handler, fmt.Sprintf("batch-%d", i))
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Empty(t, res.Header.Get(batchDuplicateHeader))
+	}
+
+	// повторная доставка пакета подтверждается, но не применяется
+	res := post(h.APIUpdates, "batch-0")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "true", res.Header.Get(batchDuplicateHeader))
+	var result models.BatchResult
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+	assert.Equal(t, 1, result.Accepted)
+	assert.True(t, result.Duplicate)
+
+	metric, err := storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), metric.Value)
+
+	// пакеты без идентификатора применяются всегда
+	for i := 0; i < 2; i++ {
+		res := post(h.JSONUpdates, "")
+		res.Body.Close()
+	}
+	metric, err = storage.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), metric.Value)
+
+	res = post(h.JSONUpdates, strings.Repeat("x", maxBatchIDLength+1))
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestCumulativeCounters(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	storage := mem.NewStorage()
+	h := NewHandlers(storage, config.Config{}, log)
+
+	post := func(handler http.HandlerFunc, body, source, mode string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header.Set(sourceIDHeader, source)
+		if mode != "" {
+			r.Header.Set(counterModeHeader, mode)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		res := w.Result()
+		res.Body.Close()
+		return res.StatusCode
+	}
+	value := func(name string) int64 {
+		metric, err := storage.Metric(context.Background(), "", name)
+		require.NoError(t, err)
+		return metric.Value.(int64)
+	}
+
+	// первое значение источника задает точку отсчета, дальше прибавляется только приращение
+	assert.Equal(t, http.StatusOK, post(h.APIUpdate, `{"id":"requests","type":"counter","delta":10,"cumulative":true}`, "a", ""))
+	assert.Equal(t, http.StatusOK, post(h.JSONUpdate, `{"id":"requests","type":"counter","delta":15,"cumulative":true}`, "a", ""))
+	assert.Equal(t, int64(5), value("requests"))
+
+	// у другого источника своя точка отсчета
+	assert.Equal(t, http.StatusOK, post(h.APIUpdates, `[{"id":"requests","type":"counter","delta":100}]`, "b", counterModeCumulative))
+	assert.Equal(t, http.StatusOK, post(h.JSONUpdates, `[{"id":"requests","type":"counter","delta":102}]`, "b", counterModeCumulative))
+	assert.Equal(t, int64(7), value("requests"))
+
+	// сброс счетчика: новое значение меньше предыдущего и прибавляется целиком
+	assert.Equal(t, http.StatusOK, post(h.APIUpdates, `[{"id":"requests","type":"counter","delta":4,"cumulative":true}]`, "a", ""))
+	assert.Equal(t, int64(11), value("requests"))
+
+	// обычные приращения не затрагиваются
+	assert.Equal(t, http.StatusOK, post(h.APIUpdates, `[{"id":"requests","type":"counter","delta":1}]`, "a", counterModeDelta))
+	assert.Equal(t, int64(12), value("requests"))
+
+	assert.Equal(t, http.StatusBadRequest, post(h.APIUpdate, `{"id":"load","type":"gauge","value":1,"cumulative":true}`, "a", ""))
+	assert.Equal(t, http.StatusBadRequest, post(h.JSONUpdate, `{"id":"requests","type":"counter","delta":-1,"cumulative":true}`, "a", ""))
+	assert.Equal(t, http.StatusBadRequest, post(h.APIUpdates, `[{"id":"requests","type":"counter","delta":1}]`, "a", "total"))
+}
+
+func TestMetricsDashboard(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	storage := history.NewStorage(mem.NewStorage(), history.DefaultSize)
+	require.NoError(t, storage.SetMetric(ctx, "<script>", types.Metric{MetricType: types.Gauge, Value: float64(1.5)}))
+	require.NoError(t, storage.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(3)}))
+	require.NoError(t, storage.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(4)}))
+
+	h := NewHandlers(storage, config.Config{}, log)
+	r := chi.NewRouter()
+	r.Get("/", h.Metrics)
+	r.Get("/metric/{metricName}", h.MetricDetail)
+	r.Handle("/assets/*", h.Assets())
+	serv := httptest.NewServer(r)
+	defer serv.Close()
+
+	get := func(url string) (int, string) {
+		res, err := serv.Client().Get(serv.URL + url)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		return res.StatusCode, string(body)
+	}
+
+	t.Run("Names are escaped", func(t *testing.T) {
+		status, body := get("/")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "&lt;script&gt;")
+		assert.NotContains(t, body, "<script>")
+	})
+
+	t.Run("Filter and sort", func(t *testing.T) {
+		_, body := get("/?type=counter")
+		assert.Contains(t, body, ">requests</a>")
+		assert.NotContains(t, body, "&lt;script&gt;")
+
+		_, body = get("/?sort=value&order=desc")
+		assert.Less(t, strings.Index(body, ">requests</a>"), strings.Index(body, "&lt;script&gt;"))
+	})
+
+	t.Run("Auto-refresh", func(t *testing.T) {
+		_, body := get("/?refresh=5")
+		assert.Contains(t, body, `<meta http-equiv="refresh" content="5">`)
+
+		_, body = get("/?refresh=7")
+		assert.NotContains(t, body, `http-equiv="refresh"`)
+	})
+
+	t.Run("Detail page", func(t *testing.T) {
+		status, body := get("/metric/requests")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "<polyline")
+		assert.Contains(t, body, "min 3 &middot; max 7")
+
+		status, _ = get("/metric/unknown")
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("Assets", func(t *testing.T) {
+		status, body := get("/assets/style.css")
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "polyline")
+	})
+}
+
+func TestAPIStreamHandler(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	t.Run("Heartbeat", func(t *testing.T) {
+		interval := heartbeatInterval
+		heartbeatInterval = 10 * time.Millisecond
+		defer func() { heartbeatInterval = interval }()
+
+		h := NewHandlers(history.NewStorage(mem.NewStorage(), history.DefaultSize), config.Config{}, log)
+		serv := httptest.NewServer(http.HandlerFunc(h.APIStream))
+		defer serv.Close()
+
+		res, err := serv.Client().Get(serv.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		line, err := bufio.NewReader(res.Body).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, ": heartbeat\n", line)
+	})
+
+	t.Run("Stream ends on drain", func(t *testing.T) {
+		h := NewHandlers(history.NewStorage(mem.NewStorage(), history.DefaultSize), config.Config{}, log)
+		serv := httptest.NewServer(http.HandlerFunc(h.APIStream))
+		defer serv.Close()
+
+		res, err := serv.Client().Get(serv.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		h.Probe.Drain()
+
+		_, err = io.ReadAll(res.Body)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid Last-Event-ID", func(t *testing.T) {
+		h := NewHandlers(history.NewStorage(mem.NewStorage(), history.DefaultSize), config.Config{}, log)
+		serv := httptest.NewServer(http.HandlerFunc(h.APIStream))
+		defer serv.Close()
+
+		res, err := serv.Client().Get(serv.URL + "?last_event_id=wrong")
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("Storage without events", func(t *testing.T) {
+		h := NewHandlers(mem.NewStorage(), config.Config{}, log)
+		serv := httptest.NewServer(http.HandlerFunc(h.APIStream))
+		defer serv.Close()
+
+		res, err := serv.Client().Get(serv.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusNotImplemented, res.StatusCode)
+	})
+}
+
 func BenchmarkUpdateHandler(b *testing.B) {
 	h := NewHandlers(mem.NewStorage(), config.Config{}, logger.Logger{})
 	mux := http.NewServeMux()
@@ -361,7 +1429,7 @@ func Example() {
 	// The chi router is used to handle the routing
 	r := chi.NewRouter()
 
-	r.Use(compress.WithCompression(logger), logger.WithLogging)
+	r.Use(compress.WithCompression(logger, 0, config.CompressMinSize, config.CompressLevel), logger.WithLogging)
 
 	r.Mount("/debug", middleware.Profiler())
 
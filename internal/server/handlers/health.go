@@ -0,0 +1,25 @@
+// Healthz - GET /healthz - reports that the process is alive, it does not check any dependencies.
+// Readyz - GET /readyz - reports whether the server is ready to accept requests: the storage is
+// reachable, the backup file is writable and the server is not shutting down. It returns 200 if
+// the server is ready and 503 otherwise, the body lists the result of every check.
+package handlers
+
+import (
+	"net/http"
+)
+
+func (h *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	h.writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	result := h.Probe.Ready(r.Context())
+
+	status := http.StatusOK
+	if !result.Ready {
+		h.lg.Sugar.Infow("the server is not ready", "checks", result.Checks)
+		status = http.StatusServiceUnavailable
+	}
+
+	h.writeJSON(w, status, result)
+}
@@ -2,6 +2,9 @@
 // It reads the request body, decodes the JSON into a models.Metrics struct and checks that the metric type is valid.
 // If the metric type is invalid, it logs an error and returns a 400 status code.
 // It then checks that the metric name is not empty. If the name is empty, it logs an error and returns a 404 status code.
+// The body may have only the fields of models.Metrics, and the metric only the value field of its type: a delta
+// for a counter, a finite value for a gauge. Otherwise it returns a 400 status code naming the field.
+// Cumulative counters are replaced with their increase since the previous report of the source.
 // If all checks pass, it calls the SetMetric method of the repository to update the metric.
 // The function does not return any data in the response body.
 package handlers
@@ -10,7 +13,9 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/plasmatrip/metriq/internal/codec"
 	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/types"
 )
 
@@ -41,9 +46,10 @@ func (h *Handlers) JSONUpdate(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	if err := json.NewDecoder(r.Body).Decode(&jMetric); err != nil {
+	if err := codec.DecodeMetric(r.Body, &jMetric); err != nil {
+		telemetry.DecodeErrors.Inc("json")
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), bodyStatus(err))
 		return
 	}
 
@@ -61,6 +67,37 @@ func (h *Handlers) JSONUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name, err := h.config.Names.Apply(jMetric.ID)
+	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jMetric.ID = name
+
+	// проверяем поля значения метрики
+	if err := types.CheckFields(jMetric); err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// накопленное значение counter заменяем приращением
+	metrics := []models.Metrics{jMetric}
+	if err := markCumulative(r, metrics); err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if apiErr := checkCumulative(metrics[0]); apiErr != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", apiErr.Message)
+		http.Error(w, apiErr.Message, http.StatusBadRequest)
+		return
+	}
+	totals := h.toDeltas(r, metrics)
+	defer totals.Rollback()
+	jMetric = metrics[0]
+
 	var value any
 	switch jMetric.MType {
 	case types.Counter:
@@ -71,11 +108,12 @@ func (h *Handlers) JSONUpdate(w http.ResponseWriter, r *http.Request) {
 
 	if err := h.Repo.SetMetric(r.Context(), jMetric.ID, types.Metric{MetricType: jMetric.MType, Value: value}); err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), storeStatus(err, http.StatusBadRequest))
 		return
 	}
+	totals.Commit()
 
-	metric, err := h.Repo.Metric(r.Context(), jMetric.ID)
+	metric, err := h.Repo.Metric(r.Context(), jMetric.MType, jMetric.ID)
 	if err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", "metric not found")
 		http.Error(w, "metric not found", http.StatusNotFound)
@@ -6,16 +6,25 @@
 // metrics and calls the SetMetric method of the repository for each one, storing
 // the metric in the repository. The handler logs an error if the repository
 // returns an error. The handler returns a JSON response with the list of metrics
-// that were successfully written to the repository.
+// that were successfully written to the repository. A batch delivered again
+// with the same X-Batch-ID is acknowledged without writing it twice. The totals
+// of cumulative counters are written as their increases. A batch sent with the
+// application/x-protobuf content type is decoded from protobuf instead of JSON,
+// one sent with application/x-ndjson is streamed in chunks, see ndjson.go.
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
 
+	"github.com/plasmatrip/metriq/internal/codec"
 	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/types"
 )
 
@@ -25,8 +34,23 @@ var mPool = sync.Pool{
 	},
 }
 
+// putMetrics returns the slice to the pool. The metrics are cleared first, the decoders would
+// otherwise keep the pointer fields of the previous batch in the reused elements.
+func putMetrics(metrics *[]models.Metrics) {
+	clear(*metrics)
+	*metrics = (*metrics)[:0]
+	mPool.Put(metrics)
+}
+
 func (h *Handlers) JSONUpdates(w http.ResponseWriter, r *http.Request) {
+	c := codec.ForContentType(r.Header.Get("Content-Type"))
+	if c == codec.NDJSON {
+		h.jsonUpdatesStream(w, r)
+		return
+	}
+
 	jMetrics := mPool.Get().(*[]models.Metrics)
+	defer putMetrics(jMetrics)
 
 	// read body
 	// var body []byte
@@ -52,13 +76,29 @@ func (h *Handlers) JSONUpdates(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	if err := json.NewDecoder(r.Body).Decode(&jMetrics); err != nil {
+	if err := codec.Decode(c, r.Body, jMetrics); err != nil {
+		telemetry.DecodeErrors.Inc(codec.Name(c))
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), bodyStatus(err))
+		return
+	}
+
+	if h.config.MaxBatchSize > 0 && len(*jMetrics) > h.config.MaxBatchSize {
+		err := fmt.Errorf("the batch has %d metrics, at most %d are allowed", len(*jMetrics), h.config.MaxBatchSize)
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := markCumulative(r, *jMetrics); err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	for _, jMetric := range *jMetrics {
+	for i := range *jMetrics {
+		jMetric := &(*jMetrics)[i]
+
 		// проверяем тип метрики
 		if err := types.CheckMetricType(jMetric.MType); err != nil {
 			h.lg.Sugar.Infow("error in request handler", "error: ", err)
@@ -73,15 +113,49 @@ func (h *Handlers) JSONUpdates(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		name, err := h.config.Names.Apply(jMetric.ID)
+		if err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		jMetric.ID = name
+
+		if err := types.CheckFields(*jMetric); err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if apiErr := checkCumulative(*jMetric); apiErr != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", apiErr.Message)
+			http.Error(w, apiErr.Message, http.StatusBadRequest)
+			return
+		}
 	}
 
-	if err := h.Repo.SetMetrics(r.Context(), *jMetrics); err != nil {
+	_, err := h.applyBatch(w, r, func(ctx context.Context) error {
+		return h.setMetrics(ctx, r, *jMetrics)
+	})
+	if err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		status := storeStatus(err, http.StatusBadRequest)
+		switch {
+		case errors.Is(err, errInvalidBatchID):
+			status = http.StatusBadRequest
+		case errors.Is(err, dedup.ErrStore):
+			status = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	resp, err := json.Marshal(fmt.Sprintf("%d metrics received", len(*jMetrics)))
+	h.writeReceived(w, len(*jMetrics))
+}
+
+// writeReceived answers how many metrics of the batch were received, the answer is signed when there is a key.
+func (h *Handlers) writeReceived(w http.ResponseWriter, n int) {
+	resp, err := json.Marshal(fmt.Sprintf("%d metrics received", n))
 	if err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -13,7 +13,9 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/plasmatrip/metriq/internal/codec"
 	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/types"
 )
 
@@ -44,9 +46,10 @@ func (h *Handlers) JSONValue(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	if err := json.NewDecoder(r.Body).Decode(&jMetric); err != nil {
+	if err := codec.DecodeMetric(r.Body, &jMetric); err != nil {
+		telemetry.DecodeErrors.Inc("json")
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), bodyStatus(err))
 		return
 	}
 
@@ -64,7 +67,8 @@ func (h *Handlers) JSONValue(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metric, err := h.Repo.Metric(r.Context(), jMetric.ID)
+	jMetric.ID = h.config.Names.Normalize(jMetric.ID)
+	metric, err := h.Repo.Metric(r.Context(), jMetric.MType, jMetric.ID)
 	if err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
 		http.Error(w, "metric not found", http.StatusNotFound)
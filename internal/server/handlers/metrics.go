@@ -1,41 +1,310 @@
-// Metrics - GET /metrics - returns a list of all metrics and their current values as HTML page.
-// It does not take any parameters. It returns a list of all metrics and their current values as HTML page.
-// The list of metrics is retrieved from the repository and then written to the HTTP response.
-// If any error occur during the request, it returns an error with the appropriate HTTP status code.
+// Metrics - GET / - renders the dashboard: an HTML table with the name, type,
+// current value and the time of the last update of every metric.
+// The table is sorted by the sort (name, type, value, updated) and order (asc, desc)
+// query parameters and filtered by a substring of the name (filter) and by the
+// metric type (type). The refresh parameter turns on the auto-refresh of the page
+// with the given interval in seconds.
+// MetricDetail - GET /metric/{metricName} - renders the page of a single metric
+// with a chart of its recent values, the type parameter picks the metric of the type
+// when every type has its own names.
+// The templates and the stylesheet are embedded into the binary, the stylesheet
+// is served by Assets under /assets/.
 package handlers
 
 import (
+	"cmp"
+	"embed"
 	"fmt"
+	"html/template"
+	"io/fs"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/storage/history"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+//go:embed web
+var webFS embed.FS
+
+var templates = template.Must(template.ParseFS(webFS, "web/*.html"))
+
+// refreshOptions - допустимые интервалы автообновления страницы в секундах
+var refreshOptions = []int{0, 5, 10, 30, 60}
+
+const (
+	chartWidth  = 600
+	chartHeight = 150
 )
 
+// historian is implemented by repositories that keep the recent values of metrics.
+type historian interface {
+	History(mType, mName string) []history.Point
+}
+
+type column struct {
+	Title  string
+	Link   string
+	Active bool
+}
+
+type metricRow struct {
+	Name    string
+	Link    string
+	Type    string
+	Value   string
+	Updated time.Time
+
+	value float64
+}
+
+type historyRow struct {
+	Time  time.Time
+	Value string
+}
+
+type chart struct {
+	Width  int
+	Height int
+	Points string
+	Min    string
+	Max    string
+}
+
 func (h *Handlers) Metrics(w http.ResponseWriter, r *http.Request) {
 	metrics, err := h.Repo.Metrics(r.Context())
-
 	if err != nil {
 		h.lg.Sugar.Infow("error in request handler", "error: ", err)
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	html := fmt.Sprintf(`
-		<!DOCTYPE html>
-		<html lang="ru">
-		<head>
-			<meta charset="UTF-8">
-			<title>All metric params</title>
-		</head>
-		<body>
-			%v
-		</body>
-		</html>
-		`, metrics)
-
-	w.Header().Set("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write([]byte(html))
+	query := r.URL.Query()
+	filter := query.Get("filter")
+	mType := query.Get("type")
+	refresh := refreshInterval(query.Get("refresh"))
+
+	sortBy := query.Get("sort")
+	if !slices.Contains([]string{"name", "type", "value", "updated"}, sortBy) {
+		sortBy = "name"
+	}
+	desc := query.Get("order") == "desc"
+
+	rows := make([]metricRow, 0, len(metrics))
+	for key, metric := range metrics {
+		name := types.KeyName(h.config.TypeConflict, key, metric.MetricType)
+		if filter != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(filter)) {
+			continue
+		}
+		if mType != "" && metric.MetricType != mType {
+			continue
+		}
+
+		row := metricRow{
+			Name:  name,
+			Link:  "/metric/" + url.PathEscape(name) + "?" + url.Values{"type": {metric.MetricType}}.Encode(),
+			Type:  metric.MetricType,
+			Value: formatValue(metric),
+			value: numericValue(metric),
+		}
+		if points := h.history(metric.MetricType, name); len(points) > 0 {
+			row.Updated = points[len(points)-1].Time
+		}
+		rows = append(rows, row)
+	}
+
+	slices.SortFunc(rows, func(a, b metricRow) int {
+		var c int
+		switch sortBy {
+		case "type":
+			c = strings.Compare(a.Type, b.Type)
+		case "value":
+			c = cmp.Compare(a.value, b.value)
+		case "updated":
+			c = a.Updated.Compare(b.Updated)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Name, b.Name)
+		}
+		if c == 0 {
+			c = strings.Compare(a.Type, b.Type)
+		}
+		if desc {
+			return -c
+		}
+		return c
+	})
+
+	// ссылка в заголовке столбца сортирует по нему, повторное нажатие меняет направление
+	columns := make([]column, 0, 4)
+	for _, col := range []struct{ key, title string }{{"name", "Name"}, {"type", "Type"}, {"value", "Value"}, {"updated", "Last update"}} {
+		order := "asc"
+		if col.key == sortBy && !desc {
+			order = "desc"
+		}
+		link := url.Values{"sort": {col.key}, "order": {order}}
+		if filter != "" {
+			link.Set("filter", filter)
+		}
+		if mType != "" {
+			link.Set("type", mType)
+		}
+		if refresh > 0 {
+			link.Set("refresh", strconv.Itoa(refresh))
+		}
+		columns = append(columns, column{Title: col.title, Link: "/?" + link.Encode(), Active: col.key == sortBy})
+	}
+
+	order := "asc"
+	if desc {
+		order = "desc"
+	}
+
+	h.render(w, "dashboard.html", map[string]any{
+		"Rows":           rows,
+		"Columns":        columns,
+		"Sort":           sortBy,
+		"Order":          order,
+		"Desc":           desc,
+		"Filter":         filter,
+		"Type":           mType,
+		"Types":          []string{types.Counter, types.Gauge},
+		"Refresh":        refresh,
+		"RefreshOptions": refreshOptions,
+	})
+}
+
+func (h *Handlers) MetricDetail(w http.ResponseWriter, r *http.Request) {
+	mName := h.config.Names.Normalize(r.PathValue("metricName"))
+
+	metric, err := h.Repo.Metric(r.Context(), r.URL.Query().Get("type"), mName)
 	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, "metric not found", http.StatusNotFound)
+		return
+	}
+
+	points := h.history(metric.MetricType, mName)
+
+	data := map[string]any{
+		"Name":    mName,
+		"Type":    metric.MetricType,
+		"Value":   formatValue(metric),
+		"Refresh": refreshInterval(r.URL.Query().Get("refresh")),
+		"Updated": time.Time{},
+	}
+
+	if len(points) > 0 {
+		data["Updated"] = points[len(points)-1].Time
+		data["Chart"] = newChart(points)
+
+		// последние значения показываем первыми
+		rows := make([]historyRow, 0, len(points))
+		for i := len(points) - 1; i >= 0; i-- {
+			rows = append(rows, historyRow{Time: points[i].Time, Value: strconv.FormatFloat(points[i].Value, 'f', -1, 64)})
+		}
+		data["History"] = rows
+	}
+
+	h.render(w, "metric.html", data)
+}
+
+// Assets serves the embedded stylesheet of the dashboard.
+func (h *Handlers) Assets() http.Handler {
+	sub, err := fs.Sub(webFS, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/assets/", http.FileServer(http.FS(sub)))
+}
+
+func (h *Handlers) render(w http.ResponseWriter, name string, data any) {
+	var buf strings.Builder
+	if err := templates.ExecuteTemplate(&buf, name, data); err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write([]byte(buf.String())); err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+	}
+}
+
+// history returns the recent values of the metric if the repository keeps them.
+func (h *Handlers) history(mType, mName string) []history.Point {
+	if hist, ok := h.Repo.(historian); ok {
+		return hist.History(mType, mName)
+	}
+	return nil
+}
+
+func refreshInterval(s string) int {
+	refresh, err := strconv.Atoi(s)
+	if err != nil || !slices.Contains(refreshOptions, refresh) {
+		return 0
+	}
+	return refresh
+}
+
+func formatValue(metric types.Metric) string {
+	switch value := metric.Value.(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case int64:
+		return strconv.FormatInt(value, 10)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func numericValue(metric types.Metric) float64 {
+	switch value := metric.Value.(type) {
+	case float64:
+		return value
+	case int64:
+		return float64(value)
+	default:
+		return 0
+	}
+}
+
+// newChart scales the values to the chart area and builds the points of an SVG polyline.
+func newChart(points []history.Point) *chart {
+	lo, hi := points[0].Value, points[0].Value
+	for _, p := range points {
+		lo = min(lo, p.Value)
+		hi = max(hi, p.Value)
+	}
+
+	const pad = 5
+	var sb strings.Builder
+	for i, p := range points {
+		x := float64(pad)
+		if len(points) > 1 {
+			x += float64(i) * float64(chartWidth-2*pad) / float64(len(points)-1)
+		}
+		y := float64(chartHeight) / 2
+		if hi > lo {
+			y = float64(chartHeight-pad) - (p.Value-lo)*float64(chartHeight-2*pad)/(hi-lo)
+		}
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		fmt.Fprintf(&sb, "%.1f,%.1f", x, y)
+	}
+
+	return &chart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Points: sb.String(),
+		Min:    strconv.FormatFloat(lo, 'f', -1, 64),
+		Max:    strconv.FormatFloat(hi, 'f', -1, 64),
+	}
 }
@@ -0,0 +1,267 @@
+// A batch sent to /updates or /api/v1/updates with the application/x-ndjson
+// content type is a metric per line and may be of any size: it is not decoded
+// as a whole, the metrics are read as they arrive and applied in chunks of at
+// most streamChunkSize, so the memory the server needs does not depend on the
+// size of the batch and MaxBatchSize does not limit it, only the body limits
+// do. The chunks applied before an invalid line or a storage failure stay
+// applied, the response tells how many metrics were accepted. A batch with an
+// ID is not applied in chunks: its metrics are kept until the end of the body
+// and applied at once together with recording the ID, so a retry of a batch
+// that failed midway does not apply its first chunks twice. Nothing of such a
+// batch is applied and its ID is not recorded if /updates stops at an invalid
+// line, so the corrected batch can be sent again with the same ID. At most
+// maxBufferedStream metrics of a batch with an ID are kept, a longer batch is
+// answered with 413 and has to be split or sent without an ID.
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/codec"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	// streamChunkSize - количество метрик NDJSON, применяемых одним вызовом хранилища
+	streamChunkSize = 1000
+	// maxStreamRejections - количество отклоненных метрик NDJSON, перечисляемых в ответе
+	maxStreamRejections = 100
+)
+
+// maxBufferedStream - количество метрик NDJSON пакета с идентификатором, хранимых до конца потока
+var maxBufferedStream = 100 * streamChunkSize
+
+var (
+	// errStreamStorage wraps the errors of the storage while applying a stream
+	errStreamStorage = errors.New("failed to apply the metrics")
+	// errStreamTooLarge is returned for a batch with an ID of more than maxBufferedStream metrics
+	errStreamTooLarge = errors.New("the batch with an ID has too many metrics")
+)
+
+// streamResult is the outcome of a stream: the number of applied metrics and whether the batch was a duplicate.
+type streamResult struct {
+	accepted  int
+	duplicate bool
+}
+
+// streamBatch reads the NDJSON body and applies its valid metrics in chunks. reject is called for every
+// invalid line or metric with its position in the stream and decides whether the stream goes on.
+// Errors of the body, the batch ID and the storage stop the stream and are returned, a batch with an ID
+// is then not applied at all.
+func (h *Handlers) streamBatch(w http.ResponseWriter, r *http.Request, reject func(index int, id string, apiErr models.APIError) bool) (streamResult, error) {
+	var result streamResult
+
+	// режим счетчиков проверяется до чтения тела, метрики помечаются по одной
+	if err := markCumulative(r, nil); err != nil {
+		return result, err
+	}
+	cumulative := r.Header.Get(counterModeHeader) == counterModeCumulative
+
+	id, err := batchID(r)
+	if err != nil {
+		return result, err
+	}
+	// пакет с идентификатором применяется целиком в конце потока вместе с записью идентификатора
+	buffered := id != "" && h.Batches != nil
+	var batch []models.Metrics
+
+	chunkSize := streamChunkSize
+	if h.config.MaxBatchSize > 0 && h.config.MaxBatchSize < chunkSize {
+		chunkSize = h.config.MaxBatchSize
+	}
+
+	chunk := mPool.Get().(*[]models.Metrics)
+	defer putMetrics(chunk)
+
+	flush := func() error {
+		if len(*chunk) == 0 {
+			return nil
+		}
+		defer func() { *chunk = (*chunk)[:0] }()
+
+		if buffered {
+			if len(batch)+len(*chunk) > maxBufferedStream {
+				return fmt.Errorf("%w, at most %d are allowed", errStreamTooLarge, maxBufferedStream)
+			}
+			batch = append(batch, *chunk...)
+			return nil
+		}
+
+		if err := h.setMetrics(r.Context(), r, *chunk); err != nil {
+			return fmt.Errorf("%w: %w", errStreamStorage, err)
+		}
+		result.accepted += len(*chunk)
+		return nil
+	}
+
+	// finish applies the rest of the stream, the whole batch if it has an ID
+	finish := func() error {
+		if err := flush(); err != nil || len(batch) == 0 {
+			return err
+		}
+
+		applied, err := h.applyBatch(w, r, func(ctx context.Context) error {
+			return h.setMetrics(ctx, r, batch)
+		})
+		if errors.Is(err, dedup.ErrStore) {
+			return err
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %w", errStreamStorage, err)
+		}
+		result.accepted = len(batch)
+		result.duplicate = !applied
+		return nil
+	}
+
+	// stop ends the stream at a rejected metric: the chunks before it are applied, a batch with an ID is
+	// not applied at all and its ID is not recorded
+	stop := func() error {
+		if buffered {
+			return nil
+		}
+		return finish()
+	}
+
+	s := codec.NewStream(r.Body)
+	for {
+		var m models.Metrics
+		err := s.Next(&m)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var lineErr *codec.LineError
+		if errors.As(err, &lineErr) {
+			telemetry.DecodeErrors.Inc(codec.Name(codec.NDJSON))
+			apiErr := fieldError(errCodeInvalidJSON, lineErr)
+			apiErr.Message = lineErr.Error()
+			if !reject(s.Index(), "", *apiErr) {
+				return result, stop()
+			}
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		if cumulative && m.MType == types.Counter {
+			m.Cumulative = true
+		}
+		if apiErr := h.validateMetric(&m); apiErr != nil {
+			if !reject(s.Index(), m.ID, *apiErr) {
+				return result, stop()
+			}
+			continue
+		}
+
+		*chunk = append(*chunk, m)
+		if len(*chunk) == chunkSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, finish()
+}
+
+// jsonUpdatesStream applies an NDJSON batch sent to /updates. Like the JSON array, the batch stops at the
+// first invalid metric, but the chunks before it are already applied.
+func (h *Handlers) jsonUpdatesStream(w http.ResponseWriter, r *http.Request) {
+	var rejected *models.APIError
+	var rejectedIndex int
+	var rejectedID string
+
+	result, err := h.streamBatch(w, r, func(index int, id string, apiErr models.APIError) bool {
+		rejected, rejectedIndex, rejectedID = &apiErr, index, id
+		return false
+	})
+	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err, "applied", result.accepted)
+		// ошибки хранилища отвечают 400, как и для массива JSON
+		status := storeStatus(err, bodyStatus(err))
+		if errors.Is(err, dedup.ErrStore) {
+			status = http.StatusInternalServerError
+		}
+		if errors.Is(err, errStreamTooLarge) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, streamError(err, result), status)
+		return
+	}
+
+	if rejected != nil {
+		msg := fmt.Sprintf("metric %d: %s, %d metrics applied", rejectedIndex, rejected.Message, result.accepted)
+		h.lg.Sugar.Infow("error in request handler", "error: ", msg)
+		// как и для массива JSON, метрика без имени не найдена, имя не по правилам - ошибка запроса
+		status := http.StatusBadRequest
+		if rejected.Code == errCodeInvalidName && rejectedID == "" {
+			status = http.StatusNotFound
+		}
+		http.Error(w, msg, status)
+		return
+	}
+
+	h.writeReceived(w, result.accepted)
+}
+
+// apiUpdatesStream applies an NDJSON batch sent to /api/v1/updates. The invalid lines and metrics are rejected
+// one by one, the first maxStreamRejections of them are listed in the response.
+func (h *Handlers) apiUpdatesStream(w http.ResponseWriter, r *http.Request) {
+	batch := models.BatchResult{Rejected: []models.RejectedMetric{}}
+
+	result, err := h.streamBatch(w, r, func(index int, id string, apiErr models.APIError) bool {
+		batch.RejectedCount++
+		if len(batch.Rejected) < maxStreamRejections {
+			batch.Rejected = append(batch.Rejected, models.RejectedMetric{Index: index, ID: id, Error: apiErr})
+		}
+		return true
+	})
+
+	var maxErr *http.MaxBytesError
+	switch {
+	case err == nil:
+	case errors.Is(err, errInvalidBatchID):
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: batchIDHeader})
+		return
+	case errors.Is(err, errInvalidCounterMode):
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidValue, Message: err.Error(), Field: counterModeHeader})
+		return
+	case errors.Is(err, telemetry.ErrReservedName):
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidName, Message: streamError(err, result), Field: "id"})
+		return
+	case errors.Is(err, types.ErrTypeConflict):
+		h.writeError(w, http.StatusConflict, models.APIError{Code: errCodeTypeConflict, Message: streamError(err, result), Field: "type"})
+		return
+	case errors.As(err, &maxErr), errors.Is(err, errStreamTooLarge):
+		h.writeError(w, http.StatusRequestEntityTooLarge, models.APIError{Code: errCodeTooLarge, Message: streamError(err, result)})
+		return
+	case errors.Is(err, errStreamStorage), errors.Is(err, dedup.ErrStore):
+		h.writeError(w, http.StatusInternalServerError, models.APIError{Code: errCodeInternalError, Message: streamError(err, result)})
+		return
+	default:
+		h.writeError(w, http.StatusBadRequest, models.APIError{Code: errCodeInvalidJSON, Message: streamError(err, result)})
+		return
+	}
+
+	if batch.RejectedCount > 0 {
+		h.lg.Sugar.Infow("metrics rejected in stream", "rejected", batch.RejectedCount, "accepted", result.accepted)
+	}
+
+	batch.Accepted = result.accepted
+	batch.Duplicate = result.duplicate
+	h.writeJSON(w, http.StatusOK, batch)
+}
+
+// streamError tells how many metrics were applied before the stream failed.
+func streamError(err error, result streamResult) string {
+	return fmt.Sprintf("%v, %d metrics applied", err, result.accepted)
+}
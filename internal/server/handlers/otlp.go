@@ -0,0 +1,124 @@
+// The OTLPMetrics function is a request handler for the OTLP/HTTP metrics endpoint (/v1/metrics).
+// It accepts an ExportMetricsServiceRequest encoded either as protobuf (application/x-protobuf)
+// or as JSON (application/json), converts the data points to metriq metrics and stores them
+// in the repository as one batch. The response is an ExportMetricsServiceResponse in the
+// same encoding as the request. Data points that cannot be represented or whose names break
+// the name policy are reported back through the partial success field instead of failing
+// the whole request.
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+)
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+func (h *Handlers) OTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (contentType != contentTypeProtobuf && contentType != contentTypeJSON) {
+		h.lg.Sugar.Infow("error in request handler", "error: ", "unsupported content type", "content type", r.Header.Get("Content-Type"))
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), bodyStatus(err))
+		return
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{}
+	format := "protobuf"
+	switch contentType {
+	case contentTypeProtobuf:
+		err = proto.Unmarshal(body, req)
+	case contentTypeJSON:
+		format = "json"
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, req)
+	}
+	if err != nil {
+		telemetry.DecodeErrors.Inc(format)
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// итоги накопленных сумм сохраняются, только если метрики записаны
+	totals := h.counters.Begin(source(r))
+	defer totals.Rollback()
+	metrics, rejected, message := h.otlp.Convert(req, totals)
+
+	metrics, dropped, err := h.config.Names.Filter(metrics)
+	if dropped > 0 {
+		rejected += int64(dropped)
+		message = strings.TrimPrefix(message+"; "+err.Error(), "; ")
+	}
+
+	if h.config.MaxBatchSize > 0 && len(metrics) > h.config.MaxBatchSize {
+		err := fmt.Errorf("the request has %d data points, at most %d are allowed", len(metrics), h.config.MaxBatchSize)
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if len(metrics) > 0 {
+		if err := h.Repo.SetMetrics(r.Context(), metrics); err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), storeStatus(err, http.StatusInternalServerError))
+			return
+		}
+	}
+	totals.Commit()
+
+	result := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 {
+		h.lg.Sugar.Infow("otlp data points rejected", "rejected", rejected, "reason", message)
+		result.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       message,
+		}
+	}
+
+	var resp []byte
+	switch contentType {
+	case contentTypeProtobuf:
+		resp, err = proto.Marshal(result)
+	case contentTypeJSON:
+		resp, err = protojson.Marshal(result)
+	}
+	if err != nil {
+		h.lg.Sugar.Infow("error in request handler", "error: ", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// если есть ключ, хэшируем ответ
+	if len(h.config.Key) > 0 {
+		hash, err := h.Sum(resp)
+		if err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("HashSHA256", hash)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
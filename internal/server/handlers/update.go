@@ -33,6 +33,12 @@ func (h *Handlers) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	mName, err := h.config.Names.Apply(mName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	//проверяем значение метрики
 	metricValue := r.PathValue("metricValue")
 	value, err := types.CheckValue(mType, metricValue)
@@ -42,7 +48,7 @@ func (h *Handlers) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = h.Repo.SetMetric(r.Context(), mName, types.Metric{MetricType: mType, Value: value}); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), storeStatus(err, http.StatusBadRequest))
 		return
 	}
 
@@ -27,7 +27,7 @@ func (h *Handlers) Value(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metric, err := h.Repo.Metric(r.Context(), mName)
+	metric, err := h.Repo.Metric(r.Context(), mType, h.config.Names.Normalize(mName))
 	if err != nil {
 		h.lg.Sugar.Infoln("Metric not found")
 		http.Error(w, "Metric not found", http.StatusNotFound)
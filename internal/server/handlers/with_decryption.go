@@ -4,24 +4,38 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"io"
 	"net/http"
+
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 )
 
+// errEncryptedTooLong - тело длиннее блока ключа не может быть расшифровано
+var errEncryptedTooLong = errors.New("the encrypted body is longer than the key size")
+
 func (h Handlers) WithDecryption(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Чтение зашифрованных данных из тела запроса
-		encryptedData, err := io.ReadAll(r.Body)
+		// Чтение зашифрованных данных из тела запроса, агент шифрует тело одним блоком ключа,
+		// поэтому читается не больше блока и байта сверх него, чтобы отличить более длинное тело
+		encryptedData, err := io.ReadAll(io.LimitReader(r.Body, int64(h.config.CryptoKey.Size())+1))
 		if err != nil {
 			h.lg.Sugar.Infow("error in request handler", "error: ", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), bodyStatus(err))
 			return
 		}
 		defer r.Body.Close()
 
+		if len(encryptedData) > h.config.CryptoKey.Size() {
+			h.lg.Sugar.Infow("error in request handler", "error: ", errEncryptedTooLong)
+			http.Error(w, errEncryptedTooLong.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
 		// Расшифровка данных
 		decryptedData, err := rsa.DecryptPKCS1v15(rand.Reader, h.config.CryptoKey, encryptedData)
 		if err != nil {
+			telemetry.DecodeErrors.Inc("decrypt")
 			h.lg.Sugar.Infow("error encryption data", "error: ", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -4,7 +4,15 @@
 // is allowed to proceed to the next handler in the chain. If the values do not
 // match, the function returns an error response with a status code of 400.
 // The function is used to prevent tampering with the request body and ensure
-// that the data is not modified during transport.
+// that the data is not modified during transport. The body is hashed while it
+// is read and kept for the handler in memory up to maxSpoolMemory bytes and in
+// a temporary file beyond that, so a large NDJSON batch is verified before any
+// of its metrics is applied without holding the whole batch in memory. A signed
+// NDJSON batch is thus applied only once it is received whole, not as it
+// arrives. Without MaxBodySize the spooled body is limited to maxSpoolSize, a
+// longer one is answered with 413. A batch with an ID is applied at once
+// whether it is signed or not, see ndjson.go, so a retry of a signed batch that
+// failed midway does not apply its first chunks twice.
 package handlers
 
 import (
@@ -12,10 +20,67 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"io"
 	"net/http"
+	"os"
 )
 
+// maxSpoolMemory - размер тела запроса, до которого оно хранится в памяти
+const maxSpoolMemory = 1 << 20
+
+// maxSpoolSize - размер подписанного тела, хранимого для проверки, если размер тела не ограничен
+var maxSpoolSize int64 = 1 << 30
+
+// spool keeps the body read for the hash: in memory while it is short, in a temporary file once it grows.
+type spool struct {
+	buf  bytes.Buffer
+	file *os.File
+}
+
+func (s *spool) Write(data []byte) (int, error) {
+	if s.file != nil {
+		return s.file.Write(data)
+	}
+	if s.buf.Len()+len(data) <= maxSpoolMemory {
+		return s.buf.Write(data)
+	}
+
+	f, err := os.CreateTemp("", "metriq-body-*")
+	if err != nil {
+		return 0, err
+	}
+	s.file = f
+	if _, err := s.buf.WriteTo(f); err != nil {
+		return 0, err
+	}
+	return f.Write(data)
+}
+
+// reader returns the spooled body from the beginning, closing it removes the temporary file.
+func (s *spool) reader() (io.ReadCloser, error) {
+	if s.file == nil {
+		return io.NopCloser(&s.buf), nil
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *spool) Read(data []byte) (int, error) {
+	return s.file.Read(data)
+}
+
+func (s *spool) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	return errors.Join(err, os.Remove(s.file.Name()))
+}
+
 func (h Handlers) WithHashing(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		reqHash := r.Header.Get("HashSHA256")
@@ -24,26 +89,36 @@ func (h Handlers) WithHashing(next http.Handler) http.Handler {
 			return
 		}
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			h.lg.Sugar.Infow("error in request handler", "error: ", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
+		// без ограничения тела временный файл ограничивается отдельно
+		if h.config.MaxBodySize <= 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxSpoolSize)
 		}
 
-		r.Body = io.NopCloser(bytes.NewReader(body))
-
 		hm := hmac.New(sha256.New, []byte(h.config.Key))
-		hm.Write(body)
-		dst := hm.Sum(nil)
-		sumHash := base64.StdEncoding.EncodeToString(dst)
+		s := new(spool)
+		defer s.Close()
 
+		if _, err := io.Copy(io.MultiWriter(hm, s), r.Body); err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), bodyStatus(err))
+			return
+		}
+
+		sumHash := base64.StdEncoding.EncodeToString(hm.Sum(nil))
 		if !hmac.Equal([]byte(reqHash), []byte(sumHash)) {
 			h.lg.Sugar.Infow("error in request handler", "req: ", reqHash, "sum: ", sumHash)
 			http.Error(w, "hashes are not equal", http.StatusBadRequest)
 			return
 		}
 
+		body, err := s.reader()
+		if err != nil {
+			h.lg.Sugar.Infow("error in request handler", "error: ", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.Body = body
+
 		next.ServeHTTP(w, r)
 	})
 }
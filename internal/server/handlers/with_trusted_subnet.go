@@ -0,0 +1,26 @@
+// This middleware function restricts metric writes to the trusted subnet of the agents.
+// The agent reports the address of its outbound interface in the "X-Real-IP" header,
+// the middleware parses it and checks that it belongs to the subnet configured with
+// the trusted_subnet option. Requests without the header, with an address that cannot
+// be parsed or with an address outside the subnet are rejected with a status code of 403.
+package handlers
+
+import (
+	"net"
+	"net/http"
+)
+
+func (h Handlers) WithTrustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		realIP := r.Header.Get("X-Real-IP")
+
+		ip := net.ParseIP(realIP)
+		if ip == nil || !h.config.TrustedNet.Contains(ip) {
+			h.lg.Sugar.Infow("request from untrusted address", "X-Real-IP", realIP, "remote", r.RemoteAddr)
+			http.Error(w, "the address is not in the trusted subnet", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,72 @@
+// Package health implements the readiness probe of the server. The server is
+// ready when every registered check passes and it is not draining. Draining
+// starts on shutdown: from then on the server reports not ready, so that load
+// balancers stop routing new requests to it while in-flight requests complete,
+// and long-lived responses such as event streams are told to finish.
+package health
+
+import (
+	"context"
+	"sync"
+)
+
+// Check is a named dependency check of the readiness probe.
+type Check struct {
+	Name string
+	Fn   func(ctx context.Context) error
+}
+
+// Result is the outcome of the readiness probe, Checks maps the check names to
+// "ok" or the error message.
+type Result struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+type Probe struct {
+	checks []Check
+
+	once     sync.Once
+	draining chan struct{}
+}
+
+func NewProbe(checks ...Check) *Probe {
+	return &Probe{
+		checks:   checks,
+		draining: make(chan struct{}),
+	}
+}
+
+// Drain marks the server as shutting down, it is safe to call more than once.
+func (p *Probe) Drain() {
+	p.once.Do(func() { close(p.draining) })
+}
+
+// Draining returns a channel that is closed when the server starts draining.
+func (p *Probe) Draining() <-chan struct{} {
+	return p.draining
+}
+
+// Ready runs the checks and reports whether the server can accept requests.
+func (p *Probe) Ready(ctx context.Context) Result {
+	result := Result{Ready: true, Checks: make(map[string]string, len(p.checks)+1)}
+
+	select {
+	case <-p.draining:
+		result.Ready = false
+		result.Checks["draining"] = "the server is shutting down"
+	default:
+		result.Checks["draining"] = "ok"
+	}
+
+	for _, check := range p.checks {
+		if err := check.Fn(ctx); err != nil {
+			result.Ready = false
+			result.Checks[check.Name] = err.Error()
+			continue
+		}
+		result.Checks[check.Name] = "ok"
+	}
+
+	return result
+}
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbe_Ready(t *testing.T) {
+	var storageErr error
+	p := NewProbe(
+		Check{Name: "storage", Fn: func(context.Context) error { return storageErr }},
+		Check{Name: "backup", Fn: func(context.Context) error { return nil }},
+	)
+	ctx := context.Background()
+
+	assert.Equal(t, Result{Ready: true, Checks: map[string]string{"storage": "ok", "backup": "ok", "draining": "ok"}}, p.Ready(ctx))
+
+	storageErr = errors.New("connection refused")
+	result := p.Ready(ctx)
+	assert.False(t, result.Ready)
+	assert.Equal(t, "connection refused", result.Checks["storage"])
+
+	storageErr = nil
+	p.Drain()
+	p.Drain()
+	result = p.Ready(ctx)
+	assert.False(t, result.Ready)
+	assert.Equal(t, "the server is shutting down", result.Checks["draining"])
+
+	select {
+	case <-p.Draining():
+	default:
+		t.Error("the draining channel is not closed")
+	}
+}
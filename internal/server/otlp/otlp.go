@@ -0,0 +1,257 @@
+// Package otlp converts OpenTelemetry metrics received over OTLP/HTTP into
+// metriq metrics. Monotonic sums become counters, cumulative sums are turned
+// into deltas against the previously received value by the cumulative tracker
+// shared with the HTTP handlers, the fractions of the delta sums are carried
+// over to the next data point of the series instead of being rounded away,
+// non-monotonic sums and
+// gauges become gauges, and histograms are stored as a set of gauges with the
+// .count, .sum, .min and .max suffixes.
+//
+// Resource and data point attributes are folded into the metric name: either
+// as a dotted prefix/suffix built from the attribute values, or as a
+// Prometheus-like label set, e.g. http_requests{service.name="api"}.
+package otlp
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/cumulative"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+type Converter struct {
+	resourceAttrs []string
+	labels        bool
+}
+
+// NewConverter creates a converter. resourceAttrs is a comma separated list of
+// resource attribute keys that are added to metric names, labels selects the
+// label set naming instead of the dotted one.
+func NewConverter(resourceAttrs string, labels bool) *Converter {
+	c := &Converter{labels: labels}
+
+	for _, key := range strings.Split(resourceAttrs, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			c.resourceAttrs = append(c.resourceAttrs, key)
+		}
+	}
+
+	return c
+}
+
+// attr is a single attribute rendered as a string.
+type attr struct {
+	key   string
+	value string
+}
+
+// Convert maps the data points of an export request onto metriq metrics. Data
+// points that cannot be represented are counted as rejected, the returned
+// message explains why. The totals of the cumulative sums are recorded in the
+// batch, it has to be committed once the metrics are stored.
+func (c *Converter) Convert(req *colmetricspb.ExportMetricsServiceRequest, totals *cumulative.Batch) ([]models.Metrics, int64, string) {
+	var metrics []models.Metrics
+	var rejected int64
+	var reasons []string
+
+	reject := func(n int, reason string) {
+		rejected += int64(n)
+		reasons = append(reasons, reason)
+	}
+
+	for _, rm := range req.GetResourceMetrics() {
+		resource := c.resourceAttributes(rm.GetResource().GetAttributes())
+
+		for _, sm := range rm.GetScopeMetrics() {
+			for _, m := range sm.GetMetrics() {
+				if m.GetName() == "" {
+					reject(dataPoints(m), "metric without a name")
+					continue
+				}
+
+				switch data := m.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						value := numberValue(dp)
+						metrics = append(metrics, gauge(c.name(m.GetName(), resource, dp.GetAttributes()), value))
+					}
+				case *metricspb.Metric_Sum:
+					for _, dp := range data.Sum.GetDataPoints() {
+						id := c.name(m.GetName(), resource, dp.GetAttributes())
+						metric, err := c.sum(id, data.Sum, dp, totals)
+						if err != nil {
+							reject(1, err.Error())
+							continue
+						}
+						metrics = append(metrics, metric)
+					}
+				case *metricspb.Metric_Histogram:
+					for _, dp := range data.Histogram.GetDataPoints() {
+						id := c.name(m.GetName(), resource, dp.GetAttributes())
+						metrics = append(metrics, histogram(id, dp)...)
+					}
+				default:
+					reject(dataPoints(m), fmt.Sprintf("unsupported data type of metric %s", m.GetName()))
+				}
+			}
+		}
+	}
+
+	// NaN и бесконечность не представимы в JSON
+	kept := metrics[:0]
+	for _, m := range metrics {
+		if err := types.CheckFields(m); err != nil {
+			reject(1, fmt.Sprintf("%s %s", m.ID, err))
+			continue
+		}
+		kept = append(kept, m)
+	}
+
+	return kept, rejected, strings.Join(reasons, "; ")
+}
+
+// sum converts a data point of a Sum metric.
+func (c *Converter) sum(id string, sum *metricspb.Sum, dp *metricspb.NumberDataPoint, totals *cumulative.Batch) (models.Metrics, error) {
+	value := numberValue(dp)
+
+	if !sum.GetIsMonotonic() {
+		return gauge(id, value), nil
+	}
+
+	if value < 0 {
+		return models.Metrics{}, fmt.Errorf("negative value of monotonic sum %s", id)
+	}
+
+	switch sum.GetAggregationTemporality() {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		// дробные приращения накапливаются, а не теряются при округлении каждого
+		delta := totals.Add(id, value)
+		return models.Metrics{ID: id, MType: types.Counter, Delta: &delta}, nil
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		// первая точка ряда, начавшегося при работающем сервере, учитывается целиком
+		delta := totals.Delta(id, dp.GetStartTimeUnixNano(), value)
+		return models.Metrics{ID: id, MType: types.Counter, Delta: &delta}, nil
+	}
+
+	return models.Metrics{}, fmt.Errorf("unspecified aggregation temporality of sum %s", id)
+}
+
+// name builds the metric ID from the metric name and the attributes.
+func (c *Converter) name(name string, resource []attr, dpAttrs []*commonpb.KeyValue) string {
+	point := attributes(dpAttrs)
+	sort.Slice(point, func(i, j int) bool { return point[i].key < point[j].key })
+
+	if c.labels {
+		all := append(append([]attr{}, resource...), point...)
+		if len(all) == 0 {
+			return name
+		}
+		pairs := make([]string, 0, len(all))
+		for _, a := range all {
+			pairs = append(pairs, a.key+"="+strconv.Quote(a.value))
+		}
+		return name + "{" + strings.Join(pairs, ",") + "}"
+	}
+
+	parts := make([]string, 0, len(resource)+len(point)+1)
+	for _, a := range resource {
+		parts = append(parts, a.value)
+	}
+	parts = append(parts, name)
+	for _, a := range point {
+		parts = append(parts, a.value)
+	}
+	return strings.Join(parts, ".")
+}
+
+// resourceAttributes picks the configured resource attributes in the configured order.
+func (c *Converter) resourceAttributes(kvs []*commonpb.KeyValue) []attr {
+	all := attributes(kvs)
+
+	var picked []attr
+	for _, key := range c.resourceAttrs {
+		for _, a := range all {
+			if a.key == key {
+				picked = append(picked, a)
+				break
+			}
+		}
+	}
+	return picked
+}
+
+func attributes(kvs []*commonpb.KeyValue) []attr {
+	attrs := make([]attr, 0, len(kvs))
+	for _, kv := range kvs {
+		value, ok := anyValue(kv.GetValue())
+		if !ok || value == "" {
+			continue
+		}
+		attrs = append(attrs, attr{key: kv.GetKey(), value: value})
+	}
+	return attrs
+}
+
+// anyValue renders scalar attribute values, arrays, maps and bytes are skipped.
+func anyValue(v *commonpb.AnyValue) (string, bool) {
+	switch value := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return value.StringValue, true
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(value.BoolValue), true
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(value.IntValue, 10), true
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(value.DoubleValue, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func numberValue(dp *metricspb.NumberDataPoint) float64 {
+	if v, ok := dp.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+	return dp.GetAsDouble()
+}
+
+func histogram(id string, dp *metricspb.HistogramDataPoint) []models.Metrics {
+	metrics := []models.Metrics{gauge(id+".count", float64(dp.GetCount()))}
+	if dp.Sum != nil {
+		metrics = append(metrics, gauge(id+".sum", dp.GetSum()))
+	}
+	if dp.Min != nil {
+		metrics = append(metrics, gauge(id+".min", dp.GetMin()))
+	}
+	if dp.Max != nil {
+		metrics = append(metrics, gauge(id+".max", dp.GetMax()))
+	}
+	return metrics
+}
+
+func dataPoints(m *metricspb.Metric) int {
+	switch data := m.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		return len(data.Gauge.GetDataPoints())
+	case *metricspb.Metric_Sum:
+		return len(data.Sum.GetDataPoints())
+	case *metricspb.Metric_Histogram:
+		return len(data.Histogram.GetDataPoints())
+	case *metricspb.Metric_ExponentialHistogram:
+		return len(data.ExponentialHistogram.GetDataPoints())
+	case *metricspb.Metric_Summary:
+		return len(data.Summary.GetDataPoints())
+	}
+	return 0
+}
+
+func gauge(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: types.Gauge, Value: &value}
+}
@@ -0,0 +1,191 @@
+package otlp
+
+import (
+	"testing"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/cumulative"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTracker() *cumulative.Tracker {
+	return cumulative.NewTracker(cumulative.DefaultSize, cumulative.DefaultTTL)
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func request(resource []*commonpb.KeyValue, metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource:     &resourcepb.Resource{Attributes: resource},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func sum(name string, temporality metricspb.AggregationTemporality, monotonic bool, start uint64, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            monotonic,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				StartTimeUnixNano: start,
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+func values(metrics []models.Metrics) map[string]any {
+	got := make(map[string]any, len(metrics))
+	for _, m := range metrics {
+		switch m.MType {
+		case types.Counter:
+			got[m.ID] = *m.Delta
+		case types.Gauge:
+			got[m.ID] = *m.Value
+		}
+	}
+	return got
+}
+
+func TestConverter_Convert(t *testing.T) {
+	c := NewConverter("service.name", false)
+
+	lo, hi, total := 1.0, 9.0, 12.0
+	req := request(
+		[]*commonpb.KeyValue{stringAttr("service.name", "api"), stringAttr("host.name", "web1")},
+		sum("requests", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, true, 0, 5),
+		sum("inflight", metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, false, 0, 3),
+		&metricspb.Metric{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{stringAttr("room", "kitchen")},
+				Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+			}}}},
+		},
+		&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{DataPoints: []*metricspb.HistogramDataPoint{{
+				Count: 3, Sum: &total, Min: &lo, Max: &hi,
+			}}}},
+		},
+		&metricspb.Metric{
+			Name: "sizes",
+			Data: &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: []*metricspb.SummaryDataPoint{{}}}},
+		},
+	)
+
+	metrics, rejected, message := c.Convert(req, newTracker().Begin(""))
+	assert.Equal(t, int64(1), rejected)
+	assert.Contains(t, message, "sizes")
+	assert.Equal(t, map[string]any{
+		"api.requests":            int64(5),
+		"api.inflight":            float64(3),
+		"api.temperature.kitchen": 21.5,
+		"api.latency.count":       float64(3),
+		"api.latency.sum":         12.0,
+		"api.latency.min":         1.0,
+		"api.latency.max":         9.0,
+	}, values(metrics))
+}
+
+func TestConverter_Labels(t *testing.T) {
+	c := NewConverter("service.name,host.name", true)
+
+	req := request(
+		[]*commonpb.KeyValue{stringAttr("host.name", "web1"), stringAttr("service.name", "api")},
+		&metricspb.Metric{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{stringAttr("room", "kitchen")},
+				Value:      &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+			}}}},
+		},
+	)
+
+	metrics, rejected, _ := c.Convert(req, newTracker().Begin(""))
+	assert.Zero(t, rejected)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, `temperature{service.name="api",host.name="web1",room="kitchen"}`, metrics[0].ID)
+}
+
+func TestConverter_Cumulative(t *testing.T) {
+	c := NewConverter("", false)
+	tr := newTracker()
+	temporality := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+
+	tests := []struct {
+		name  string
+		start uint64
+		value int64
+		want  int64
+	}{
+		{name: "First point", start: 100, value: 10, want: 10},
+		{name: "Increase", start: 100, value: 15, want: 5},
+		{name: "No change", start: 100, value: 15, want: 0},
+		{name: "Reset by value", start: 100, value: 4, want: 4},
+		{name: "Reset by start time", start: 200, value: 7, want: 7},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			totals := tr.Begin("a")
+			metrics, rejected, _ := c.Convert(request(nil, sum("requests", temporality, true, test.start, test.value)), totals)
+			totals.Commit()
+			assert.Zero(t, rejected)
+			require.Len(t, metrics, 1)
+			assert.Equal(t, types.Counter, metrics[0].MType)
+			assert.Equal(t, test.want, *metrics[0].Delta)
+		})
+	}
+}
+
+func TestConverter_Fractions(t *testing.T) {
+	c := NewConverter("", false)
+	tr := newTracker()
+
+	point := func(temporality metricspb.AggregationTemporality, start uint64, value float64) int64 {
+		totals := tr.Begin("a")
+		defer totals.Commit()
+		metrics, rejected, _ := c.Convert(request(nil, &metricspb.Metric{
+			Name: "bytes",
+			Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+				AggregationTemporality: temporality,
+				IsMonotonic:            true,
+				DataPoints: []*metricspb.NumberDataPoint{{
+					StartTimeUnixNano: start,
+					Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+				}},
+			}},
+		}), totals)
+		require.Zero(t, rejected)
+		require.Len(t, metrics, 1)
+		return *metrics[0].Delta
+	}
+
+	// дробные приращения складываются, а не теряются при округлении каждого
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+	var sum int64
+	for i := 0; i < 10; i++ {
+		sum += point(delta, 0, 0.4)
+	}
+	assert.Equal(t, int64(4), sum)
+
+	running := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	sum = 0
+	for i := 1; i <= 10; i++ {
+		sum += point(running, 100, 0.4*float64(i))
+	}
+	assert.Equal(t, int64(4), sum)
+}
@@ -1,20 +1,58 @@
 package router
 
 import (
+	"net/http"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/server/auth"
 	"github.com/plasmatrip/metriq/internal/server/compress"
 	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
 	"github.com/plasmatrip/metriq/internal/server/handlers"
+	"github.com/plasmatrip/metriq/internal/server/health"
+	"github.com/plasmatrip/metriq/internal/server/limits"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
 	"github.com/plasmatrip/metriq/internal/storage"
 )
 
-func NewRouter(s storage.Repository, c config.Config, l logger.Logger) *chi.Mux {
+// NewRouter builds the routes of the HTTP server. The probe is reported by /readyz,
+// without it only the storage is checked. The IDs of applied batches are kept in b,
+// in memory if it is nil.
+func NewRouter(s storage.Repository, c config.Config, l logger.Logger, p *health.Probe, b dedup.Store) *chi.Mux {
 	h := handlers.NewHandlers(s, c, l)
+	if p != nil {
+		h.Probe = p
+	}
+	if b != nil {
+		h.Batches = b
+	}
 
 	r := chi.NewRouter()
 
+	// the panics of the middleware below and of the handlers are answered with 500 and counted by the metrics
+	r.Use(telemetry.WithMetrics, l.WithRecovery)
+
+	// throttle the clients by their address before doing any work for them, the guesses of API keys too
+	if c.RateLimit > 0 {
+		r.Use(limits.WithRateLimit(limits.NewLimiter(c.RateLimit, c.RateBurst), limits.ClientIP))
+	}
+
+	// authenticate the clients with API keys, if they are configured, the scopes are checked per route below
+	require := func(auth.Scope) func(next http.Handler) http.Handler {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if c.APIKeys != nil {
+		r.Use(auth.WithAuth(c.APIKeys))
+		require = auth.Require
+	}
+
+	// cap the bodies for all the middleware reading them
+	if c.MaxBodySize > 0 {
+		r.Use(limits.WithBodySize(c.MaxBodySize))
+	}
+
 	if c.Key != "" {
 		r.Use(h.WithHashing)
 	}
@@ -23,25 +61,76 @@ func NewRouter(s storage.Repository, c config.Config, l logger.Logger) *chi.Mux
 		r.Use(h.WithDecryption)
 	}
 
-	r.Use(compress.WithCompression(l), l.WithLogging)
+	r.Use(compress.WithCompression(l, c.MaxDecompressedSize, c.CompressMinSize, c.CompressLevel), l.WithLogging)
+
+	r.With(require(auth.ScopeAdmin)).Mount("/debug", middleware.Profiler())
 
-	r.Mount("/debug", middleware.Profiler())
+	// metric writes are accepted only from the trusted subnet, if it is configured
+	r.Group(func(r chi.Router) {
+		r.Use(require(auth.ScopeWrite))
+		if c.TrustedNet != nil {
+			r.Use(h.WithTrustedSubnet)
+		}
 
-	r.Route("/update", func(r chi.Router) {
-		r.Post("/", h.JSONUpdate)
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", h.JSONUpdate)
+		})
+		r.Route("/updates", func(r chi.Router) {
+			r.Post("/", h.JSONUpdates)
+		})
+		r.Route("/v1/metrics", func(r chi.Router) {
+			r.Post("/", h.OTLPMetrics)
+		})
+		r.Post("/update/{metricType}/{metricName}/{metricValue}", h.Update)
 	})
-	r.Route("/updates", func(r chi.Router) {
-		r.Post("/", h.JSONUpdates)
+
+	// versioned API with JSON error envelopes, the legacy routes above and below are kept for compatibility
+	r.Route("/api/v1", func(r chi.Router) {
+		r.NotFound(h.APINotFound)
+		r.MethodNotAllowed(h.APIMethodNotAllowed)
+
+		r.Get("/capabilities", h.APICapabilities)
+
+		r.Group(func(r chi.Router) {
+			r.Use(require(auth.ScopeWrite))
+			if c.TrustedNet != nil {
+				r.Use(h.WithTrustedSubnet)
+			}
+
+			r.Post("/update", h.APIUpdate)
+			r.Post("/updates", h.APIUpdates)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(require(auth.ScopeRead))
+
+			r.Post("/value", h.APIValue)
+			r.Get("/value/{metricType}/{metricName}", h.APIValueByPath)
+			r.Get("/stream", h.APIStream)
+			r.Get("/query", h.APIQuery)
+			r.Get("/eval", h.APIEval)
+			r.Get("/export", h.APIExport)
+		})
 	})
-	r.Route("/value", func(r chi.Router) {
-		r.Post("/", h.JSONValue)
+
+	r.Group(func(r chi.Router) {
+		r.Use(require(auth.ScopeRead))
+
+		r.Route("/value", func(r chi.Router) {
+			r.Post("/", h.JSONValue)
+		})
+		r.Get("/value/{metricType}/{metricName}", h.Value)
+		r.Get("/", h.Metrics)
+		r.Get("/metric/{metricName}", h.MetricDetail)
+		r.Method(http.MethodGet, "/internal/metrics", telemetry.Handler(telemetry.Default))
 	})
-	r.Post("/update/{metricType}/{metricName}/{metricValue}", h.Update)
-	r.Get("/value/{metricType}/{metricName}", h.Value)
-	r.Get("/", h.Metrics)
+
+	// static files, the storage check and the probes are public
+	r.Handle("/assets/*", h.Assets())
 	r.Route("/ping", func(r chi.Router) {
 		r.Get("/", h.Ping)
 	})
+	r.Get("/healthz", h.Healthz)
+	r.Get("/readyz", h.Readyz)
 
 	return r
 }
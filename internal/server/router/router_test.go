@@ -0,0 +1,457 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/auth"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/health"
+	"github.com/plasmatrip/metriq/internal/storage/history"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_TrustedSubnet(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		realIP string
+		want   int
+	}{
+		{
+			name:   "JSON update from trusted address",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"metric","type":"gauge","value":1}`,
+			realIP: "192.168.1.10",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "JSON update from untrusted address",
+			method: http.MethodPost,
+			url:    "/update",
+			body:   `{"id":"metric","type":"gauge","value":1}`,
+			realIP: "10.0.0.1",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "JSON batch update without address",
+			method: http.MethodPost,
+			url:    "/updates",
+			body:   `[{"id":"metric","type":"gauge","value":1}]`,
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "Legacy update from trusted address",
+			method: http.MethodPost,
+			url:    "/update/counter/requests/1",
+			realIP: "192.168.1.10",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Legacy update from untrusted address",
+			method: http.MethodPost,
+			url:    "/update/counter/requests/1",
+			realIP: "10.0.0.1",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "Legacy update with wrong address",
+			method: http.MethodPost,
+			url:    "/update/counter/requests/1",
+			realIP: "wrong",
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "Reading is not restricted",
+			method: http.MethodGet,
+			url:    "/value/counter/requests",
+			realIP: "10.0.0.1",
+			want:   http.StatusOK,
+		},
+	}
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	_, trustedNet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), config.Config{TrustedNet: trustedNet}, log, nil, nil))
+	defer serv.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, serv.URL+test.url, bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+			if test.realIP != "" {
+				request.Header.Set("X-Real-IP", test.realIP)
+			}
+
+			res, err := serv.Client().Do(request)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, test.want, res.StatusCode)
+		})
+	}
+}
+
+func TestRouter_APIv1(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		want   int
+		code   string
+	}{
+		{
+			name:   "Update",
+			method: http.MethodPost,
+			url:    "/api/v1/update",
+			body:   `{"id":"requests","type":"counter","delta":2}`,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Value by path",
+			method: http.MethodGet,
+			url:    "/api/v1/value/counter/requests",
+			want:   http.StatusOK,
+		},
+		{
+			name:   "Value of the wrong type",
+			method: http.MethodGet,
+			url:    "/api/v1/value/gauge/requests",
+			want:   http.StatusNotFound,
+			code:   "not_found",
+		},
+		{
+			name:   "Value with empty name",
+			method: http.MethodPost,
+			url:    "/api/v1/value",
+			body:   `{"type":"counter"}`,
+			want:   http.StatusBadRequest,
+			code:   "invalid_name",
+		},
+		{
+			name:   "Unknown route",
+			method: http.MethodGet,
+			url:    "/api/v1/unknown",
+			want:   http.StatusNotFound,
+			code:   "not_found",
+		},
+		{
+			name:   "Wrong method",
+			method: http.MethodGet,
+			url:    "/api/v1/update",
+			want:   http.StatusMethodNotAllowed,
+			code:   "method_not_allowed",
+		},
+	}
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), config.Config{}, log, nil, nil))
+	defer serv.Close()
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request, err := http.NewRequest(test.method, serv.URL+test.url, bytes.NewBufferString(test.body))
+			require.NoError(t, err)
+
+			res, err := serv.Client().Do(request)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			assert.Equal(t, test.want, res.StatusCode)
+
+			if test.code != "" {
+				var resp models.ErrorResponse
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+				assert.Equal(t, test.code, resp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestRouter_Stream(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	serv := httptest.NewServer(NewRouter(history.NewStorage(mem.NewStorage(), history.DefaultSize), config.Config{}, log, nil, nil))
+	defer serv.Close()
+
+	update := func(body string) {
+		res, err := serv.Client().Post(serv.URL+"/api/v1/update", "application/json", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// subscribe reads the stream until the wanted number of events is received
+	subscribe := func(url, lastEventID string, want int, write func()) []string {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, serv.URL+url, nil)
+		require.NoError(t, err)
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		res, err := serv.Client().Do(request)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		write()
+
+		var lines []string
+		scanner := bufio.NewScanner(res.Body)
+		for len(lines) < want && scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), "id: ") || strings.HasPrefix(scanner.Text(), "data: ") {
+				lines = append(lines, scanner.Text())
+			}
+		}
+		return lines
+	}
+
+	lines := subscribe("/api/v1/stream?prefix=cpu", "", 4, func() {
+		update(`{"id":"mem","type":"gauge","value":1}`)
+		update(`{"id":"cpu1","type":"gauge","value":0.5}`)
+		update(`{"id":"cpu2","type":"counter","delta":2}`)
+	})
+	// идентификаторы продолжаются со времени запуска, проверяются относительно первого
+	require.Len(t, lines, 4)
+	first, err := strconv.ParseUint(strings.TrimPrefix(lines[0], "id: "), 10, 64)
+	require.NoError(t, err)
+	id := func(n uint64) string { return "id: " + strconv.FormatUint(first+n, 10) }
+	assert.Equal(t, []string{
+		id(0),
+		`data: {"id":"cpu1","type":"gauge","value":0.5}`,
+		id(1),
+		`data: {"id":"cpu2","type":"counter","delta":2}`,
+	}, lines)
+
+	// the client resumes after the last received event
+	lines = subscribe("/api/v1/stream?name=cpu2", strconv.FormatUint(first+1, 10), 2, func() {
+		update(`{"id":"cpu2","type":"counter","delta":3}`)
+	})
+	assert.Equal(t, []string{id(2), `data: {"id":"cpu2","type":"counter","delta":5}`}, lines)
+}
+
+func TestRouter_Health(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	stor := mem.NewStorage()
+	probe := health.NewProbe(health.Check{Name: "storage", Fn: stor.Ping})
+	serv := httptest.NewServer(NewRouter(stor, config.Config{}, log, probe, nil))
+	defer serv.Close()
+
+	get := func(url string) (int, health.Result) {
+		res, err := serv.Client().Get(serv.URL + url)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		var result health.Result
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&result))
+		return res.StatusCode, result
+	}
+
+	status, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+
+	status, result := get("/readyz")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, health.Result{Ready: true, Checks: map[string]string{"storage": "ok", "draining": "ok"}}, result)
+
+	probe.Drain()
+
+	status, result = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.False(t, result.Ready)
+
+	// the process is still alive while draining
+	status, _ = get("/healthz")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestRouter_InternalMetrics(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), config.Config{}, log, nil, nil))
+	defer serv.Close()
+
+	res, err := serv.Client().Post(serv.URL+"/update/gauge/load/0.5", "text/plain", nil)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	res, err = serv.Client().Post(serv.URL+"/update", "application/json", bytes.NewBufferString("{"))
+	require.NoError(t, err)
+	res.Body.Close()
+
+	res, err = serv.Client().Get(serv.URL + "/internal/metrics")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain"))
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `metriq_http_requests_total{route="/update/{metricType}/{metricName}/{metricValue}",method="POST",status="200"}`)
+	assert.Contains(t, string(body), `metriq_http_request_duration_seconds_count{route="/update",method="POST"}`)
+	assert.Contains(t, string(body), `metriq_decode_errors_total{format="json"}`)
+}
+
+func TestRouter_Limits(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	c := config.Config{
+		MaxBodySize:         1024,
+		MaxDecompressedSize: 4096,
+		MaxBatchSize:        2,
+		RateLimit:           1,
+		RateBurst:           3,
+	}
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), c, log, nil, nil))
+	defer serv.Close()
+
+	post := func(body []byte, gzipped bool) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, serv.URL+"/api/v1/updates", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if gzipped {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		res, err := serv.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	// тело больше лимита
+	res := post(bytes.Repeat([]byte(" "), 2048), false)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// небольшой архив, распаковывающийся больше лимита
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err = zw.Write(append([]byte("["), bytes.Repeat([]byte(" "), 64<<10)...))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.Less(t, buf.Len(), 1024)
+	res = post(buf.Bytes(), true)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// пакет длиннее лимита
+	res = post([]byte(`[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]`), false)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+
+	// корзина клиента исчерпана
+	res = post([]byte(`[]`), false)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "1", res.Header.Get("Retry-After"))
+}
+
+func TestRouter_Auth(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	keys, err := auth.NewKeys(
+		auth.Key{ID: "agent", SecretHash: auth.HashSecret("agent-secret"), Scopes: []auth.Scope{auth.ScopeWrite}},
+		auth.Key{ID: "grafana", SecretHash: auth.HashSecret("grafana-secret"), Scopes: []auth.Scope{auth.ScopeRead}},
+		auth.Key{ID: "root", SecretHash: auth.HashSecret("root-secret"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	)
+	require.NoError(t, err)
+
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), config.Config{APIKeys: keys}, log, nil, nil))
+	defer serv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		secret string
+		want   int
+	}{
+		{name: "Write without key", method: http.MethodPost, url: "/update/gauge/load/1", want: http.StatusUnauthorized},
+		{name: "Write with unknown key", method: http.MethodPost, url: "/update/gauge/load/1", secret: "wrong", want: http.StatusUnauthorized},
+		{name: "Write with read key", method: http.MethodPost, url: "/update/gauge/load/1", secret: "grafana-secret", want: http.StatusForbidden},
+		{name: "Write with write key", method: http.MethodPost, url: "/update/gauge/load/1", secret: "agent-secret", want: http.StatusOK},
+		{name: "Read without key", method: http.MethodGet, url: "/value/gauge/load", want: http.StatusUnauthorized},
+		{name: "Read with write key", method: http.MethodGet, url: "/value/gauge/load", secret: "agent-secret", want: http.StatusForbidden},
+		{name: "Read with read key", method: http.MethodGet, url: "/value/gauge/load", secret: "grafana-secret", want: http.StatusOK},
+		{name: "API read with read key", method: http.MethodGet, url: "/api/v1/value/gauge/load", secret: "grafana-secret", want: http.StatusOK},
+		{name: "API write with read key", method: http.MethodPost, url: "/api/v1/updates", secret: "grafana-secret", want: http.StatusForbidden},
+		{name: "Dashboard without key", method: http.MethodGet, url: "/", want: http.StatusUnauthorized},
+		{name: "Debug with read key", method: http.MethodGet, url: "/debug/pprof/", secret: "grafana-secret", want: http.StatusForbidden},
+		{name: "Debug with admin key", method: http.MethodGet, url: "/debug/pprof/", secret: "root-secret", want: http.StatusOK},
+		{name: "Admin reads metrics", method: http.MethodGet, url: "/value/gauge/load", secret: "root-secret", want: http.StatusOK},
+		{name: "Probe without key", method: http.MethodGet, url: "/healthz", want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, serv.URL+test.url, nil)
+			require.NoError(t, err)
+			if test.secret != "" {
+				req.Header.Set("Authorization", "Bearer "+test.secret)
+			}
+
+			res, err := serv.Client().Do(req)
+			require.NoError(t, err)
+			res.Body.Close()
+
+			assert.Equal(t, test.want, res.StatusCode)
+		})
+	}
+}
+
+func TestRouter_AuthRateLimit(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	keys, err := auth.NewKeys(auth.Key{ID: "agent", SecretHash: auth.HashSecret("agent-secret"), Scopes: []auth.Scope{auth.ScopeWrite}})
+	require.NoError(t, err)
+
+	c := config.Config{APIKeys: keys, RateLimit: 1, RateBurst: 2}
+	serv := httptest.NewServer(NewRouter(mem.NewStorage(), c, log, nil, nil))
+	defer serv.Close()
+
+	post := func(secret string) int {
+		req, err := http.NewRequest(http.MethodPost, serv.URL+"/update/gauge/load/1", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		res, err := serv.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	// подбор ключа ограничивается по адресу клиента до проверки ключа
+	assert.Equal(t, http.StatusUnauthorized, post("wrong-1"))
+	assert.Equal(t, http.StatusUnauthorized, post("wrong-2"))
+	assert.Equal(t, http.StatusTooManyRequests, post("wrong-3"))
+	assert.Equal(t, http.StatusTooManyRequests, post("agent-secret"))
+}
@@ -0,0 +1,214 @@
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"net"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	"github.com/plasmatrip/metriq/internal/server/auth"
+	"github.com/plasmatrip/metriq/internal/server/cert"
+	"github.com/plasmatrip/metriq/internal/server/telemetry"
+)
+
+// WithLogging logs the method, duration and status code of every unary call.
+func (s *Server) WithLogging(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	s.lg.Sugar.Infoln("METHOD", info.FullMethod, "  DURATION:", time.Since(start), "  STATUS", status.Code(err))
+
+	return resp, err
+}
+
+// WithStreamLogging logs the method, duration and status code of every streaming call.
+func (s *Server) WithStreamLogging(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+
+	err := handler(srv, ss)
+
+	s.lg.Sugar.Infoln("METHOD", info.FullMethod, "  DURATION:", time.Since(start), "  STATUS", status.Code(err))
+
+	return err
+}
+
+// WithAuth requires an API key with the write scope in the authorization
+// metadata, like the bearer token check of the HTTP server.
+func (s *Server) WithAuth(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkKey(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// WithStreamAuth is the streaming variant of WithAuth.
+func (s *Server) WithStreamAuth(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkKey(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// WithTrustedSubnet rejects calls whose x-real-ip metadata is not in the
+// trusted subnet, like the X-Real-IP check of the HTTP server.
+func (s *Server) WithTrustedSubnet(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := s.checkRealIP(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// WithStreamTrustedSubnet is the streaming variant of WithTrustedSubnet.
+func (s *Server) WithStreamTrustedSubnet(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.checkRealIP(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// WithHashing verifies the HMAC signature of the request, like the HashSHA256
+// header check of the HTTP server. Requests without a signature are let through.
+func (s *Server) WithHashing(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if r, ok := req.(*pb.UpdateMetricsRequest); ok {
+		if err := s.checkHash(r); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// WithDecryption replaces the encrypted payload of the request with the
+// decrypted metrics, like the body decryption of the HTTP server.
+func (s *Server) WithDecryption(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if r, ok := req.(*pb.UpdateMetricsRequest); ok {
+		if err := s.decrypt(r); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// WithStreamSecurity applies the signature check and the decryption to every
+// message received on a stream.
+func (s *Server) WithStreamSecurity(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &securedStream{ServerStream: ss, s: s})
+}
+
+type securedStream struct {
+	grpc.ServerStream
+	s *Server
+}
+
+func (ss *securedStream) RecvMsg(m any) error {
+	if err := ss.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	r, ok := m.(*pb.UpdateMetricsRequest)
+	if !ok {
+		return nil
+	}
+
+	if ss.s.cfg.Key != "" {
+		if err := ss.s.checkHash(r); err != nil {
+			return err
+		}
+	}
+
+	if ss.s.cfg.CryptoKey != nil {
+		return ss.s.decrypt(r)
+	}
+
+	return nil
+}
+
+func (s *Server) checkKey(ctx context.Context) error {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			if scheme, t, ok := strings.Cut(values[0], " "); ok && strings.EqualFold(scheme, "Bearer") {
+				token = strings.TrimSpace(t)
+			}
+		}
+	}
+
+	k, err := s.cfg.APIKeys.Authenticate(token)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if !k.Allows(auth.ScopeWrite) {
+		s.lg.Sugar.Infow("the API key has no write scope", "key", k.ID)
+		return status.Error(codes.PermissionDenied, "the API key has no write scope")
+	}
+
+	return nil
+}
+
+func (s *Server) checkRealIP(ctx context.Context) error {
+	var realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-real-ip"); len(values) > 0 {
+			realIP = values[0]
+		}
+	}
+
+	ip := net.ParseIP(realIP)
+	if ip == nil || !s.cfg.TrustedNet.Contains(ip) {
+		s.lg.Sugar.Infow("request from untrusted address", "x-real-ip", realIP)
+		return status.Error(codes.PermissionDenied, "the address is not in the trusted subnet")
+	}
+
+	return nil
+}
+
+func (s *Server) checkHash(r *pb.UpdateMetricsRequest) error {
+	if r.GetHash() == "" {
+		return nil
+	}
+
+	sumHash, err := pb.Sum(r, s.cfg.Key)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	if !hmac.Equal([]byte(r.GetHash()), []byte(sumHash)) {
+		s.lg.Sugar.Infow("error in request handler", "req: ", r.GetHash(), "sum: ", sumHash)
+		return status.Error(codes.InvalidArgument, "hashes are not equal")
+	}
+
+	return nil
+}
+
+func (s *Server) decrypt(r *pb.UpdateMetricsRequest) error {
+	if len(r.GetEncrypted()) == 0 {
+		return status.Error(codes.InvalidArgument, "the request is not encrypted")
+	}
+
+	data, err := cert.DecryptData(r.GetEncrypted(), s.cfg.CryptoKey)
+	if err != nil {
+		telemetry.DecodeErrors.Inc("decrypt")
+		s.lg.Sugar.Infow("error encryption data", "error: ", err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	batch := &pb.UpdateMetricsRequest{}
+	if err := proto.Unmarshal(data, batch); err != nil {
+		telemetry.DecodeErrors.Inc("protobuf")
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	r.Metrics = batch.GetMetrics()
+	r.Encrypted = nil
+
+	return nil
+}
@@ -0,0 +1,247 @@
+// Package rpc implements the gRPC transport for metric delivery. It is an
+// alternative to the JSON endpoints of the HTTP server: the Metrics service
+// accepts batches of metrics in a unary call or as a client stream. Server
+// interceptors reproduce the chi middleware of the HTTP server: request
+// logging, the API key check, the trusted subnet check, the HMAC-SHA256
+// signature check and the RSA decryption. With a TLS certificate configured the server uses the
+// same TLS settings as the HTTP server, including client certificate checks.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// maxBatchIDLength - максимальная длина идентификатора пакета
+const maxBatchIDLength = 128
+
+type Server struct {
+	pb.UnimplementedMetricsServer
+
+	// Batches keeps the IDs of applied batches, the server shares it with the HTTP handlers
+	Batches dedup.Store
+
+	cfg  config.Config
+	stor storage.Repository
+	lg   logger.Logger
+	srv  *grpc.Server
+	wg   sync.WaitGroup
+}
+
+func NewServer(cfg config.Config, stor storage.Repository, lg logger.Logger) *Server {
+	s := &Server{
+		Batches: dedup.NewCache(cfg.BatchIDCacheSize, time.Duration(cfg.BatchIDTTL)*time.Second),
+		cfg:     cfg,
+		stor:    stor,
+		lg:      lg,
+	}
+
+	unary := []grpc.UnaryServerInterceptor{s.WithLogging}
+	stream := []grpc.StreamServerInterceptor{s.WithStreamLogging}
+	if cfg.APIKeys != nil {
+		unary = append(unary, s.WithAuth)
+		stream = append(stream, s.WithStreamAuth)
+	}
+	if cfg.TrustedNet != nil {
+		unary = append(unary, s.WithTrustedSubnet)
+		stream = append(stream, s.WithStreamTrustedSubnet)
+	}
+	if cfg.Key != "" {
+		unary = append(unary, s.WithHashing)
+	}
+	if cfg.CryptoKey != nil {
+		unary = append(unary, s.WithDecryption)
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(append(stream, s.WithStreamSecurity)...),
+	}
+	if cfg.MaxBodySize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(cfg.MaxBodySize)))
+	}
+	if cfg.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(cfg.TLS)))
+	}
+
+	s.srv = grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(s.srv, s)
+
+	return s
+}
+
+// Start listens on the configured address and serves gRPC requests until the
+// context is canceled, then stops gracefully.
+func (s *Server) Start(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.cfg.GRPCAddr)
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		if err := s.Serve(lis); err != nil {
+			s.lg.Sugar.Infow("gRPC server error", "error: ", err)
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		<-ctx.Done()
+		s.srv.GracefulStop()
+	}()
+
+	s.lg.Sugar.Infow("The gRPC server is running", "address", lis.Addr().String())
+
+	return nil
+}
+
+// Serve accepts connections on the listener, it blocks until the server is stopped.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.srv.Serve(lis)
+}
+
+// Stop stops the server immediately.
+func (s *Server) Stop() {
+	s.srv.Stop()
+}
+
+// Wait blocks until the server has stopped.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Server) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	metrics, err := convert(req, s.cfg.MaxBatchSize, s.cfg.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.applyBatch(ctx, metrics); err != nil {
+		return nil, err
+	}
+
+	return &pb.UpdateMetricsResponse{Accepted: int64(len(metrics))}, nil
+}
+
+// StreamMetrics reads the whole stream and applies its metrics at once when it ends, a stream
+// that fails midway applies nothing, so its retry does not add the counters of the first
+// messages twice. The batch ID covers the whole stream, a duplicate stream is acknowledged
+// without applying it.
+func (s *Server) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	var metrics []models.Metrics
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch, err := convert(req, s.cfg.MaxBatchSize, s.cfg.Names)
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, batch...)
+	}
+
+	if len(metrics) > 0 {
+		if err := s.applyBatch(stream.Context(), metrics); err != nil {
+			return err
+		}
+	}
+
+	return stream.SendAndClose(&pb.UpdateMetricsResponse{Accepted: int64(len(metrics))})
+}
+
+// applyBatch applies the metrics unless the batch ID of the x-batch-id metadata, if the call
+// has one, is already recorded. The ID is recorded together with the metrics.
+func (s *Server) applyBatch(ctx context.Context, metrics []models.Metrics) error {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-batch-id"); len(values) > 0 {
+			id = values[0]
+		}
+	}
+
+	apply := func(ctx context.Context) error {
+		return s.stor.SetMetrics(ctx, metrics)
+	}
+
+	if id == "" || s.Batches == nil {
+		if err := apply(ctx); err != nil {
+			s.lg.Sugar.Infow("error in request handler", "error: ", err)
+			return storeError(err)
+		}
+		return nil
+	}
+
+	if len(id) > maxBatchIDLength {
+		return status.Errorf(codes.InvalidArgument, "the batch ID is longer than %d characters", maxBatchIDLength)
+	}
+
+	applied, err := s.Batches.Apply(ctx, id, apply)
+	if err != nil {
+		s.lg.Sugar.Infow("error in request handler", "error: ", err)
+		return storeError(err)
+	}
+
+	if !applied {
+		s.lg.Sugar.Infow("duplicate batch acknowledged", "batch id", id)
+	}
+
+	return nil
+}
+
+// convert validates the metrics of the request and converts them to the model,
+// a batch longer than maxBatch is rejected, 0 means no limit, and so is a metric
+// whose name breaks the name policy.
+func convert(req *pb.UpdateMetricsRequest, maxBatch int, names *types.NamePolicy) ([]models.Metrics, error) {
+	if maxBatch > 0 && len(req.GetMetrics()) > maxBatch {
+		return nil, status.Errorf(codes.ResourceExhausted, "the batch has %d metrics, at most %d are allowed", len(req.GetMetrics()), maxBatch)
+	}
+
+	metrics := make([]models.Metrics, 0, len(req.GetMetrics()))
+	for _, m := range req.GetMetrics() {
+		metric, err := m.ToModel()
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := types.CheckFields(metric); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if metric.ID, err = names.Apply(metric.ID); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// storeError converts an error of the storage: a metric of another type stored under the name
+// is reported with AlreadyExists, other errors are internal.
+func storeError(err error) error {
+	if errors.Is(err, types.ErrTypeConflict) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
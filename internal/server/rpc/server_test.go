@@ -0,0 +1,286 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"math"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	pb "github.com/plasmatrip/metriq/internal/proto"
+	"github.com/plasmatrip/metriq/internal/server/auth"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs the gRPC server on an in-process bufconn listener and
+// returns a client connected to it.
+func startServer(t *testing.T, cfg config.Config) (pb.MetricsClient, *mem.MemStorage) {
+	t.Helper()
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	stor := mem.NewStorage()
+	srv := NewServer(cfg, stor, log)
+
+	lis := bufconn.Listen(1024 * 1024)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn), stor
+}
+
+func gauge(id string, value float64) *pb.Metric {
+	return &pb.Metric{Id: id, Type: pb.Metric_GAUGE, Value: value}
+}
+
+func counter(id string, delta int64) *pb.Metric {
+	return &pb.Metric{Id: id, Type: pb.Metric_COUNTER, Delta: delta}
+}
+
+func TestServer_UpdateMetrics(t *testing.T) {
+	client, stor := startServer(t, config.Config{})
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		metrics []*pb.Metric
+		code    codes.Code
+	}{
+		{
+			name:    "Valid batch",
+			metrics: []*pb.Metric{gauge("load", 0.5), counter("requests", 3)},
+			code:    codes.OK,
+		},
+		{
+			name:    "Empty metric name",
+			metrics: []*pb.Metric{gauge("", 1)},
+			code:    codes.InvalidArgument,
+		},
+		{
+			name:    "Undefined metric type",
+			metrics: []*pb.Metric{{Id: "metric"}},
+			code:    codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: test.metrics})
+			assert.Equal(t, test.code, status.Code(err))
+			if test.code == codes.OK {
+				assert.Equal(t, int64(len(test.metrics)), resp.GetAccepted())
+			}
+		})
+	}
+
+	metric, err := stor.Metric(ctx, types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), metric.Value)
+}
+
+func TestServer_StreamMetrics(t *testing.T) {
+	client, stor := startServer(t, config.Config{})
+	ctx := context.Background()
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{counter("requests", 2)}}))
+	}
+
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), resp.GetAccepted())
+
+	metric, err := stor.Metric(ctx, types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), metric.Value)
+}
+
+func TestServer_BatchDuplicates(t *testing.T) {
+	client, stor := startServer(t, config.Config{})
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-batch-id", "batch-1")
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{counter("requests", 2)}}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.UpdateMetrics(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), resp.GetAccepted())
+	}
+
+	// поток с тем же идентификатором читается, но не применяется
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetAccepted())
+
+	metric, err := stor.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), metric.Value)
+
+	// поток, оборвавшийся на середине, не применяется, его повтор применяется один раз
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "x-batch-id", "batch-2")
+	stream, err = client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", math.NaN())}}))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err = client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	require.NoError(t, stream.Send(req))
+	_, err = stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	metric, err = stor.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), metric.Value)
+}
+
+func TestServer_Hashing(t *testing.T) {
+	client, _ := startServer(t, config.Config{Key: "secret"})
+	ctx := context.Background()
+
+	signed := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", 0.5)}}
+	hash, err := pb.Sum(signed, "secret")
+	require.NoError(t, err)
+	signed.Hash = hash
+
+	_, err = client.UpdateMetrics(ctx, signed)
+	assert.NoError(t, err)
+
+	tampered := proto.Clone(signed).(*pb.UpdateMetricsRequest)
+	tampered.Metrics[0].Value = 100
+	_, err = client.UpdateMetrics(ctx, tampered)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(tampered))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_TrustedSubnet(t *testing.T) {
+	_, trustedNet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	client, _ := startServer(t, config.Config{TrustedNet: trustedNet})
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", 0.5)}}
+
+	tests := []struct {
+		name   string
+		realIP string
+		code   codes.Code
+	}{
+		{name: "Trusted address", realIP: "192.168.1.10", code: codes.OK},
+		{name: "Untrusted address", realIP: "10.0.0.1", code: codes.PermissionDenied},
+		{name: "No address", code: codes.PermissionDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.realIP != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "x-real-ip", test.realIP)
+			}
+
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, test.code, status.Code(err))
+
+			stream, err := client.StreamMetrics(ctx)
+			require.NoError(t, err)
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	keys, err := auth.NewKeys(
+		auth.Key{ID: "agent", SecretHash: auth.HashSecret("agent-secret"), Scopes: []auth.Scope{auth.ScopeWrite}},
+		auth.Key{ID: "grafana", SecretHash: auth.HashSecret("grafana-secret"), Scopes: []auth.Scope{auth.ScopeRead}},
+	)
+	require.NoError(t, err)
+
+	client, _ := startServer(t, config.Config{APIKeys: keys})
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", 0.5)}}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   codes.Code
+	}{
+		{name: "Write key", secret: "agent-secret", code: codes.OK},
+		{name: "Read key", secret: "grafana-secret", code: codes.PermissionDenied},
+		{name: "Unknown key", secret: "wrong", code: codes.Unauthenticated},
+		{name: "No key", code: codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.secret != "" {
+				ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+test.secret)
+			}
+
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, test.code, status.Code(err))
+
+			stream, err := client.StreamMetrics(ctx)
+			require.NoError(t, err)
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+func TestServer_Decryption(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	client, stor := startServer(t, config.Config{CryptoKey: key})
+	ctx := context.Background()
+
+	data, err := proto.Marshal(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", 0.5)}})
+	require.NoError(t, err)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, data)
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Encrypted: encrypted})
+	require.NoError(t, err)
+
+	metric, err := stor.Metric(ctx, types.Gauge, "load")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, metric.Value)
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("load", 1)}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
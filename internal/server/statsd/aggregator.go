@@ -0,0 +1,136 @@
+package statsd
+
+import (
+	"math"
+	"sync"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// longestSuffix - длина самого длинного суффикса метрик таймера
+const longestSuffix = len(".count")
+
+// timer accumulates the timings received for one name during a flush interval.
+// count is the number of timings sent, the received ones scaled by their sample rates.
+type timer struct {
+	count    float64
+	received int64
+	sum      float64
+	min      float64
+	max      float64
+}
+
+// aggregator collects StatsD samples between flushes. Counters and timers are
+// reset after every flush, gauges keep their last value so that relative
+// updates (+N/-N) are applied to it, as the reference StatsD daemon does. A gauge
+// not updated during a whole flush interval is dropped, its next relative update
+// starts from the value the listener seeds it with.
+type aggregator struct {
+	mu       sync.Mutex
+	counters map[string]float64
+	gauges   map[string]float64
+	dirty    map[string]struct{}
+	timers   map[string]*timer
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{
+		counters: make(map[string]float64),
+		gauges:   make(map[string]float64),
+		dirty:    make(map[string]struct{}),
+		timers:   make(map[string]*timer),
+	}
+}
+
+func (a *aggregator) add(s sample) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch s.kind {
+	case counterType:
+		a.counters[s.name] += s.value / s.rate
+	case gaugeType:
+		if s.relative {
+			a.gauges[s.name] += s.value
+		} else {
+			a.gauges[s.name] = s.value
+		}
+		a.dirty[s.name] = struct{}{}
+	case timerType:
+		t, ok := a.timers[s.name]
+		if !ok {
+			t = &timer{min: s.value, max: s.value}
+			a.timers[s.name] = t
+		}
+		t.count += 1 / s.rate
+		t.received++
+		t.sum += s.value
+		t.min = math.Min(t.min, s.value)
+		t.max = math.Max(t.max, s.value)
+	}
+}
+
+// hasGauge reports whether the aggregator holds the value of the gauge.
+func (a *aggregator) hasGauge(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, ok := a.gauges[name]
+	return ok
+}
+
+// seed sets the value the relative updates of the gauge start from, unless the aggregator holds one already.
+func (a *aggregator) seed(name string, value float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.gauges[name]; !ok {
+		a.gauges[name] = value
+	}
+}
+
+// flush returns the metrics aggregated since the previous flush and resets the
+// per-interval state.
+func (a *aggregator) flush() []models.Metrics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metrics := make([]models.Metrics, 0, len(a.counters)+len(a.dirty)+len(a.timers)*5)
+
+	for name, value := range a.counters {
+		delta := int64(math.Round(value))
+		metrics = append(metrics, models.Metrics{ID: name, MType: types.Counter, Delta: &delta})
+	}
+
+	for name := range a.dirty {
+		value := a.gauges[name]
+		metrics = append(metrics, models.Metrics{ID: name, MType: types.Gauge, Value: &value})
+	}
+
+	for name, t := range a.timers {
+		stats := map[string]float64{
+			".count": math.Round(t.count),
+			".sum":   t.sum,
+			".min":   t.min,
+			".max":   t.max,
+			".mean":  t.sum / float64(t.received),
+		}
+		for suffix, value := range stats {
+			metrics = append(metrics, models.Metrics{ID: name + suffix, MType: types.Gauge, Value: &value})
+		}
+	}
+
+	// gauge без обновлений за интервал удаляется, он уже записан в хранилище предыдущими сбросами
+	for name := range a.gauges {
+		if _, ok := a.dirty[name]; !ok {
+			delete(a.gauges, name)
+		}
+	}
+
+	a.counters = make(map[string]float64)
+	a.dirty = make(map[string]struct{})
+	a.timers = make(map[string]*timer)
+
+	return metrics
+}
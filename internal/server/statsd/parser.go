@@ -0,0 +1,99 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	counterType = "c"
+	gaugeType   = "g"
+	timerType   = "ms"
+)
+
+// sample is a single StatsD measurement parsed from a packet line.
+type sample struct {
+	name     string
+	kind     string
+	value    float64
+	rate     float64
+	relative bool // gauge value is a +/- delta to the previous value
+}
+
+// parsePacket splits a StatsD packet into lines and parses each of them.
+// Lines that fail to parse are returned as errors, the rest are still applied.
+func parsePacket(packet []byte) ([]sample, []error) {
+	var samples []sample
+	var errs []error
+
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	return samples, errs
+}
+
+// parseLine parses a line of the form name:value|type[|@rate][|#tags].
+func parseLine(line string) (sample, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample{}, fmt.Errorf("invalid statsd line %q: missing metric name", line)
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return sample{}, fmt.Errorf("invalid statsd line %q: missing metric type", line)
+	}
+
+	s := sample{name: name, kind: fields[1], rate: 1}
+
+	raw := fields[0]
+	if raw == "" {
+		return sample{}, fmt.Errorf("invalid statsd line %q: empty value", line)
+	}
+
+	switch s.kind {
+	case counterType, timerType:
+	case gaugeType:
+		s.relative = raw[0] == '+' || raw[0] == '-'
+	default:
+		return sample{}, fmt.Errorf("invalid statsd line %q: unsupported metric type %q", line, s.kind)
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return sample{}, fmt.Errorf("invalid statsd line %q: %w", line, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return sample{}, fmt.Errorf("invalid statsd line %q: value is not finite", line)
+	}
+	s.value = value
+
+	for _, field := range fields[2:] {
+		if !strings.HasPrefix(field, "@") {
+			// tags and unknown extensions are ignored
+			continue
+		}
+		rate, err := strconv.ParseFloat(field[1:], 64)
+		if err != nil {
+			return sample{}, fmt.Errorf("invalid statsd line %q: %w", line, err)
+		}
+		if rate <= 0 || rate > 1 {
+			return sample{}, fmt.Errorf("invalid statsd line %q: sample rate must be in the range (0, 1]", line)
+		}
+		s.rate = rate
+	}
+
+	return s, nil
+}
@@ -0,0 +1,161 @@
+// Package statsd implements a UDP listener for the StatsD line protocol.
+// Received counters (c), gauges (g) and timers (ms) are aggregated in memory
+// and written to the repository in batches once per flush interval. Timers are
+// stored as a set of gauges with the .count, .sum, .min, .max and .mean suffixes,
+// the count and the counters are scaled by the sample rates. A relative gauge
+// update of a name the listener holds no value for starts from the stored gauge.
+// The name policy is applied to the received names, leaving room for the suffix
+// of a timer, and once more to the names of the flushed metrics.
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	maxPacketSize = 65535
+	batchSize     = 500
+)
+
+type Listener struct {
+	cfg  config.Config
+	stor storage.Repository
+	lg   logger.Logger
+	agg  *aggregator
+	conn net.PacketConn
+	wg   sync.WaitGroup
+}
+
+func NewListener(cfg config.Config, stor storage.Repository, lg logger.Logger) *Listener {
+	return &Listener{
+		cfg:  cfg,
+		stor: stor,
+		lg:   lg,
+		agg:  newAggregator(),
+	}
+}
+
+// Start binds the UDP socket and starts reading and flushing goroutines.
+// Both of them stop when the context is canceled, the aggregated metrics
+// left at that moment are flushed one last time.
+func (l *Listener) Start(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", l.cfg.StatsdAddr)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+
+	l.wg.Add(2)
+	go l.read()
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(time.Duration(l.cfg.StatsdFlushInterval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.flush(ctx)
+			case <-ctx.Done():
+				// closing the socket unblocks the reader
+				if err := l.conn.Close(); err != nil {
+					l.lg.Sugar.Infow("error closing statsd listener", "error: ", err)
+				}
+				l.flush(context.Background())
+				return
+			}
+		}
+	}()
+
+	l.lg.Sugar.Infow("The StatsD listener is running", "address", conn.LocalAddr().String())
+
+	return nil
+}
+
+// Addr returns the address the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Wait blocks until the listener has stopped and the final flush is done.
+func (l *Listener) Wait() {
+	l.wg.Wait()
+}
+
+func (l *Listener) read() {
+	defer l.wg.Done()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.lg.Sugar.Infow("error reading statsd packet", "error: ", err)
+			continue
+		}
+
+		samples, errs := parsePacket(buf[:n])
+		for _, err := range errs {
+			l.lg.Sugar.Infow("error parsing statsd packet", "error: ", err)
+		}
+		for _, s := range samples {
+			// имя приводится к правилам до агрегации, чтобы совпадающие после этого имена агрегировались вместе,
+			// у таймера остается место для суффикса
+			names := l.cfg.Names
+			if s.kind == timerType {
+				names = names.Reserve(longestSuffix)
+			}
+			name, err := names.Apply(s.name)
+			if err != nil {
+				l.lg.Sugar.Infow("error parsing statsd packet", "error: ", err)
+				continue
+			}
+			s.name = name
+			if s.kind == gaugeType && s.relative && !l.agg.hasGauge(name) {
+				l.agg.seed(name, l.storedGauge(name))
+			}
+			l.agg.add(s)
+		}
+	}
+}
+
+// storedGauge returns the stored value of the gauge, 0 if there is none.
+func (l *Listener) storedGauge(name string) float64 {
+	metric, err := l.stor.Metric(context.Background(), types.Gauge, name)
+	if err != nil {
+		return 0
+	}
+	value, _ := metric.Value.(float64)
+	return value
+}
+
+func (l *Listener) flush(ctx context.Context) {
+	// имена таймеров с суффиксами проверяются заново
+	metrics, dropped, err := l.cfg.Names.Filter(l.agg.flush())
+	if err != nil {
+		l.lg.Sugar.Infow("statsd metrics dropped", "dropped", dropped, "error: ", err)
+	}
+
+	for start := 0; start < len(metrics); start += batchSize {
+		end := min(start+batchSize, len(metrics))
+		// метрика другого типа под занятым именем теряется одна, а не весь пакет
+		conflicts, err := storage.SetMetricsApart(ctx, l.stor, metrics[start:end])
+		if len(conflicts) > 0 {
+			l.lg.Sugar.Infow("statsd metrics of another type dropped", "names", conflicts)
+		}
+		if err != nil {
+			l.lg.Sugar.Infow("error saving statsd metrics", "error: ", err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sample
+		errWant bool
+	}{
+		{
+			name: "Counter",
+			line: "requests:1|c",
+			want: sample{name: "requests", kind: counterType, value: 1, rate: 1},
+		},
+		{
+			name: "Counter with sample rate and tags",
+			line: "requests:2|c|@0.5|#env:prod",
+			want: sample{name: "requests", kind: counterType, value: 2, rate: 0.5},
+		},
+		{
+			name: "Gauge",
+			line: "temperature:21.5|g",
+			want: sample{name: "temperature", kind: gaugeType, value: 21.5, rate: 1},
+		},
+		{
+			name: "Relative gauge increment",
+			line: "temperature:+2|g",
+			want: sample{name: "temperature", kind: gaugeType, value: 2, rate: 1, relative: true},
+		},
+		{
+			name: "Relative gauge decrement",
+			line: "temperature:-3|g",
+			want: sample{name: "temperature", kind: gaugeType, value: -3, rate: 1, relative: true},
+		},
+		{
+			name: "Timer",
+			line: "latency:320|ms",
+			want: sample{name: "latency", kind: timerType, value: 320, rate: 1},
+		},
+		{
+			name:    "Empty name",
+			line:    ":1|c",
+			errWant: true,
+		},
+		{
+			name:    "Missing type",
+			line:    "requests:1",
+			errWant: true,
+		},
+		{
+			name:    "Unsupported type",
+			line:    "users:1|s",
+			errWant: true,
+		},
+		{
+			name:    "Wrong value",
+			line:    "requests:aa|c",
+			errWant: true,
+		},
+		{
+			name:    "Wrong sample rate",
+			line:    "requests:1|c|@2",
+			errWant: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := parseLine(test.line)
+			if test.errWant {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, s)
+		})
+	}
+}
+
+func TestAggregator_Flush(t *testing.T) {
+	agg := newAggregator()
+
+	samples, errs := parsePacket([]byte("hits:1|c\nhits:1|c|@0.5\nload:10|g\nload:+5|g\nlatency:10|ms\nlatency:30|ms\nbad line"))
+	assert.Len(t, errs, 1)
+	for _, s := range samples {
+		agg.add(s)
+	}
+
+	got := make(map[string]any)
+	for _, m := range agg.flush() {
+		switch m.MType {
+		case types.Counter:
+			got[m.ID] = *m.Delta
+		case types.Gauge:
+			got[m.ID] = *m.Value
+		}
+	}
+
+	assert.Equal(t, int64(3), got["hits"])
+	assert.Equal(t, float64(15), got["load"])
+	assert.Equal(t, float64(2), got["latency.count"])
+	assert.Equal(t, float64(40), got["latency.sum"])
+	assert.Equal(t, float64(10), got["latency.min"])
+	assert.Equal(t, float64(30), got["latency.max"])
+	assert.Equal(t, float64(20), got["latency.mean"])
+
+	// gauges are written only when updated, a gauge idle for an interval is dropped
+	// and its relative update starts from the seeded value
+	assert.True(t, agg.hasGauge("load"))
+	assert.Empty(t, agg.flush())
+	assert.False(t, agg.hasGauge("load"))
+	agg.seed("load", 15)
+	agg.add(sample{name: "load", kind: gaugeType, value: -5, rate: 1, relative: true})
+	metrics := agg.flush()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, float64(10), *metrics[0].Value)
+
+	// the sample rate of a timer scales its count, not its mean
+	agg.add(sample{name: "latency", kind: timerType, value: 10, rate: 0.25})
+	agg.add(sample{name: "latency", kind: timerType, value: 30, rate: 0.25})
+	got = make(map[string]any)
+	for _, m := range agg.flush() {
+		got[m.ID] = *m.Value
+	}
+	assert.Equal(t, float64(8), got["latency.count"])
+	assert.Equal(t, float64(20), got["latency.mean"])
+}
+
+func TestListener(t *testing.T) {
+	stor := mem.NewStorage()
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	l := NewListener(config.Config{StatsdAddr: "127.0.0.1:0", StatsdFlushInterval: 60}, stor, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("requests:5|c\nrequests:1|c\ntemperature:21.5|g"))
+	require.NoError(t, err)
+
+	// give the reader a chance to receive the packet before shutting down
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.counters) > 0 && len(l.agg.dirty) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	counter, err := stor.Metric(context.Background(), types.Counter, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), counter.Value)
+
+	gauge, err := stor.Metric(context.Background(), types.Gauge, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, gauge.Value)
+}
+
+func TestListener_Names(t *testing.T) {
+	stor := mem.NewStorage()
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	names, err := types.NewNamePolicy(types.NameRules{Mode: types.NameSanitize, MaxLength: 10})
+	require.NoError(t, err)
+	l := NewListener(config.Config{StatsdAddr: "127.0.0.1:0", StatsdFlushInterval: 60, Names: names}, stor, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("latency.total:5|ms"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.timers) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	// имя таймера обрезано так, чтобы суффиксы поместились и не совпадали
+	for _, name := range []string{"late.count", "late.sum", "late.min", "late.max", "late.mean"} {
+		_, err := stor.Metric(context.Background(), types.Gauge, name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestListener_RelativeGauge(t *testing.T) {
+	stor := mem.NewStorage()
+	// значение, записанное до перезапуска
+	require.NoError(t, stor.SetMetric(context.Background(), "temperature", types.Metric{MetricType: types.Gauge, Value: 20.0}))
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	l := NewListener(config.Config{StatsdAddr: "127.0.0.1:0", StatsdFlushInterval: 60}, stor, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("temperature:+1.5|g"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.dirty) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	gauge, err := stor.Metric(context.Background(), types.Gauge, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, gauge.Value)
+}
+
+func TestListener_TypeConflict(t *testing.T) {
+	stor := mem.NewStorage()
+	// под именем уже хранится gauge, строка со счетчиком конфликтует
+	require.NoError(t, stor.SetMetric(context.Background(), "requests", types.Metric{MetricType: types.Gauge, Value: 1.0}))
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	l := NewListener(config.Config{StatsdAddr: "127.0.0.1:0", StatsdFlushInterval: 60}, stor, log)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, l.Start(ctx))
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("requests:5|c\nerrors:2|c\ntemperature:21.5|g"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		l.agg.mu.Lock()
+		defer l.agg.mu.Unlock()
+		return len(l.agg.counters) == 2 && len(l.agg.dirty) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	l.Wait()
+
+	// конфликтующая метрика теряется одна, остальные записываются
+	counter, err := stor.Metric(context.Background(), types.Counter, "errors")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), counter.Value)
+
+	gauge, err := stor.Metric(context.Background(), types.Gauge, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, gauge.Value)
+
+	gauge, err = stor.Metric(context.Background(), types.Gauge, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gauge.Value)
+}
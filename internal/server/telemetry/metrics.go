@@ -0,0 +1,33 @@
+package telemetry
+
+var (
+	// DurationBuckets - границы бакетов длительности операций в секундах
+	DurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	// SizeBuckets - границы бакетов размера пакетов метрик
+	SizeBuckets = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+)
+
+var (
+	HTTPRequests = NewCounterVec("metriq_http_requests_total",
+		"Number of HTTP requests by route, method and status code.", "route", "method", "status")
+	HTTPDuration = NewHistogramVec("metriq_http_request_duration_seconds",
+		"Duration of HTTP requests by route and method.", DurationBuckets, "route", "method")
+
+	DecodeErrors = NewCounterVec("metriq_decode_errors_total",
+		"Number of request bodies that failed to decode by format: gzip, json, protobuf, decrypt.", "format")
+
+	StorageDuration = NewHistogramVec("metriq_storage_operation_duration_seconds",
+		"Duration of storage operations by operation.", DurationBuckets, "operation")
+	StorageErrors = NewCounterVec("metriq_storage_errors_total",
+		"Number of failed storage operations by operation.", "operation")
+	BatchSize = NewHistogramVec("metriq_storage_batch_size",
+		"Number of metrics written to the storage in one batch.", SizeBuckets)
+
+	BackupDuration = NewHistogramVec("metriq_backup_duration_seconds",
+		"Duration of saving the backup file.", DurationBuckets)
+	BackupSize = NewGaugeVec("metriq_backup_size_bytes",
+		"Size of the last saved backup file.")
+	BackupErrors = NewCounterVec("metriq_backup_errors_total",
+		"Number of failed backups.")
+)
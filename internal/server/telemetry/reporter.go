@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// unsafeRunes - последовательности символов значений меток, не допустимых в имени метрики
+var unsafeRunes = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Reporter periodically writes the metrics of the registry into the repository.
+// Every sample becomes a gauge named prefix + metric name + .label.value for
+// every label, with the characters of the values other than letters, digits, _
+// and - replaced by _, so the names are plain URL path segments. Histograms are
+// written as their _sum and _count. The names go through the name policy
+// like the names of the clients, the ones it rejects are not written.
+type Reporter struct {
+	reg    *Registry
+	stor   storage.Repository
+	lg     logger.Logger
+	prefix string
+	names  *types.NamePolicy
+	every  time.Duration
+	wg     sync.WaitGroup
+}
+
+func NewReporter(cfg config.Config, reg *Registry, stor storage.Repository, lg logger.Logger) *Reporter {
+	return &Reporter{
+		reg:    reg,
+		stor:   stor,
+		lg:     lg,
+		prefix: cfg.SelfMetricsPrefix,
+		names:  cfg.Names,
+		every:  time.Duration(cfg.SelfMetricsInterval) * time.Second,
+	}
+}
+
+// Start writes the metrics with the configured interval until the context is canceled.
+func (r *Reporter) Start(ctx context.Context) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.every)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Report(ctx); err != nil {
+					r.lg.Sugar.Infow("error writing server metrics", "error: ", err)
+				}
+			}
+		}
+	}()
+}
+
+// Wait blocks until the reporter has stopped.
+func (r *Reporter) Wait() {
+	r.wg.Wait()
+}
+
+// Report writes the current values of the metrics into the repository.
+func (r *Reporter) Report(ctx context.Context) error {
+	var metrics []models.Metrics
+	for _, c := range r.reg.sorted() {
+		for _, s := range c.samples() {
+			value := s.value
+			metrics = append(metrics, models.Metrics{
+				ID:    r.prefix + s.name + flatLabels(s.labels),
+				MType: types.Gauge,
+				Value: &value,
+			})
+		}
+	}
+
+	metrics, dropped, err := r.names.Filter(metrics)
+	if err != nil {
+		r.lg.Sugar.Infow("server metrics dropped", "dropped", dropped, "error: ", err)
+	}
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return r.stor.SetMetrics(withReserved(ctx), metrics)
+}
+
+// flatLabels formats the label pairs as .name.value for the metric names, a value left empty is _.
+func flatLabels(pairs []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pairs); i += 2 {
+		sb.WriteByte('.')
+		sb.WriteString(pairs[i])
+		sb.WriteByte('.')
+
+		value := strings.Trim(unsafeRunes.ReplaceAllString(pairs[i+1], "_"), "_")
+		if value == "" {
+			value = "_"
+		}
+		sb.WriteString(value)
+	}
+	return sb.String()
+}
@@ -0,0 +1,107 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// ErrReservedName is returned for writes of metrics under the prefix reserved for the server metrics.
+var ErrReservedName = errors.New("the metric name uses the reserved prefix")
+
+type reservedKey struct{}
+
+// Storage measures the operations of the wrapped repository and rejects
+// client writes under the reserved prefix, if it is set.
+type Storage struct {
+	storage.Repository
+
+	reserved string
+}
+
+// NewStorage wraps the repository, an empty prefix reserves nothing.
+func NewStorage(repo storage.Repository, reservedPrefix string) *Storage {
+	return &Storage{Repository: repo, reserved: reservedPrefix}
+}
+
+// withReserved marks the context of the writes of the server metrics.
+func withReserved(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reservedKey{}, true)
+}
+
+// CheckName returns ErrReservedName if the name uses the reserved prefix.
+func (s *Storage) CheckName(ctx context.Context, mName string) error {
+	if s.reserved == "" || !strings.HasPrefix(mName, s.reserved) {
+		return nil
+	}
+	if allowed, _ := ctx.Value(reservedKey{}).(bool); allowed {
+		return nil
+	}
+	return fmt.Errorf("%w %q: %s", ErrReservedName, s.reserved, mName)
+}
+
+func (s *Storage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	for _, m := range metrics {
+		if err := s.CheckName(ctx, m.ID); err != nil {
+			return err
+		}
+	}
+
+	BatchSize.Observe(float64(len(metrics)))
+	return observe("set_metrics", func() error { return s.Repository.SetMetrics(ctx, metrics) })
+}
+
+func (s *Storage) SetMetric(ctx context.Context, mName string, metric types.Metric) error {
+	if err := s.CheckName(ctx, mName); err != nil {
+		return err
+	}
+
+	return observe("set_metric", func() error { return s.Repository.SetMetric(ctx, mName, metric) })
+}
+
+func (s *Storage) Metric(ctx context.Context, mType, mName string) (types.Metric, error) {
+	var metric types.Metric
+	err := observe("metric", func() (err error) {
+		metric, err = s.Repository.Metric(ctx, mType, mName)
+		return err
+	})
+	return metric, err
+}
+
+func (s *Storage) Metrics(ctx context.Context) (map[string]types.Metric, error) {
+	var metrics map[string]types.Metric
+	err := observe("metrics", func() (err error) {
+		metrics, err = s.Repository.Metrics(ctx)
+		return err
+	})
+	return metrics, err
+}
+
+func (s *Storage) MetricsOf(ctx context.Context, mNames []string) (map[string]types.Metric, error) {
+	var metrics map[string]types.Metric
+	err := observe("metrics_of", func() (err error) {
+		metrics, err = s.Repository.MetricsOf(ctx, mNames)
+		return err
+	})
+	return metrics, err
+}
+
+func (s *Storage) Ping(ctx context.Context) error {
+	return observe("ping", func() error { return s.Repository.Ping(ctx) })
+}
+
+func observe(operation string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	StorageDuration.Observe(time.Since(start).Seconds(), operation)
+	if err != nil {
+		StorageErrors.Inc(operation)
+	}
+	return err
+}
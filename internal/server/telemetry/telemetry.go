@@ -0,0 +1,301 @@
+// Package telemetry collects the metrics of the metriq server itself: HTTP
+// requests, decode and decrypt failures, storage operations and backups.
+// The metrics are kept in a Registry and exposed in the Prometheus text format
+// on /internal/metrics; the Reporter can also write them into the server's own
+// repository under a reserved prefix. The package has no external dependencies,
+// it implements only the counter, gauge and histogram types the server needs.
+package telemetry
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry of the server metrics declared in this package.
+var Default = NewRegistry()
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+	samples() []sample
+}
+
+// sample is a single value of a metric with its label pairs.
+type sample struct {
+	name   string
+	labels []string // пары имя, значение
+	value  float64
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+func (r *Registry) sorted() []collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	collectors := slices.Clone(r.collectors)
+	slices.SortFunc(collectors, func(a, b collector) int { return strings.Compare(a.name(), b.name()) })
+	return collectors
+}
+
+// WritePrometheus writes all metrics of the registry in the Prometheus text exposition format.
+func (r *Registry) WritePrometheus(w io.Writer) {
+	for _, c := range r.sorted() {
+		c.write(w)
+	}
+}
+
+type metric struct {
+	metricName string
+	help       string
+	labelNames []string
+}
+
+func (m metric) name() string {
+	return m.metricName
+}
+
+func (m metric) header(w io.Writer, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.metricName, m.help, m.metricName, typ)
+}
+
+// key joins the label values into a map key, the values must match the label names.
+func (m metric) key(values []string) string {
+	if len(values) != len(m.labelNames) {
+		panic(fmt.Sprintf("telemetry: %s expects %d label values, got %d", m.metricName, len(m.labelNames), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (m metric) pairs(key string) []string {
+	if len(m.labelNames) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, 2*len(values))
+	for i, v := range values {
+		pairs = append(pairs, m.labelNames[i], v)
+	}
+	return pairs
+}
+
+// CounterVec is a set of monotonically increasing counters partitioned by labels.
+type CounterVec struct {
+	metric
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter and registers it in the Default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{metric: metric{name, help, labelNames}, values: make(map[string]float64)}
+	Default.register(c)
+	return c
+}
+
+// Inc increments the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds the value to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := c.key(labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w, "counter")
+	for _, s := range c.samples() {
+		writeSample(w, s)
+	}
+}
+
+func (c *CounterVec) samples() []sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return valueSamples(c.metric, c.values)
+}
+
+// GaugeVec is a set of values that can go up and down partitioned by labels.
+type GaugeVec struct {
+	metric
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec creates a gauge and registers it in the Default registry.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	g := &GaugeVec{metric: metric{name, help, labelNames}, values: make(map[string]float64)}
+	Default.register(g)
+	return g
+}
+
+// Set sets the gauge with the given label values.
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	key := g.key(labelValues)
+	g.mu.Lock()
+	g.values[key] = v
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w, "gauge")
+	for _, s := range g.samples() {
+		writeSample(w, s)
+	}
+}
+
+func (g *GaugeVec) samples() []sample {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return valueSamples(g.metric, g.values)
+}
+
+func valueSamples(m metric, values map[string]float64) []sample {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	samples := make([]sample, 0, len(keys))
+	for _, k := range keys {
+		samples = append(samples, sample{name: m.metricName, labels: m.pairs(k), value: values[k]})
+	}
+	return samples
+}
+
+// HistogramVec counts observations in cumulative buckets partitioned by labels.
+type HistogramVec struct {
+	metric
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // количество наблюдений в каждом бакете, последний - +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec creates a histogram with the given upper bounds of the buckets
+// and registers it in the Default registry.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{metric: metric{name, help, labelNames}, buckets: buckets, values: make(map[string]*histogram)}
+	Default.register(h)
+	return h
+}
+
+// Observe adds an observation to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := h.key(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = hist
+	}
+
+	i, _ := slices.BinarySearch(h.buckets, v)
+	hist.counts[i]++
+	hist.sum += v
+	hist.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, k := range h.keys() {
+		hist := h.values[k]
+		pairs := h.pairs(k)
+
+		var cumulative uint64
+		for i, count := range hist.counts {
+			cumulative += count
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = strconv.FormatFloat(h.buckets[i], 'g', -1, 64)
+			}
+			writeSample(w, sample{name: h.metricName + "_bucket", labels: append(slices.Clone(pairs), "le", le), value: float64(cumulative)})
+		}
+		writeSample(w, sample{name: h.metricName + "_sum", labels: pairs, value: hist.sum})
+		writeSample(w, sample{name: h.metricName + "_count", labels: pairs, value: float64(hist.count)})
+	}
+}
+
+// samples returns the sum and the count of the histograms, the buckets are only exposed to Prometheus.
+func (h *HistogramVec) samples() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := make([]sample, 0, 2*len(h.values))
+	for _, k := range h.keys() {
+		hist := h.values[k]
+		pairs := h.pairs(k)
+		samples = append(samples,
+			sample{name: h.metricName + "_sum", labels: pairs, value: hist.sum},
+			sample{name: h.metricName + "_count", labels: pairs, value: float64(hist.count)},
+		)
+	}
+	return samples
+}
+
+func (h *HistogramVec) keys() []string {
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func writeSample(w io.Writer, s sample) {
+	fmt.Fprintf(w, "%s%s %s\n", s.name, formatLabels(s.labels), strconv.FormatFloat(s.value, 'g', -1, 64))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels formats the label pairs as {name="value",...}.
+func formatLabels(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
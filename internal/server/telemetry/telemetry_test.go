@@ -0,0 +1,128 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/server/config"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+
+	requests := NewCounterVec("test_requests_total", "Number of requests.", "route", "status")
+	reg.register(requests)
+	requests.Inc("/update", "200")
+	requests.Add(2, "/update", "200")
+	requests.Inc(`/a"b`, "500")
+
+	duration := NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1})
+	reg.register(duration)
+	duration.Observe(0.05)
+	duration.Observe(0.1)
+	duration.Observe(5)
+
+	size := NewGaugeVec("test_size_bytes", "Size.")
+	reg.register(size)
+	size.Set(42)
+
+	var sb strings.Builder
+	reg.WritePrometheus(&sb)
+
+	assert.Equal(t, `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 2
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.15
+test_duration_seconds_count 3
+# HELP test_requests_total Number of requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b",status="500"} 1
+test_requests_total{route="/update",status="200"} 3
+# HELP test_size_bytes Size.
+# TYPE test_size_bytes gauge
+test_size_bytes 42
+`, sb.String())
+}
+
+func TestStorage_ReservedPrefix(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), "_metriq.")
+
+	value := 1.0
+	err := stor.SetMetrics(ctx, []models.Metrics{{ID: "_metriq.requests", MType: types.Gauge, Value: &value}})
+	assert.ErrorIs(t, err, ErrReservedName)
+
+	err = stor.SetMetric(ctx, "_metriq.requests", types.Metric{MetricType: types.Gauge, Value: value})
+	assert.ErrorIs(t, err, ErrReservedName)
+
+	assert.NoError(t, stor.SetMetric(ctx, "requests", types.Metric{MetricType: types.Gauge, Value: value}))
+	assert.NoError(t, stor.SetMetric(withReserved(ctx), "_metriq.requests", types.Metric{MetricType: types.Gauge, Value: value}))
+
+	// nothing is reserved without a prefix
+	assert.NoError(t, NewStorage(mem.NewStorage(), "").SetMetric(ctx, "_metriq.requests", types.Metric{MetricType: types.Gauge, Value: value}))
+}
+
+func TestReporter_Report(t *testing.T) {
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	reg := NewRegistry()
+	requests := NewCounterVec("test_reported_total", "Number of requests.", "route")
+	reg.register(requests)
+	requests.Add(3, "/update")
+	duration := NewHistogramVec("test_reported_seconds", "Duration.", []float64{1})
+	reg.register(duration)
+	duration.Observe(0.5)
+
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), "_metriq.")
+	reporter := NewReporter(config.Config{SelfMetricsPrefix: "_metriq."}, reg, stor, log)
+	require.NoError(t, reporter.Report(ctx))
+
+	metrics, err := stor.Metrics(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, types.Metric{MetricType: types.Gauge, Value: 3.0}, metrics["_metriq.test_reported_total.route.update"])
+	assert.Equal(t, types.Metric{MetricType: types.Gauge, Value: 0.5}, metrics["_metriq.test_reported_seconds_sum"])
+	assert.Equal(t, types.Metric{MetricType: types.Gauge, Value: 1.0}, metrics["_metriq.test_reported_seconds_count"])
+
+	// имена, нарушающие правила имен, не записываются
+	names, err := types.NewNamePolicy(types.NameRules{MaxLength: 36})
+	require.NoError(t, err)
+	stor = NewStorage(mem.NewStorage(), "_metriq.")
+	reporter = NewReporter(config.Config{SelfMetricsPrefix: "_metriq.", Names: names}, reg, stor, log)
+	require.NoError(t, reporter.Report(ctx))
+
+	metrics, err = stor.Metrics(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, metrics, "_metriq.test_reported_total.route.update")
+	assert.Contains(t, metrics, "_metriq.test_reported_seconds_count")
+}
+
+func TestFlatLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels []string
+		want   string
+	}{
+		{name: "No labels", want: ""},
+		{name: "Route", labels: []string{"route", "/update/{metricType}/{metricName}/{metricValue}"}, want: ".route.update_metricType_metricName_metricValue"},
+		{name: "Root route", labels: []string{"route", "/"}, want: ".route._"},
+		{name: "Several labels", labels: []string{"method", "POST", "status", "200"}, want: ".method.POST.status.200"},
+		{name: "Quotes", labels: []string{"format", `"json"`}, want: ".format.json"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, flatLabels(test.labels))
+		})
+	}
+}
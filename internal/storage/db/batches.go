@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/plasmatrip/metriq/internal/server/dedup"
+)
+
+// expireInterval - интервал удаления устаревших идентификаторов пакетов
+const expireInterval = time.Minute
+
+// BatchLog keeps the IDs of applied metric batches in the batches table, so that
+// duplicates are recognized after a restart and by every server sharing the database.
+type BatchLog struct {
+	db  *pgxpool.Pool
+	ttl time.Duration
+
+	mu         sync.Mutex
+	lastExpire time.Time
+}
+
+// Batches returns the log of batch IDs stored for ttl.
+func (ps PostgresStorage) Batches(ttl time.Duration) *BatchLog {
+	return &BatchLog{db: ps.db, ttl: ttl}
+}
+
+// txKey is the context key of the transaction recording the batch ID.
+type txKey struct{}
+
+// Apply records the ID and applies the batch in one transaction, the storage writes made with
+// the context passed to apply join it. A retry of a batch that is being applied waits on the
+// uncommitted row of its ID and is a duplicate if the first attempt commits.
+func (b *BatchLog) Apply(ctx context.Context, id string, apply func(ctx context.Context) error) (bool, error) {
+	if err := b.expire(ctx); err != nil {
+		return false, fmt.Errorf("%w: %w", dedup.ErrStore, err)
+	}
+
+	tx, err := b.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite})
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", dedup.ErrStore, err)
+	}
+	defer tx.Rollback(ctx)
+
+	// устаревшая запись, которую еще не удалили, занимается заново
+	res, err := tx.Exec(ctx, claimBatch, pgx.NamedArgs{"id": id, "ttl": b.ttl.Seconds()})
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", dedup.ErrStore, err)
+	}
+	if res.RowsAffected() != 1 {
+		return false, nil
+	}
+
+	if err := apply(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return true, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return true, fmt.Errorf("%w: %w", dedup.ErrStore, err)
+	}
+	return true, nil
+}
+
+// expire deletes the outdated IDs at most once per expireInterval.
+func (b *BatchLog) expire(ctx context.Context) error {
+	b.mu.Lock()
+	if time.Since(b.lastExpire) < expireInterval {
+		b.mu.Unlock()
+		return nil
+	}
+	b.lastExpire = time.Now()
+	b.mu.Unlock()
+
+	_, err := b.db.Exec(ctx, expireBatches, pgx.NamedArgs{"ttl": b.ttl.Seconds()})
+	return err
+}
@@ -0,0 +1,10 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS batches (
+			id VARCHAR(128) NOT NULL PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+CREATE INDEX IF NOT EXISTS batches_applied_at_idx ON batches (applied_at);
+
+COMMIT;
\ No newline at end of file
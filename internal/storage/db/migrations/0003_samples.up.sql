@@ -0,0 +1,13 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS samples (
+			id VARCHAR(128) NOT NULL,
+			mType VARCHAR(128) NOT NULL,
+			value DOUBLE PRECISION NOT NULL,
+			ts TIMESTAMPTZ NOT NULL DEFAULT now()
+		);
+
+CREATE INDEX IF NOT EXISTS samples_id_ts_idx ON samples (id, ts);
+CREATE INDEX IF NOT EXISTS samples_ts_idx ON samples (ts);
+
+COMMIT;
\ No newline at end of file
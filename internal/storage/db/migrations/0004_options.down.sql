@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP TABLE IF EXISTS options;
+
+COMMIT;
\ No newline at end of file
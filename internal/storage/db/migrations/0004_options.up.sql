@@ -0,0 +1,8 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS options (
+			name VARCHAR(128) NOT NULL PRIMARY KEY,
+			value VARCHAR(128) NOT NULL
+		);
+
+COMMIT;
\ No newline at end of file
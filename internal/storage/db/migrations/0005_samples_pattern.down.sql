@@ -0,0 +1,5 @@
+BEGIN;
+
+DROP INDEX IF EXISTS samples_id_pattern_idx;
+
+COMMIT;
\ No newline at end of file
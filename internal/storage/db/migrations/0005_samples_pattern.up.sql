@@ -0,0 +1,5 @@
+BEGIN;
+
+CREATE INDEX IF NOT EXISTS samples_id_pattern_idx ON samples (id text_pattern_ops, ts);
+
+COMMIT;
\ No newline at end of file
@@ -17,9 +17,12 @@ import (
 	"github.com/plasmatrip/metriq/internal/types"
 )
 
+// PostgresStorage keeps the metrics in the metrics table under the keys of types.MetricKey.
 type PostgresStorage struct {
-	db *pgxpool.Pool
-	lg logger.Logger
+	db       *pgxpool.Pool
+	lg       logger.Logger
+	samples  *sampleLog
+	conflict string
 }
 
 func NewPostgresStorage(ctx context.Context, dsn string, lg logger.Logger) (*PostgresStorage, error) {
@@ -42,8 +45,9 @@ func NewPostgresStorage(ctx context.Context, dsn string, lg logger.Logger) (*Pos
 	}
 
 	ps := &PostgresStorage{
-		db: db,
-		lg: lg,
+		db:      db,
+		lg:      lg,
+		samples: &sampleLog{},
 	}
 
 	// // создаем таблицу, при ошибке прокидываем ее наверх
@@ -94,9 +98,132 @@ func (ps PostgresStorage) Close() {
 	ps.db.Close()
 }
 
+// SetTypeConflict sets what a write of a metric under a name that holds a metric of another type does,
+// one of the types.Conflict policies. By default such a write is rejected with types.ErrTypeConflict.
+func (ps *PostgresStorage) SetTypeConflict(conflict string) {
+	ps.conflict = conflict
+}
+
+// typeConflictOption is the option the type conflict policy the keys are stored by is kept under.
+const typeConflictOption = "type_conflict"
+
+// MigrateKeys brings the keys of the stored metrics to the type conflict policy set by
+// SetTypeConflict, when the database was written by another one: a switch to or from namespace
+// rewrites name to type/name or back. A database with a name that holds metrics of both types can't
+// be switched from namespace, nothing is then rewritten and an error is returned. The policy of a
+// database written before it was recorded is told by its keys. The samples are kept under the name
+// and the type whatever the policy and are left as they are.
+func (ps *PostgresStorage) MigrateKeys(ctx context.Context) error {
+	tx, err := ps.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var stored string
+	err = tx.QueryRow(ctx, selectOption, pgx.NamedArgs{"name": typeConflictOption}).Scan(&stored)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var total, prefixed int
+		if err := tx.QueryRow(ctx, countTypeKeys).Scan(&total, &prefixed); err != nil {
+			return err
+		}
+		stored = types.ConflictReject
+		if total > 0 && prefixed == total {
+			stored = types.ConflictNamespace
+		}
+	} else if err != nil {
+		return err
+	}
+
+	namespace := ps.conflict == types.ConflictNamespace
+	if namespace != (stored == types.ConflictNamespace) {
+		migration := prefixTypeKeys
+		if !namespace {
+			var name string
+			err := tx.QueryRow(ctx, findSharedName).Scan(&name)
+			if err == nil {
+				return fmt.Errorf("%w: %s is stored as a gauge and a counter, the %s policy can't keep both", types.ErrTypeConflict, name, ps.conflict)
+			}
+			if !errors.Is(err, pgx.ErrNoRows) {
+				return err
+			}
+			migration = stripTypeKeys
+		}
+		if _, err := tx.Exec(ctx, migration); err != nil {
+			return fmt.Errorf("failed to rewrite the keys from the %s policy to %s: %w", stored, ps.conflict, err)
+		}
+		ps.lg.Sugar.Infow("the metric keys are rewritten", "from", stored, "to", ps.conflict)
+	}
+
+	conflict := ps.conflict
+	if conflict == "" {
+		conflict = types.ConflictReject
+	}
+	if _, err := tx.Exec(ctx, upsertOption, pgx.NamedArgs{"name": typeConflictOption, "value": conflict}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// upsert returns the query writing a metric of the type by the type conflict policy.
+func (ps PostgresStorage) upsert(mType string) string {
+	overwrite := ps.conflict == types.ConflictOverwrite
+	switch {
+	case mType == types.Gauge && overwrite:
+		return overwriteGauge
+	case mType == types.Gauge:
+		return insertGauge
+	case overwrite:
+		return overwriteCounter
+	}
+	return insertCounter
+}
+
+// write writes the metric with the connection or the transaction and records its sample.
+// A metric of another type under the name leaves no row affected and is reported as a conflict.
+func (ps PostgresStorage) write(ctx context.Context, db execer, mName, mType string, value any) error {
+	args := pgx.NamedArgs{
+		"id":    types.MetricKey(ps.conflict, mType, mName),
+		"mType": mType,
+	}
+	if mType == types.Gauge {
+		args["value"] = value
+	} else {
+		args["delta"] = value
+	}
+
+	res, err := db.Exec(ctx, ps.upsert(mType), args)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", types.ErrTypeConflict, mName)
+	}
+
+	return ps.recordSample(ctx, db, mName, mType, value)
+}
+
+// pollCount increments PollCount after a gauge, unless the name holds a gauge:
+// the write of the gauge does not fail because of it.
+func (ps PostgresStorage) pollCount(ctx context.Context, db execer) error {
+	err := ps.write(ctx, db, types.PollCount, types.Counter, 1)
+	if errors.Is(err, types.ErrTypeConflict) {
+		return nil
+	}
+	return err
+}
+
 func (ps PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
-	// начинаем транзакцию
-	tx, err := ps.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite})
+	ps.expire(ctx)
+
+	// начинаем транзакцию, внутри транзакции пакета с идентификатором - точку сохранения
+	var tx pgx.Tx
+	var err error
+	if outer, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		tx, err = outer.Begin(ctx)
+	} else {
+		tx, err = ps.db.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.ReadCommitted, AccessMode: pgx.ReadWrite})
+	}
 	if err != nil {
 		return err
 	}
@@ -106,35 +233,22 @@ func (ps PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metri
 		return tx.Rollback(ctx)
 	}()
 
-	// итерируемся по метрикам
+	// итерируемся по метрикам, при конфликте типов или метрике без значения откатывается весь пакет
 	for _, metric := range metrics {
+		if err = types.CheckFields(metric); err != nil {
+			return err
+		}
 		switch metric.MType {
 		case types.Gauge:
-			// пытаемся обновить метрику в БД, при ошибке прокидываем ее наверх
-			_, err = tx.Exec(ctx, insertGauge, pgx.NamedArgs{
-				"id":    metric.ID,
-				"mType": metric.MType,
-				"value": metric.Value,
-			})
-			if err != nil {
+			if err = ps.write(ctx, tx, metric.ID, metric.MType, metric.Value); err != nil {
 				return err
 			}
 			// т.к. пришел тип gauge, увеличиваем PollCounter на 1
-			_, err = tx.Exec(ctx, insertCounter, pgx.NamedArgs{
-				"id":    types.PollCount,
-				"mType": types.Counter,
-				"delta": 1,
-			})
-			if err != nil {
+			if err = ps.pollCount(ctx, tx); err != nil {
 				return err
 			}
 		case types.Counter:
-			_, err = tx.Exec(ctx, insertCounter, pgx.NamedArgs{
-				"id":    metric.ID,
-				"mType": metric.MType,
-				"delta": metric.Delta,
-			})
-			if err != nil {
+			if err = ps.write(ctx, tx, metric.ID, metric.MType, metric.Delta); err != nil {
 				return err
 			}
 		}
@@ -150,37 +264,28 @@ func (ps PostgresStorage) SetMetrics(ctx context.Context, metrics []models.Metri
 }
 
 func (ps PostgresStorage) SetMetric(ctx context.Context, id string, metric types.Metric) error {
+	ps.expire(ctx)
+
+	// проверяем метрику (тип и значение)
+	if err := metric.Check(); err != nil {
+		return err
+	}
+
+	db := ps.conn(ctx)
+
 	// определяем тип пришедшей метрики
 	switch metric.MetricType {
 	case types.Gauge:
-		// проверяем метрику (тип и значение)
-		if err := metric.Check(); err != nil {
-			return err
-		}
-
-		// пытаемся обновить метрику в БД, при ошибке прокидываем ее наверх
-		res, err := ps.db.Exec(ctx, insertGauge,
-			pgx.NamedArgs{
-				"id":    id,
-				"mType": metric.MetricType,
-				"value": metric.Value,
-			})
-		if err != nil {
+		if err := ps.write(ctx, db, id, metric.MetricType, metric.Value); err != nil {
 			return err
 		}
-		rows := res.RowsAffected()
-		if rows == 0 {
-			return errors.New("zero rows inserted")
-		}
 
 		// т.к. пришел тип gauge, увеличиваем PollCounter на 1
-		err = ps.setCounter(ctx, types.PollCount, types.Metric{MetricType: types.Counter, Value: 1})
-		if err != nil {
+		if err := ps.pollCount(ctx, db); err != nil {
 			return err
 		}
 	case types.Counter:
-		err := ps.setCounter(ctx, id, metric)
-		if err != nil {
+		if err := ps.write(ctx, db, id, metric.MetricType, metric.Value); err != nil {
 			return err
 		}
 	}
@@ -188,31 +293,34 @@ func (ps PostgresStorage) SetMetric(ctx context.Context, id string, metric types
 	return nil
 }
 
-func (ps PostgresStorage) setCounter(ctx context.Context, id string, metric types.Metric) error {
-	// пытаемся обновить метрику в БД, при ошибке прокидываем ее наверх
-	res, err := ps.db.Exec(ctx, insertCounter,
-		pgx.NamedArgs{
-			"id":    id,
-			"mType": metric.MetricType,
-			"delta": metric.Value,
-		})
-	if err != nil {
-		return err
+// Metric returns the metric of the type stored under the name, an empty type finds a metric of any type,
+// the gauge first if each type has its own names.
+func (ps PostgresStorage) Metric(ctx context.Context, mType, id string) (types.Metric, error) {
+	mTypes := []string{mType}
+	if mType == "" && ps.conflict == types.ConflictNamespace {
+		mTypes = []string{types.Gauge, types.Counter}
 	}
 
-	rows := res.RowsAffected()
-	if rows == 0 {
-		return errors.New("zero rows inserted")
+	var err error
+	for _, t := range mTypes {
+		var metric types.Metric
+		metric, err = ps.metric(ctx, types.MetricKey(ps.conflict, t, id))
+		if err == nil && (t == "" || metric.MetricType == t) {
+			return metric, nil
+		}
+		if err == nil {
+			err = pgx.ErrNoRows
+		}
 	}
-
-	return nil
+	return types.Metric{}, err
 }
 
-func (ps PostgresStorage) Metric(ctx context.Context, id string) (types.Metric, error) {
+// metric reads the metric stored under the key.
+func (ps PostgresStorage) metric(ctx context.Context, key string) (types.Metric, error) {
 	m := models.Metrics{}
 
 	// делаем запрос в БД
-	row := ps.db.QueryRow(ctx, "SELECT * FROM metrics WHERE id = @id", pgx.NamedArgs{"id": id})
+	row := ps.conn(ctx).QueryRow(ctx, "SELECT * FROM metrics WHERE id = @id", pgx.NamedArgs{"id": key})
 
 	// читаем результат в структуру models.Metrics, при ошибке прокидываем ее наверх
 	err := row.Scan(&m.ID, &m.MType, &m.Value, &m.Delta)
@@ -234,11 +342,24 @@ func (ps PostgresStorage) Metric(ctx context.Context, id string) (types.Metric,
 }
 
 func (ps PostgresStorage) Metrics(ctx context.Context) (map[string]types.Metric, error) {
+	return ps.metrics(ctx, "SELECT * FROM metrics")
+}
+
+func (ps PostgresStorage) MetricsOf(ctx context.Context, mNames []string) (map[string]types.Metric, error) {
+	keys := make([]string, 0, len(mNames))
+	for _, name := range mNames {
+		keys = append(keys, types.MetricKeys(ps.conflict, name)...)
+	}
+	return ps.metrics(ctx, "SELECT * FROM metrics WHERE id = ANY(@ids)", pgx.NamedArgs{"ids": keys})
+}
+
+// metrics reads the metrics selected by the query under their keys.
+func (ps PostgresStorage) metrics(ctx context.Context, sql string, args ...any) (map[string]types.Metric, error) {
 	// создаем мапу для записи результата
 	metrics := make(map[string]types.Metric, 0)
 
 	// делаем запрос в БД
-	rows, err := ps.db.Query(ctx, "SELECT * FROM metrics")
+	rows, err := ps.conn(ctx).Query(ctx, sql, args...)
 
 	// при ошибке прокидываем ее наверх
 	if err != nil {
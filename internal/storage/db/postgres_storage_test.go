@@ -0,0 +1,72 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/plasmatrip/metriq/internal/logger"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// newTestStorage connects to the database of TEST_DATABASE_DSN and empties it, the test is skipped without it.
+func newTestStorage(t *testing.T, conflict string) *PostgresStorage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	log, err := logger.NewLogger()
+	require.NoError(t, err)
+
+	ps, err := NewPostgresStorage(context.Background(), dsn, log)
+	require.NoError(t, err)
+	t.Cleanup(ps.Close)
+
+	ps.SetSampleRetention(time.Hour)
+	ps.SetTypeConflict(conflict)
+	return ps
+}
+
+func TestPostgresStorage_MigrateKeys(t *testing.T) {
+	ctx := context.Background()
+	ps := newTestStorage(t, types.ConflictReject)
+	_, err := ps.db.Exec(ctx, `TRUNCATE metrics, samples, options`)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, ps.MigrateKeys(ctx))
+	require.NoError(t, ps.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: 0.5}))
+
+	// у каждого типа свои имена: ключ метрики переписывается, значения остаются одним рядом
+	ps.SetTypeConflict(types.ConflictNamespace)
+	require.NoError(t, ps.MigrateKeys(ctx))
+	require.NoError(t, ps.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: 1.5}))
+
+	metric, err := ps.Metric(ctx, types.Gauge, "load")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, metric.Value)
+
+	q := storage.Query{Selector: "load", From: start.Add(-time.Second), To: time.Now(), Aggregation: storage.AggCount}
+	results, err := ps.Query(ctx, q)
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Aggregate{{ID: "load", MType: types.Gauge, Value: 2, Samples: 2}}, results)
+
+	// обратно к общим именам, пока под именем нет метрик обоих типов
+	ps.SetTypeConflict(types.ConflictReject)
+	require.NoError(t, ps.MigrateKeys(ctx))
+	metric, err = ps.Metric(ctx, types.Gauge, "load")
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, metric.Value)
+
+	ps.SetTypeConflict(types.ConflictNamespace)
+	require.NoError(t, ps.MigrateKeys(ctx))
+	require.NoError(t, ps.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+	ps.SetTypeConflict(types.ConflictReject)
+	assert.ErrorIs(t, ps.MigrateKeys(ctx), types.ErrTypeConflict)
+}
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// aggregations - выражения SQL для агрегаций запроса, counter записан приращениями, gauge значениями
+var aggregations = map[storage.Aggregation]string{
+	storage.AggSum:   "sum(value)",
+	storage.AggAvg:   "avg(value)",
+	storage.AggMin:   "min(value)",
+	storage.AggMax:   "max(value)",
+	storage.AggCount: "count(*)::DOUBLE PRECISION",
+	storage.AggLast:  "(array_agg(value ORDER BY ts DESC))[1]",
+	storage.AggRate: `CASE WHEN mType = '` + types.Counter + `' THEN sum(value) / @window
+		ELSE COALESCE(((array_agg(value ORDER BY ts DESC))[1] - (array_agg(value ORDER BY ts))[1])
+			/ NULLIF(EXTRACT(EPOCH FROM max(ts) - min(ts))::DOUBLE PRECISION, 0), 0) END`,
+	storage.AggP95: "percentile_cont(0.95) WITHIN GROUP (ORDER BY value)",
+}
+
+// sampleLog keeps the written values of metrics in the samples table for the aggregation queries.
+type sampleLog struct {
+	mu         sync.Mutex
+	retention  time.Duration
+	lastExpire time.Time
+}
+
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// querier is the pool or the transaction of a batch applied with its ID.
+type querier interface {
+	execer
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// conn returns the transaction of the batch being applied with its ID or the pool.
+func (ps PostgresStorage) conn(ctx context.Context) querier {
+	if tx, ok := ctx.Value(txKey{}).(pgx.Tx); ok {
+		return tx
+	}
+	return ps.db
+}
+
+// SetSampleRetention makes the storage keep the values written within the retention period
+// for the aggregation queries. Without it no values are kept and the queries find nothing.
+func (ps PostgresStorage) SetSampleRetention(retention time.Duration) {
+	ps.samples.mu.Lock()
+	defer ps.samples.mu.Unlock()
+	ps.samples.retention = retention
+}
+
+// recordSample writes the value of a gauge or the increment of a counter with the connection or the transaction.
+func (ps PostgresStorage) recordSample(ctx context.Context, db execer, id, mType string, value any) error {
+	ps.samples.mu.Lock()
+	retention := ps.samples.retention
+	ps.samples.mu.Unlock()
+
+	if retention <= 0 {
+		return nil
+	}
+
+	_, err := db.Exec(ctx, insertSample, pgx.NamedArgs{
+		"id":    id,
+		"mType": mType,
+		"value": value,
+		"ts":    time.Now(),
+	})
+	return err
+}
+
+// expire deletes the values older than the retention period at most once per expireInterval.
+// A failure does not prevent the write, the values are deleted on the next attempt.
+func (ps PostgresStorage) expire(ctx context.Context) {
+	ps.samples.mu.Lock()
+	if ps.samples.retention <= 0 || time.Since(ps.samples.lastExpire) < expireInterval {
+		ps.samples.mu.Unlock()
+		return
+	}
+	ps.samples.lastExpire = time.Now()
+	before := time.Now().Add(-ps.samples.retention)
+	ps.samples.mu.Unlock()
+
+	if _, err := ps.db.Exec(ctx, expireSamples, pgx.NamedArgs{"before": before}); err != nil {
+		ps.lg.Sugar.Infow("failed to delete outdated samples", "error", err)
+	}
+}
+
+// Query aggregates the values of the selected metrics written within the window of the query.
+func (ps PostgresStorage) Query(ctx context.Context, q storage.Query) ([]storage.Aggregate, error) {
+	expr, ok := aggregations[q.Aggregation]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation %q", q.Aggregation)
+	}
+
+	rows, err := ps.db.Query(ctx, fmt.Sprintf(queryAggregate, expr), pgx.NamedArgs{
+		"pattern": storage.LikePattern(q.Selector),
+		"from":    q.From,
+		"to":      q.To,
+		"window":  q.To.Sub(q.From).Seconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]storage.Aggregate, 0)
+	for rows.Next() {
+		var a storage.Aggregate
+		if err := rows.Scan(&a.ID, &a.MType, &a.Samples, &a.Value); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
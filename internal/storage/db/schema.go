@@ -10,15 +10,91 @@ const (
 		);
 	`
 
+	// insertGauge и insertCounter не меняют метрику другого типа, тогда ни одна строка не затронута
 	insertGauge = `
 		INSERT INTO metrics (id, mType, value) VALUES (@id, @mType, @value)
 		ON CONFLICT (id)
-		DO UPDATE SET value = @value
+		DO UPDATE SET value = @value WHERE metrics.mType = @mType
 	`
 
 	insertCounter = `
 		INSERT INTO metrics (id, mType, delta) VALUES (@id, @mType, @delta)
 		ON CONFLICT (id)
-		DO UPDATE SET delta = metrics.delta + @delta
+		DO UPDATE SET delta = metrics.delta + @delta WHERE metrics.mType = @mType
+	`
+
+	// overwriteGauge и overwriteCounter заменяют метрику другого типа, counter начинается заново
+	overwriteGauge = `
+		INSERT INTO metrics (id, mType, value) VALUES (@id, @mType, @value)
+		ON CONFLICT (id)
+		DO UPDATE SET mType = @mType, value = @value, delta = NULL
+	`
+
+	overwriteCounter = `
+		INSERT INTO metrics (id, mType, delta) VALUES (@id, @mType, @delta)
+		ON CONFLICT (id)
+		DO UPDATE SET delta = CASE WHEN metrics.mType = @mType THEN metrics.delta + @delta ELSE @delta END,
+			mType = @mType, value = NULL
+	`
+
+	selectOption = `
+		SELECT value FROM options WHERE name = @name FOR UPDATE
+	`
+
+	upsertOption = `
+		INSERT INTO options (name, value) VALUES (@name, @value)
+		ON CONFLICT (name)
+		DO UPDATE SET value = @value
+	`
+
+	// countTypeKeys - число метрик и метрик с ключом type/name, по нему узнается политика базы без записанной политики
+	countTypeKeys = `
+		SELECT count(*), count(*) FILTER (WHERE starts_with(id, mType || '/')) FROM metrics
+	`
+
+	// findSharedName - имя, под которым хранятся метрики обоих типов, его нельзя оставить одним ключом
+	findSharedName = `
+		SELECT substr(id, length(mType) + 2) AS name FROM metrics
+		WHERE starts_with(id, mType || '/')
+		GROUP BY name HAVING count(*) > 1
+		LIMIT 1
+	`
+
+	// prefixTypeKeys и stripTypeKeys переводят ключи метрик между name и type/name,
+	// значения хранятся под именем с типом при любой политике и не меняются
+	prefixTypeKeys = `
+		UPDATE metrics SET id = mType || '/' || id
+	`
+
+	stripTypeKeys = `
+		UPDATE metrics SET id = substr(id, length(mType) + 2) WHERE starts_with(id, mType || '/')
+	`
+
+	claimBatch = `
+		INSERT INTO batches (id, applied_at) VALUES (@id, now())
+		ON CONFLICT (id)
+		DO UPDATE SET applied_at = now() WHERE batches.applied_at < now() - make_interval(secs => @ttl)
+	`
+
+	expireBatches = `
+		DELETE FROM batches WHERE applied_at < now() - make_interval(secs => @ttl)
+	`
+
+	insertSample = `
+		INSERT INTO samples (id, mType, value, ts) VALUES (@id, @mType, @value, @ts)
+	`
+
+	expireSamples = `
+		DELETE FROM samples WHERE ts <= @before
+	`
+
+	// queryAggregate - агрегация значений выбранных метрик за окно, выражение агрегации подставляется из aggregations,
+	// селектор с постоянным началом ищется по индексу samples_id_pattern_idx
+	queryAggregate = `
+		SELECT id, mType, count(*), %s
+		FROM samples
+		WHERE id LIKE @pattern ESCAPE '\' AND ts > @from AND ts <= @to
+		GROUP BY id, mType
+		ORDER BY id
 	`
 )
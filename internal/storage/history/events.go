@@ -0,0 +1,87 @@
+package history
+
+import (
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+const (
+	// EventBufferSize - количество последних событий, доступных для возобновления потока
+	EventBufferSize = 1000
+
+	// subscriberBuffer - размер очереди событий подписчика
+	subscriberBuffer = 64
+)
+
+// Event is a write of a metric. The metric holds the value after the write,
+// counters are published as totals. IDs grow monotonically across restarts:
+// they continue from the time the storage was created in nanoseconds, so the
+// IDs a client received from an earlier process are below the current ones.
+type Event struct {
+	ID     uint64
+	Time   time.Time
+	Metric models.Metrics
+}
+
+type subscriber struct {
+	c chan Event
+}
+
+// Subscribe registers a subscriber of the write events. It returns the buffered
+// events with an ID greater than lastID, the channel of the following events and
+// the function to cancel the subscription. An ID above the last published one was
+// not issued by this process, with a clock set back, all the buffered events are
+// returned for it. A subscriber that falls behind by more
+// than its queue is dropped and its channel is closed, it is expected to subscribe
+// again with the ID of the last event received.
+func (s *Storage) Subscribe(lastID uint64) ([]Event, <-chan Event, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if lastID > s.lastEventID {
+		lastID = 0
+	}
+
+	var missed []Event
+	for _, e := range s.events {
+		if e.ID > lastID {
+			missed = append(missed, e)
+		}
+	}
+
+	sub := &subscriber{c: make(chan Event, subscriberBuffer)}
+	s.subscribers[sub] = struct{}{}
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if _, ok := s.subscribers[sub]; ok {
+			delete(s.subscribers, sub)
+			close(sub.c)
+		}
+	}
+
+	return missed, sub.c, cancel
+}
+
+// publish appends the event to the buffer and sends it to the subscribers, s.mu must be held.
+func (s *Storage) publish(metric models.Metrics, t time.Time) {
+	s.lastEventID++
+	e := Event{ID: s.lastEventID, Time: t, Metric: metric}
+
+	s.events = append(s.events, e)
+	if len(s.events) > EventBufferSize {
+		s.events = s.events[len(s.events)-EventBufferSize:]
+	}
+
+	for sub := range s.subscribers {
+		select {
+		case sub.c <- e:
+		default:
+			delete(s.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
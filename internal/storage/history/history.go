@@ -0,0 +1,161 @@
+// Package history keeps the recent values of metrics in memory. Storage wraps
+// a repository and, after every successful write, records the resulting value of
+// each written metric with the time of the update. The dashboard uses it to show
+// the time of the last update and a chart of the recent values; the wrapped
+// repository remains the only source of the current values.
+// Every recorded value is also published as an Event to the subscribers of the
+// live update stream, the last events are kept to let them resume after a reconnect.
+// At most DefaultSeries metrics are kept, the one updated least recently is
+// forgotten to make room for a new one.
+package history
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/storage"
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+const (
+	// DefaultSize - количество хранимых последних значений каждой метрики
+	DefaultSize = 100
+	// DefaultSeries - количество метрик, значения которых хранятся
+	DefaultSeries = 10000
+)
+
+// Point is a value of a metric at the time of an update.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+type Storage struct {
+	storage.Repository
+
+	mu        sync.RWMutex
+	size      int
+	maxSeries int
+	series    map[string][]Point
+
+	events      []Event
+	lastEventID uint64
+	subscribers map[*subscriber]struct{}
+}
+
+// NewStorage wraps the repository and keeps up to size recent values of every metric.
+func NewStorage(repo storage.Repository, size int) *Storage {
+	return &Storage{
+		Repository: repo,
+		size:       size,
+		maxSeries:  DefaultSeries,
+		series:     make(map[string][]Point),
+
+		// идентификаторы событий продолжаются со времени запуска, а не с нуля
+		lastEventID: uint64(time.Now().UnixNano()),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (s *Storage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if err := s.Repository.SetMetrics(ctx, metrics); err != nil {
+		return err
+	}
+
+	// a single metric is read back on its own, a batch with one query for the metrics of the batch
+	if len(metrics) == 1 {
+		s.recordOne(ctx, metrics[0].MType, metrics[0].ID)
+		return nil
+	}
+
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.ID)
+	}
+	stored, err := s.Repository.MetricsOf(ctx, names)
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	for _, metric := range metrics {
+		if value, ok := types.LookupMetric(stored, metric.MType, metric.ID); ok {
+			s.record(metric.ID, value, now)
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) SetMetric(ctx context.Context, mName string, metric types.Metric) error {
+	if err := s.Repository.SetMetric(ctx, mName, metric); err != nil {
+		return err
+	}
+
+	s.recordOne(ctx, metric.MetricType, mName)
+
+	return nil
+}
+
+// History returns the recorded values of the metric of the type, the oldest first.
+func (s *Storage) History(mType, mName string) []Point {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]Point(nil), s.series[seriesKey(mType, mName)]...)
+}
+
+// seriesKey keeps the values of the metrics of different types apart whatever the type conflict policy.
+func seriesKey(mType, mName string) string {
+	return mType + "/" + mName
+}
+
+// recordOne reads back the stored value, so that counters are recorded as totals, not deltas.
+func (s *Storage) recordOne(ctx context.Context, mType, mName string) {
+	metric, err := s.Repository.Metric(ctx, mType, mName)
+	if err != nil {
+		return
+	}
+	s.record(mName, metric, time.Now())
+}
+
+func (s *Storage) record(mName string, metric types.Metric, t time.Time) {
+	var value float64
+	switch v := metric.Value.(type) {
+	case float64:
+		value = v
+	case int64:
+		value = float64(v)
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := seriesKey(metric.MetricType, mName)
+	if _, ok := s.series[key]; !ok && len(s.series) >= s.maxSeries {
+		s.evict()
+	}
+	points := append(s.series[key], Point{Time: t, Value: value})
+	if len(points) > s.size {
+		points = points[len(points)-s.size:]
+	}
+	s.series[key] = points
+
+	s.publish(metric.Convert(mName), t)
+}
+
+// evict forgets the metric updated least recently, the lock must be held.
+func (s *Storage) evict() {
+	var oldest string
+	var last time.Time
+	for key, points := range s.series {
+		if t := points[len(points)-1].Time; last.IsZero() || t.Before(last) {
+			oldest, last = key, t
+		}
+	}
+	delete(s.series, oldest)
+}
@@ -0,0 +1,124 @@
+package history
+
+import (
+	"context"
+	"testing"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/plasmatrip/metriq/internal/storage/mem"
+	"github.com/plasmatrip/metriq/internal/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func values(points []Point) []float64 {
+	vals := make([]float64, 0, len(points))
+	for _, p := range points {
+		vals = append(vals, p.Value)
+	}
+	return vals
+}
+
+func TestStorage_History(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), 3)
+
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, stor.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(i) / 10}))
+	}
+	assert.Equal(t, []float64{0.2, 0.3, 0.4}, values(stor.History(types.Gauge, "load")))
+
+	delta := int64(5)
+	batch := []models.Metrics{
+		{ID: "requests", MType: types.Counter, Delta: &delta},
+		{ID: "requests", MType: types.Counter, Delta: &delta},
+	}
+	require.NoError(t, stor.SetMetrics(ctx, batch[:1]))
+	require.NoError(t, stor.SetMetrics(ctx, batch))
+
+	// counters are recorded as totals
+	assert.Equal(t, []float64{5, 15, 15}, values(stor.History(types.Counter, "requests")))
+
+	assert.Empty(t, stor.History(types.Gauge, "unknown"))
+}
+
+func TestStorage_MaxSeries(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), 3)
+	stor.maxSeries = 2
+
+	for _, name := range []string{"first", "second", "first", "third"} {
+		require.NoError(t, stor.SetMetric(ctx, name, types.Metric{MetricType: types.Gauge, Value: float64(1)}))
+	}
+
+	// забыта метрика, которая обновлялась раньше остальных
+	assert.Len(t, stor.series, 2)
+	assert.Empty(t, stor.History(types.Gauge, "second"))
+	assert.Len(t, stor.History(types.Gauge, "first"), 2)
+}
+
+func TestStorage_FailedWrite(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), 3)
+
+	err := stor.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: "wrong"})
+	assert.Error(t, err)
+	assert.Empty(t, stor.History(types.Gauge, "load"))
+}
+
+func TestStorage_Subscribe(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), 3)
+	base := stor.lastEventID
+
+	require.NoError(t, stor.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+
+	missed, events, cancel := stor.Subscribe(0)
+	require.Len(t, missed, 1)
+	assert.Equal(t, base+1, missed[0].ID)
+	assert.Equal(t, int64(1), *missed[0].Metric.Delta)
+
+	require.NoError(t, stor.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(2)}))
+	e := <-events
+	assert.Equal(t, base+2, e.ID)
+	assert.Equal(t, "requests", e.Metric.ID)
+	assert.Equal(t, int64(3), *e.Metric.Delta)
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+
+	// resume after the last received event
+	missed, _, cancel = stor.Subscribe(base + 1)
+	defer cancel()
+	require.Len(t, missed, 1)
+	assert.Equal(t, base+2, missed[0].ID)
+
+	// ID of an earlier process replays the buffer
+	missed, _, cancel = stor.Subscribe(base - 1)
+	defer cancel()
+	assert.Len(t, missed, 2)
+
+	// ID above the current ones is taken for a reset
+	missed, _, cancel = stor.Subscribe(base + 10)
+	defer cancel()
+	assert.Len(t, missed, 2)
+}
+
+func TestStorage_SlowSubscriber(t *testing.T) {
+	ctx := context.Background()
+	stor := NewStorage(mem.NewStorage(), 3)
+
+	_, events, cancel := stor.Subscribe(0)
+	defer cancel()
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		require.NoError(t, stor.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+}
@@ -3,19 +3,42 @@ package mem
 import (
 	"context"
 	"errors"
+	"fmt"
 	"maps"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/plasmatrip/metriq/internal/models"
+	istorage "github.com/plasmatrip/metriq/internal/storage"
 	"github.com/plasmatrip/metriq/internal/types"
 )
 
 type storage map[string]types.Metric
 
+// MemStorage keeps the metrics in a map under the keys of types.MetricKey.
 type MemStorage struct {
-	Mu      sync.RWMutex
-	Storage storage
-	bkp     backup
+	Mu       sync.RWMutex
+	Storage  storage
+	bkp      backup
+	samples  samples
+	conflict string
+}
+
+// samples keeps the written values of metrics for the aggregation queries,
+// gauges as their values and counters as their increments.
+type samples struct {
+	retention time.Duration
+	series    map[string]*series
+	now       func() time.Time
+	// lastSweep - время последней очистки всех рядов, ряды без записей тоже очищаются раз в срок хранения
+	lastSweep time.Time
+}
+
+type series struct {
+	mType  string
+	name   string
+	points []istorage.Sample
 }
 
 type backup struct {
@@ -28,9 +51,26 @@ func NewStorage() *MemStorage {
 		Mu:      sync.RWMutex{},
 		Storage: make(storage),
 		bkp:     backup{do: false, c: nil},
+		samples: samples{series: make(map[string]*series), now: time.Now},
 	}
 }
 
+// SetSampleRetention makes the storage keep the values written within the retention period
+// for the aggregation queries. Without it no values are kept and the queries find nothing.
+func (ms *MemStorage) SetSampleRetention(retention time.Duration) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	ms.samples.retention = retention
+}
+
+// SetTypeConflict sets what a write of a metric under a name that holds a metric of another type does,
+// one of the types.Conflict policies. By default such a write is rejected with types.ErrTypeConflict.
+func (ms *MemStorage) SetTypeConflict(conflict string) {
+	ms.Mu.Lock()
+	defer ms.Mu.Unlock()
+	ms.conflict = conflict
+}
+
 func (ms *MemStorage) Ping(_ context.Context) error {
 	return nil
 }
@@ -38,76 +78,165 @@ func (ms *MemStorage) Ping(_ context.Context) error {
 func (ms *MemStorage) Close() {
 }
 
+// SetMetrics writes the batch as a whole: if a metric has no value of its type or conflicts
+// with the stored ones or with another metric of the batch, nothing is written.
 func (ms *MemStorage) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
 	for _, metric := range metrics {
+		if err := types.CheckFields(metric); err != nil {
+			return err
+		}
+	}
+
+	ms.Mu.Lock()
+
+	if ms.conflict == "" || ms.conflict == types.ConflictReject {
+		batch := make(map[string]string, len(metrics))
+		for _, metric := range metrics {
+			mType, ok := batch[metric.ID]
+			if !ok {
+				if old, stored := ms.Storage[metric.ID]; stored {
+					mType, ok = old.MetricType, true
+				}
+			}
+			if ok && mType != metric.MType {
+				ms.Mu.Unlock()
+				return conflictError(metric.ID, mType)
+			}
+			batch[metric.ID] = metric.MType
+		}
+	}
+
+	for _, metric := range metrics {
+		var err error
 		switch metric.MType {
 		case types.Gauge:
-			err := ms.SetMetric(ctx, metric.ID, types.Metric{MetricType: metric.MType, Value: *metric.Value})
-			if err != nil {
-				return err
-			}
+			err = ms.set(metric.ID, types.Metric{MetricType: metric.MType, Value: *metric.Value})
 		case types.Counter:
-			err := ms.SetMetric(ctx, metric.ID, types.Metric{MetricType: metric.MType, Value: *metric.Delta})
-			if err != nil {
-				return err
-			}
+			err = ms.set(metric.ID, types.Metric{MetricType: metric.MType, Value: *metric.Delta})
+		}
+		if err != nil {
+			ms.Mu.Unlock()
+			return err
 		}
 	}
 
+	ms.Mu.Unlock()
+
+	ms.backup()
+
 	return nil
 }
 
 func (ms *MemStorage) SetMetric(ctx context.Context, mName string, metric types.Metric) error {
 	ms.Mu.Lock()
+	err := ms.set(mName, metric)
+	ms.Mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ms.backup()
+
+	return nil
+}
+
+// backup signals the backup that the metrics have changed. The write does not wait for the backup:
+// a signal already pending covers this change too, and after the backup has stopped on shutdown
+// the final backup of the server saves it.
+func (ms *MemStorage) backup() {
+	if !ms.bkp.do {
+		return
+	}
+	select {
+	case ms.bkp.c <- struct{}{}:
+	default:
+	}
+}
+
+// set writes the metric, the lock must be held. A gauge also increments PollCount, unless
+// the name holds a gauge: the write of the gauge does not fail because of it.
+func (ms *MemStorage) set(mName string, metric types.Metric) error {
+	if err := metric.Check(); err != nil {
+		return err
+	}
+
+	key := types.MetricKey(ms.conflict, metric.MetricType, mName)
+	old, ok := ms.Storage[key]
+	if ok && old.MetricType != metric.MetricType {
+		if ms.conflict != types.ConflictOverwrite {
+			return conflictError(mName, old.MetricType)
+		}
+		// counter другого типа начинается заново
+		ok = false
+	}
+
 	switch metric.MetricType {
 	case types.Gauge:
-		if err := metric.Check(); err != nil {
-			ms.Mu.Unlock()
-			return err
-		}
-		ms.Storage[mName] = metric
-		err := ms.setCounter(ctx, types.PollCount, types.Metric{MetricType: types.Counter, Value: int64(1)})
-		if err != nil {
-			ms.Mu.Unlock()
+		ms.Storage[key] = metric
+		ms.record(key, mName, metric)
+		err := ms.set(types.PollCount, types.Metric{MetricType: types.Counter, Value: int64(1)})
+		if err != nil && !errors.Is(err, types.ErrTypeConflict) {
 			return err
 		}
 	case types.Counter:
-		err := ms.setCounter(ctx, mName, metric)
-		if err != nil {
-			ms.Mu.Unlock()
-			return err
+		value := metric.Value.(int64)
+		if ok {
+			value += old.Value.(int64)
 		}
+		ms.Storage[key] = types.Metric{MetricType: metric.MetricType, Value: value}
+		ms.record(key, mName, metric)
 	}
 
-	ms.Mu.Unlock()
+	return nil
+}
 
-	if ms.bkp.do {
-		ms.bkp.c <- struct{}{}
-		select {
-		case <-ctx.Done():
-			close(ms.bkp.c)
-		default:
-		}
+func conflictError(mName, mType string) error {
+	return fmt.Errorf("%w: %s is a %s", types.ErrTypeConflict, mName, mType)
+}
+
+// record keeps the written value and drops the values older than the retention period, the lock must be held.
+// Once in the retention period the values of the series not written since are dropped too, with the emptied series.
+func (ms *MemStorage) record(key, mName string, metric types.Metric) {
+	if ms.samples.retention <= 0 {
+		return
 	}
 
-	return nil
-}
+	var value float64
+	switch v := metric.Value.(type) {
+	case float64:
+		value = v
+	case int64:
+		value = float64(v)
+	default:
+		return
+	}
 
-func (ms *MemStorage) setCounter(_ context.Context, mName string, metric types.Metric) error {
-	if oldMetric, ok := ms.Storage[mName]; ok {
-		oldValue, ok := oldMetric.Value.(int64)
-		if !ok {
-			return errors.New("failed to cast stored value to type int64")
-		}
-		newValue, ok := metric.Value.(int64)
-		if !ok {
-			return errors.New("failed to cast the received value to type int64")
+	s, ok := ms.samples.series[key]
+	if !ok || s.mType != metric.MetricType {
+		s = &series{mType: metric.MetricType, name: mName}
+		ms.samples.series[key] = s
+	}
+
+	now := ms.samples.now()
+	s.expire(now.Add(-ms.samples.retention))
+	s.points = append(s.points, istorage.Sample{Time: now, Value: value})
+
+	if now.Sub(ms.samples.lastSweep) >= ms.samples.retention {
+		ms.samples.lastSweep = now
+		for key, s := range ms.samples.series {
+			if s.expire(now.Add(-ms.samples.retention)); len(s.points) == 0 {
+				delete(ms.samples.series, key)
+			}
 		}
-		ms.Storage[mName] = types.Metric{MetricType: metric.MetricType, Value: oldValue + newValue}
-		return nil
 	}
-	ms.Storage[mName] = metric
-	return nil
+}
+
+// expire drops the values written up to the time.
+func (s *series) expire(before time.Time) {
+	expired := sort.Search(len(s.points), func(i int) bool {
+		return s.points[i].Time.After(before)
+	})
+	s.points = s.points[expired:]
 }
 
 func (ms *MemStorage) SetBackup(c chan struct{}) {
@@ -115,14 +244,23 @@ func (ms *MemStorage) SetBackup(c chan struct{}) {
 	ms.bkp.c = c
 }
 
-func (ms *MemStorage) Metric(_ context.Context, key string) (types.Metric, error) {
+// Metric returns the metric of the type stored under the name, an empty type finds a metric of any type,
+// the gauge first if each type has its own names.
+func (ms *MemStorage) Metric(_ context.Context, mType, mName string) (types.Metric, error) {
 	ms.Mu.RLock()
 	defer ms.Mu.RUnlock()
-	metric, ok := ms.Storage[key]
-	if !ok {
-		return types.Metric{}, errors.New("metric not found")
+
+	mTypes := []string{mType}
+	if mType == "" && ms.conflict == types.ConflictNamespace {
+		mTypes = []string{types.Gauge, types.Counter}
+	}
+	for _, t := range mTypes {
+		metric, ok := ms.Storage[types.MetricKey(ms.conflict, t, mName)]
+		if ok && (t == "" || metric.MetricType == t) {
+			return metric, nil
+		}
 	}
-	return metric, nil
+	return types.Metric{}, errors.New("metric not found")
 }
 
 func (ms *MemStorage) Metrics(_ context.Context) (map[string]types.Metric, error) {
@@ -132,3 +270,54 @@ func (ms *MemStorage) Metrics(_ context.Context) (map[string]types.Metric, error
 	maps.Copy(copyStorage, ms.Storage)
 	return copyStorage, nil
 }
+
+func (ms *MemStorage) MetricsOf(_ context.Context, mNames []string) (map[string]types.Metric, error) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	metrics := make(map[string]types.Metric, len(mNames))
+	for _, name := range mNames {
+		for _, key := range types.MetricKeys(ms.conflict, name) {
+			if metric, ok := ms.Storage[key]; ok {
+				metrics[key] = metric
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// Query aggregates the values of the selected metrics written within the window of the query.
+func (ms *MemStorage) Query(_ context.Context, q istorage.Query) ([]istorage.Aggregate, error) {
+	ms.Mu.RLock()
+	defer ms.Mu.RUnlock()
+
+	results := make([]istorage.Aggregate, 0)
+	for _, s := range ms.samples.series {
+		if !istorage.Match(q.Selector, s.name) {
+			continue
+		}
+
+		from := sort.Search(len(s.points), func(i int) bool { return s.points[i].Time.After(q.From) })
+		to := sort.Search(len(s.points), func(i int) bool { return s.points[i].Time.After(q.To) })
+		if from >= to {
+			continue
+		}
+
+		points := s.points[from:to]
+		results = append(results, istorage.Aggregate{
+			ID:      s.name,
+			MType:   s.mType,
+			Value:   q.Aggregate(s.mType, points),
+			Samples: len(points),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ID != results[j].ID {
+			return results[i].ID < results[j].ID
+		}
+		return results[i].MType < results[j].MType
+	})
+
+	return results, nil
+}
@@ -3,7 +3,10 @@ package mem
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/plasmatrip/metriq/internal/models"
+	istorage "github.com/plasmatrip/metriq/internal/storage"
 	"github.com/plasmatrip/metriq/internal/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -29,7 +32,7 @@ func TestMemStorage_Update(t *testing.T) {
 		},
 		{
 			name:       "Gauge counter correct value",
-			key:        "key",
+			key:        "gauge",
 			value:      float64(1),
 			want:       float64(1),
 			errWant:    false,
@@ -44,7 +47,7 @@ func TestMemStorage_Update(t *testing.T) {
 		},
 		{
 			name:       "Gauge counter incorrect value",
-			key:        "key",
+			key:        "gauge",
 			value:      "a",
 			errWant:    true,
 			metricType: types.Gauge,
@@ -125,7 +128,7 @@ func TestMemStorage_Get(t *testing.T) {
 			case types.Gauge:
 				_ = storage.SetMetric(ctx, test.key, test.metric)
 			}
-			_, err := storage.Metric(ctx, test.getKey)
+			_, err := storage.Metric(ctx, "", test.getKey)
 			if test.errWant {
 				assert.Error(t, err)
 				return
@@ -151,6 +154,114 @@ func TestMemStorage_Metrics(t *testing.T) {
 		assert.Equal(t, types.Metric{MetricType: types.Gauge, Value: float64(100)}, metrics["metric"])
 		assert.Equal(t, types.Metric{MetricType: types.Counter, Value: int64(100)}, metrics["counter"])
 	})
+
+	t.Run("Get metrics by name", func(t *testing.T) {
+		metrics, err := storage.MetricsOf(ctx, []string{"metric", "unknown"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]types.Metric{"metric": {MetricType: types.Gauge, Value: float64(100)}}, metrics)
+	})
+
+	t.Run("Get metrics of every type by name", func(t *testing.T) {
+		storage := NewStorage()
+		storage.SetTypeConflict(types.ConflictNamespace)
+		storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(1)})
+		storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(2)})
+
+		metrics, err := storage.MetricsOf(ctx, []string{"load"})
+		assert.NoError(t, err)
+		assert.Len(t, metrics, 2)
+		assert.Contains(t, metrics, "gauge/load")
+		assert.Contains(t, metrics, "counter/load")
+	})
+}
+
+func TestMemStorage_Backup(t *testing.T) {
+	ctx := context.Background()
+	storage := NewStorage()
+	c := make(chan struct{}, 1)
+	storage.SetBackup(c)
+
+	// никто не читает канал, как после остановки резервного копирования, записи не ждут
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, storage.SetMetric(ctx, "requests", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the write waits for the backup")
+	}
+	assert.Len(t, c, 1)
+}
+
+func TestMemStorage_TypeConflict(t *testing.T) {
+	ctx := context.Background()
+	gauge := func(v float64) *float64 { return &v }
+	delta := func(v int64) *int64 { return &v }
+
+	t.Run("Reject", func(t *testing.T) {
+		storage := NewStorage()
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(5)}))
+
+		err := storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(1.5)})
+		assert.ErrorIs(t, err, types.ErrTypeConflict)
+
+		// пакет с конфликтом не записывается целиком
+		err = storage.SetMetrics(ctx, []models.Metrics{
+			{ID: "requests", MType: types.Counter, Delta: delta(1)},
+			{ID: "requests", MType: types.Gauge, Value: gauge(1)},
+		})
+		assert.ErrorIs(t, err, types.ErrTypeConflict)
+		_, err = storage.Metric(ctx, "", "requests")
+		assert.Error(t, err)
+
+		metric, err := storage.Metric(ctx, types.Counter, "load")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), metric.Value)
+		_, err = storage.Metric(ctx, types.Gauge, "load")
+		assert.Error(t, err)
+	})
+
+	t.Run("Namespace", func(t *testing.T) {
+		storage := NewStorage()
+		storage.SetTypeConflict(types.ConflictNamespace)
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(5)}))
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(1.5)}))
+
+		counter, err := storage.Metric(ctx, types.Counter, "load")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), counter.Value)
+		gauge, err := storage.Metric(ctx, types.Gauge, "load")
+		assert.NoError(t, err)
+		assert.Equal(t, float64(1.5), gauge.Value)
+
+		metrics, err := storage.Metrics(ctx)
+		assert.NoError(t, err)
+		assert.Contains(t, metrics, "counter/load")
+		assert.Contains(t, metrics, "gauge/load")
+		assert.Contains(t, metrics, "counter/PollCount")
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		storage := NewStorage()
+		storage.SetTypeConflict(types.ConflictOverwrite)
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(5)}))
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Gauge, Value: float64(1.5)}))
+
+		metric, err := storage.Metric(ctx, types.Gauge, "load")
+		assert.NoError(t, err)
+		assert.Equal(t, float64(1.5), metric.Value)
+
+		// counter начинается заново
+		assert.NoError(t, storage.SetMetric(ctx, "load", types.Metric{MetricType: types.Counter, Value: int64(2)}))
+		metric, err = storage.Metric(ctx, types.Counter, "load")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), metric.Value)
+	})
 }
 
 // package storage
@@ -286,3 +397,52 @@ func TestMemStorage_Metrics(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func TestMemStorage_Query(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	now := start
+
+	storage := NewStorage()
+	storage.samples.now = func() time.Time { return now }
+
+	// без срока хранения значения не сохраняются
+	assert.NoError(t, storage.SetMetric(ctx, "http_requests", types.Metric{MetricType: types.Counter, Value: int64(100)}))
+
+	storage.SetSampleRetention(time.Hour)
+	for i := 1; i <= 3; i++ {
+		now = start.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, storage.SetMetric(ctx, "http_requests", types.Metric{MetricType: types.Counter, Value: int64(i)}))
+		assert.NoError(t, storage.SetMetric(ctx, "http_errors", types.Metric{MetricType: types.Counter, Value: int64(1)}))
+		assert.NoError(t, storage.SetMetric(ctx, "HeapAlloc", types.Metric{MetricType: types.Gauge, Value: float64(i * 10)}))
+	}
+
+	q := istorage.Query{Selector: "http_*", From: start, To: now, Aggregation: istorage.AggSum}
+	results, err := storage.Query(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []istorage.Aggregate{
+		{ID: "http_errors", MType: types.Counter, Value: 3, Samples: 3},
+		{ID: "http_requests", MType: types.Counter, Value: 6, Samples: 3},
+	}, results)
+
+	// окно не включает свое начало
+	q = istorage.Query{Selector: "HeapAlloc", From: start.Add(time.Minute), To: now, Aggregation: istorage.AggAvg}
+	results, err = storage.Query(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []istorage.Aggregate{{ID: "HeapAlloc", MType: types.Gauge, Value: 25, Samples: 2}}, results)
+
+	// значения старше срока хранения удаляются при следующей записи
+	now = start.Add(time.Hour + 2*time.Minute)
+	assert.NoError(t, storage.SetMetric(ctx, "HeapAlloc", types.Metric{MetricType: types.Gauge, Value: float64(5)}))
+	q = istorage.Query{Selector: "HeapAlloc", From: start, To: now, Aggregation: istorage.AggCount}
+	results, err = storage.Query(ctx, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []istorage.Aggregate{{ID: "HeapAlloc", MType: types.Gauge, Value: 2, Samples: 2}}, results)
+
+	// ряды без записей удаляются при очистке раз в срок хранения
+	now = start.Add(2*time.Hour + 3*time.Minute)
+	assert.NoError(t, storage.SetMetric(ctx, "HeapAlloc", types.Metric{MetricType: types.Gauge, Value: float64(7)}))
+	assert.NotContains(t, storage.samples.series, "http_requests")
+	assert.NotContains(t, storage.samples.series, "http_errors")
+	assert.Contains(t, storage.samples.series, "HeapAlloc")
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+// Aggregation is the function applied to the values of a metric written within the window of a query.
+// Gauges are written as their values, counters as their increments, so the sum of a counter is its
+// increase within the window.
+type Aggregation string
+
+const (
+	AggSum   Aggregation = "sum"
+	AggAvg   Aggregation = "avg"
+	AggMin   Aggregation = "min"
+	AggMax   Aggregation = "max"
+	AggCount Aggregation = "count"
+	AggLast  Aggregation = "last"
+	// AggRate - приращение counter или изменение gauge в секунду
+	AggRate Aggregation = "rate"
+	AggP95  Aggregation = "p95"
+)
+
+// ParseAggregation checks the name of the aggregation.
+func ParseAggregation(name string) (Aggregation, error) {
+	switch agg := Aggregation(name); agg {
+	case AggSum, AggAvg, AggMin, AggMax, AggCount, AggLast, AggRate, AggP95:
+		return agg, nil
+	default:
+		return "", fmt.Errorf("unknown aggregation %q", name)
+	}
+}
+
+// Query selects the metrics by an exact name or a glob with * and ? and aggregates
+// their values written after From up to and including To.
+type Query struct {
+	Selector    string
+	From        time.Time
+	To          time.Time
+	Aggregation Aggregation
+}
+
+// Aggregate is the result of a query for one metric.
+type Aggregate struct {
+	ID      string
+	MType   string
+	Value   float64
+	Samples int
+}
+
+// Sample is a value of a metric written at the time.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Match reports whether the name is selected by the selector, * matches any
+// sequence of characters and ? any single character.
+func Match(selector, name string) bool {
+	s, n := []rune(selector), []rune(name)
+	// позиция последней * в селекторе и позиция имени, с которой она сопоставлена
+	star, next := -1, 0
+
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(s) && (s[i] == '?' || (s[i] != '*' && s[i] == n[j])):
+			i++
+			j++
+		case i < len(s) && s[i] == '*':
+			star, next = i, j
+			i++
+		case star >= 0:
+			// * поглощает еще один символ
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+
+	for i < len(s) && s[i] == '*' {
+		i++
+	}
+
+	return i == len(s)
+}
+
+// LikePattern translates the selector to the pattern of the SQL LIKE operator with \ as the escape character.
+func LikePattern(selector string) string {
+	var b strings.Builder
+	for _, r := range selector {
+		switch r {
+		case '*':
+			b.WriteRune('%')
+		case '?':
+			b.WriteRune('_')
+		case '%', '_', '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Aggregate applies the aggregation of the query to the samples of a metric of the type, the oldest first.
+// It is used by the storages that keep the samples in memory and follows the SQL of the database storage.
+func (q Query) Aggregate(mType string, samples []Sample) float64 {
+	switch q.Aggregation {
+	case AggSum, AggAvg:
+		var sum float64
+		for _, s := range samples {
+			sum += s.Value
+		}
+		if q.Aggregation == AggAvg {
+			return sum / float64(len(samples))
+		}
+		return sum
+	case AggMin:
+		min := samples[0].Value
+		for _, s := range samples[1:] {
+			min = math.Min(min, s.Value)
+		}
+		return min
+	case AggMax:
+		max := samples[0].Value
+		for _, s := range samples[1:] {
+			max = math.Max(max, s.Value)
+		}
+		return max
+	case AggCount:
+		return float64(len(samples))
+	case AggLast:
+		return samples[len(samples)-1].Value
+	case AggRate:
+		return q.rate(mType, samples)
+	case AggP95:
+		return percentile(samples, 0.95)
+	}
+	return 0
+}
+
+// rate divides the increase of a counter by the window and the change of a gauge by the time between its first and last samples.
+func (q Query) rate(mType string, samples []Sample) float64 {
+	if mType == types.Counter {
+		var sum float64
+		for _, s := range samples {
+			sum += s.Value
+		}
+		return sum / q.To.Sub(q.From).Seconds()
+	}
+
+	first, last := samples[0], samples[len(samples)-1]
+	elapsed := last.Time.Sub(first.Time).Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+	return (last.Value - first.Value) / elapsed
+}
+
+// percentile interpolates between the closest values like percentile_cont of PostgreSQL.
+func percentile(samples []Sample, p float64) float64 {
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.Value
+	}
+	sort.Float64s(values)
+
+	pos := p * float64(len(values)-1)
+	lower := int(math.Floor(pos))
+	if lower == len(values)-1 {
+		return values[lower]
+	}
+	return values[lower] + (pos-float64(lower))*(values[lower+1]-values[lower])
+}
+
+// Combine folds the results for the single metrics into the result for all of them: sums and counts
+// are added up, averages weighted by the number of samples. The percentile, the last value and the
+// rate, which differs for gauges and counters, are not computed from those of several metrics, ok is
+// false for them.
+func Combine(agg Aggregation, results []Aggregate) (value float64, ok bool) {
+	switch len(results) {
+	case 0:
+		return 0, false
+	case 1:
+		return results[0].Value, true
+	}
+
+	switch agg {
+	case AggSum, AggCount:
+		for _, r := range results {
+			value += r.Value
+		}
+	case AggAvg:
+		var samples int
+		for _, r := range results {
+			value += r.Value * float64(r.Samples)
+			samples += r.Samples
+		}
+		value /= float64(samples)
+	case AggMin:
+		value = results[0].Value
+		for _, r := range results[1:] {
+			value = math.Min(value, r.Value)
+		}
+	case AggMax:
+		value = results[0].Value
+		for _, r := range results[1:] {
+			value = math.Max(value, r.Value)
+		}
+	default:
+		return 0, false
+	}
+
+	return value, true
+}
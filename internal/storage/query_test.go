@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/plasmatrip/metriq/internal/types"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		selector string
+		name     string
+		want     bool
+	}{
+		{"HeapAlloc", "HeapAlloc", true},
+		{"HeapAlloc", "HeapAllocs", false},
+		{"http_*", "http_requests", true},
+		{"http_*", "http_", true},
+		{"http_*", "grpc_requests", false},
+		{"*_total", "http_requests_total", true},
+		{"h?ap*", "HeapAlloc", false},
+		{"H?ap*", "HeapAlloc", true},
+		{"*a*b*", "xaxxbx", true},
+		{"*a*b", "xaxxbx", false},
+		{"*", "", true},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Match(tt.selector, tt.name), "%s ~ %s", tt.selector, tt.name)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	assert.Equal(t, "http\\_%", LikePattern("http_*"))
+	assert.Equal(t, "a_b\\%c\\\\", LikePattern("a?b%c\\"))
+}
+
+func TestQuery_Aggregate(t *testing.T) {
+	now := time.Now()
+	samples := make([]Sample, 0, 20)
+	for i := 1; i <= 20; i++ {
+		samples = append(samples, Sample{Time: now.Add(time.Duration(i) * time.Second), Value: float64(i)})
+	}
+
+	q := Query{From: now, To: now.Add(time.Minute)}
+	tests := []struct {
+		agg   Aggregation
+		mType string
+		want  float64
+	}{
+		{AggSum, types.Gauge, 210},
+		{AggAvg, types.Gauge, 10.5},
+		{AggMin, types.Gauge, 1},
+		{AggMax, types.Gauge, 20},
+		{AggCount, types.Gauge, 20},
+		{AggLast, types.Gauge, 20},
+		{AggP95, types.Gauge, 19.05},
+		// gauge изменился на 19 за 19 секунд
+		{AggRate, types.Gauge, 1},
+		// counter увеличился на 210 за окно в 60 секунд
+		{AggRate, types.Counter, 3.5},
+	}
+
+	for _, tt := range tests {
+		q.Aggregation = tt.agg
+		assert.InDelta(t, tt.want, q.Aggregate(tt.mType, samples), 1e-9, "%s of %s", tt.agg, tt.mType)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	results := []Aggregate{{ID: "a", Value: 2, Samples: 1}, {ID: "b", Value: 5, Samples: 3}}
+
+	value, ok := Combine(AggSum, results)
+	assert.True(t, ok)
+	assert.Equal(t, 7.0, value)
+
+	value, ok = Combine(AggAvg, results)
+	assert.True(t, ok)
+	assert.Equal(t, 4.25, value)
+
+	value, ok = Combine(AggMin, results)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, value)
+
+	_, ok = Combine(AggP95, results)
+	assert.False(t, ok)
+
+	_, ok = Combine(AggLast, results)
+	assert.False(t, ok)
+
+	_, ok = Combine(AggRate, results)
+	assert.False(t, ok)
+
+	value, ok = Combine(AggP95, results[:1])
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, value)
+
+	_, ok = Combine(AggSum, nil)
+	assert.False(t, ok)
+}
+
+func TestParseAggregation(t *testing.T) {
+	agg, err := ParseAggregation("p95")
+	assert.NoError(t, err)
+	assert.Equal(t, AggP95, agg)
+
+	_, err = ParseAggregation("median")
+	assert.Error(t, err)
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"errors"
 
 	"github.com/plasmatrip/metriq/internal/models"
 	"github.com/plasmatrip/metriq/internal/types"
@@ -10,9 +11,36 @@ import (
 type Repository interface {
 	SetMetrics(ctx context.Context, metrics []models.Metrics) error
 	SetMetric(ctx context.Context, mName string, metric types.Metric) error
-	Metric(ctx context.Context, mName string) (types.Metric, error)
+	// Metric returns the metric of the type, an empty type finds a metric of any type
+	Metric(ctx context.Context, mType, mName string) (types.Metric, error)
+	// Metrics returns the metrics under the keys of types.MetricKey
 	Metrics(context.Context) (map[string]types.Metric, error)
+	// MetricsOf returns the metrics of any type stored under the names, under the keys of types.MetricKey
+	MetricsOf(ctx context.Context, mNames []string) (map[string]types.Metric, error)
+	Query(ctx context.Context, q Query) ([]Aggregate, error)
 	SetBackup(chan struct{})
 	Ping(context.Context) error
 	Close()
 }
+
+// SetMetricsApart writes the batch with SetMetrics and, if it is rejected for a metric of another type
+// under a name, writes the metrics one by one, so that only the conflicting ones are lost. It returns
+// the names of the metrics not written for a conflict and the first error of another kind.
+func SetMetricsApart(ctx context.Context, repo Repository, metrics []models.Metrics) ([]string, error) {
+	if err := repo.SetMetrics(ctx, metrics); !errors.Is(err, types.ErrTypeConflict) {
+		return nil, err
+	}
+
+	var conflicts []string
+	for i := range metrics {
+		err := repo.SetMetrics(ctx, metrics[i:i+1])
+		if errors.Is(err, types.ErrTypeConflict) {
+			conflicts = append(conflicts, metrics[i].ID)
+			continue
+		}
+		if err != nil {
+			return conflicts, err
+		}
+	}
+	return conflicts, nil
+}
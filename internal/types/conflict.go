@@ -0,0 +1,67 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ConflictReject - запись метрики другого типа под занятым именем отклоняется
+	ConflictReject = "reject"
+	// ConflictNamespace - у каждого типа свои имена, gauge/x и counter/x хранятся отдельно
+	ConflictNamespace = "namespace"
+	// ConflictOverwrite - метрика другого типа заменяет хранимую, counter начинается заново
+	ConflictOverwrite = "overwrite"
+)
+
+// ErrTypeConflict is returned by the storages for a write of a metric under a name that holds
+// a metric of another type, when the type conflicts are rejected.
+var ErrTypeConflict = errors.New("the name holds a metric of another type")
+
+// CheckTypeConflict returns an error for an unknown type conflict policy.
+func CheckTypeConflict(conflict string) error {
+	switch conflict {
+	case ConflictReject, ConflictNamespace, ConflictOverwrite:
+		return nil
+	}
+	return fmt.Errorf("unknown type conflict policy %q, %s, %s or %s is expected", conflict, ConflictReject, ConflictNamespace, ConflictOverwrite)
+}
+
+// MetricKey returns the key the storages keep the metric under and list it by: its name or,
+// when every type has its own names, type/name. An empty policy is ConflictReject.
+func MetricKey(conflict, mType, mName string) string {
+	if conflict == ConflictNamespace {
+		return mType + "/" + mName
+	}
+	return mName
+}
+
+// MetricKeys returns the keys the metrics of any type with the name are stored under.
+func MetricKeys(conflict, mName string) []string {
+	if conflict == ConflictNamespace {
+		return []string{MetricKey(conflict, Gauge, mName), MetricKey(conflict, Counter, mName)}
+	}
+	return []string{mName}
+}
+
+// KeyName returns the name of the metric of the type listed under the key.
+func KeyName(conflict, key, mType string) string {
+	if conflict == ConflictNamespace {
+		return strings.TrimPrefix(key, mType+"/")
+	}
+	return key
+}
+
+// LookupMetric finds the metric of the type in a listing of a storage whatever its policy: under
+// the name, unless a metric of another type is kept there, or under type/name.
+func LookupMetric(metrics map[string]Metric, mType, mName string) (Metric, bool) {
+	if metric, ok := metrics[mName]; ok && metric.MetricType == mType {
+		return metric, true
+	}
+	metric, ok := metrics[MetricKey(ConflictNamespace, mType, mName)]
+	if ok && metric.MetricType != mType {
+		return Metric{}, false
+	}
+	return metric, ok
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+// ErrNotFinite is wrapped by the errors of the NaN and infinite gauge values, they cannot be encoded to JSON.
+var ErrNotFinite = errors.New("the value is not a finite number")
+
+// FieldError is a problem of a field of a metric received in a request.
+type FieldError struct {
+	Field   string // поле метрики в JSON, например value
+	Message string // описание ошибки
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// CheckFields checks the value fields of a metric of a known type: a counter has only a delta,
+// a gauge has only a value and the value is finite. It returns *FieldError for the first problem.
+func CheckFields(m models.Metrics) error {
+	switch m.MType {
+	case Counter:
+		if m.Delta == nil {
+			return &FieldError{Field: "delta", Message: "the counter has no delta"}
+		}
+		if m.Value != nil {
+			return &FieldError{Field: "value", Message: "a counter has a delta, not a value"}
+		}
+	case Gauge:
+		if m.Value == nil {
+			return &FieldError{Field: "value", Message: "the gauge has no value"}
+		}
+		if m.Delta != nil {
+			return &FieldError{Field: "delta", Message: "a gauge has a value, not a delta"}
+		}
+		if err := checkFinite(*m.Value); err != nil {
+			return &FieldError{Field: "value", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+func checkFinite(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return fmt.Errorf("%w: %v", ErrNotFinite, value)
+	}
+	return nil
+}
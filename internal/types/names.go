@@ -0,0 +1,231 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/plasmatrip/metriq/internal/models"
+)
+
+const (
+	// NameReject - имена, не подходящие под правила, отклоняются
+	NameReject = "reject"
+	// NameSanitize - недопустимые символы заменяются, длинные имена обрезаются
+	NameSanitize = "sanitize"
+
+	// CaseKeep - регистр имени не меняется
+	CaseKeep = "keep"
+	// CaseLower - имя приводится к нижнему регистру
+	CaseLower = "lower"
+	// CaseUpper - имя приводится к верхнему регистру
+	CaseUpper = "upper"
+
+	// DefaultNameChars - любые символы, кроме косой черты, пробельных и управляющих,
+	// такое имя можно передать в пути /update/{type}/{name}/{value}
+	DefaultNameChars = `[^/\s\p{Z}\p{C}]`
+	// DefaultNameMaxLength - длина столбца id VARCHAR(128) в базе данных
+	DefaultNameMaxLength = 128
+
+	// sanitizeRune - замена недопустимого символа
+	sanitizeRune = '_'
+)
+
+// ErrInvalidName is wrapped by the errors of the names that break the name policy.
+var ErrInvalidName = errors.New("invalid metric name")
+
+// NameRules are the rules of the metric names. The zero value allows any non-empty name.
+type NameRules struct {
+	Mode             string   // NameReject или NameSanitize, по умолчанию NameReject
+	Chars            string   // класс допустимых символов регулярного выражения, например [A-Za-z0-9_.], пустой - любые
+	MaxLength        int      // максимальная длина имени в символах, 0 - без ограничения
+	ReservedPrefixes []string // префиксы, запрещенные для имен клиентов
+	Case             string   // CaseKeep, CaseLower или CaseUpper, по умолчанию CaseKeep
+}
+
+// NamePolicy checks and normalizes the metric names by the rules. A nil policy accepts
+// every name as it is, the handlers of the tests and the tools use it.
+type NamePolicy struct {
+	rules NameRules
+	// char - класс допустимых символов, name - имя только из них
+	char *regexp.Regexp
+	name *regexp.Regexp
+}
+
+// NewNamePolicy validates the rules and compiles the character class.
+func NewNamePolicy(rules NameRules) (*NamePolicy, error) {
+	switch rules.Mode {
+	case "":
+		rules.Mode = NameReject
+	case NameReject, NameSanitize:
+	default:
+		return nil, fmt.Errorf("unknown name mode %q, %s or %s is expected", rules.Mode, NameReject, NameSanitize)
+	}
+
+	switch rules.Case {
+	case "":
+		rules.Case = CaseKeep
+	case CaseKeep, CaseLower, CaseUpper:
+	default:
+		return nil, fmt.Errorf("unknown name case %q, %s, %s or %s is expected", rules.Case, CaseKeep, CaseLower, CaseUpper)
+	}
+
+	if rules.MaxLength < 0 {
+		return nil, fmt.Errorf("the maximum name length must not be negative")
+	}
+
+	p := &NamePolicy{rules: rules}
+	if rules.Chars == "" {
+		return p, nil
+	}
+
+	// допускается только класс одного символа, иначе его нельзя проверять посимвольно
+	re, err := syntax.Parse(rules.Chars, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name character class: %w", err)
+	}
+	if re.Op != syntax.OpCharClass && (re.Op != syntax.OpLiteral || len(re.Rune) != 1) {
+		return nil, fmt.Errorf("invalid name character class %q: a single character class such as [A-Za-z0-9_] is expected", rules.Chars)
+	}
+	p.char = regexp.MustCompile(`^` + rules.Chars + `$`)
+	p.name = regexp.MustCompile(`^` + rules.Chars + `*$`)
+
+	return p, nil
+}
+
+// Apply returns the name normalized by the policy: with the case changed and, in the sanitize mode,
+// with the characters that are not allowed replaced and the name cut to the maximum length.
+// An empty name or a reserved prefix cannot be sanitized and is always rejected.
+func (p *NamePolicy) Apply(name string) (string, error) {
+	if p == nil {
+		return name, nil
+	}
+
+	name = p.changeCase(name)
+	if p.rules.Mode == NameSanitize {
+		name = p.sanitize(name)
+	}
+
+	if name == "" {
+		return "", fmt.Errorf("%w: the name of the metric is empty", ErrInvalidName)
+	}
+
+	if !utf8.ValidString(name) {
+		return "", fmt.Errorf("%w: the name %q is not valid UTF-8", ErrInvalidName, name)
+	}
+
+	if p.rules.MaxLength > 0 && utf8.RuneCountInString(name) > p.rules.MaxLength {
+		return "", fmt.Errorf("%w: the name %q is longer than %d characters", ErrInvalidName, name, p.rules.MaxLength)
+	}
+
+	if p.name != nil && !p.name.MatchString(name) {
+		for _, r := range name {
+			if !p.char.MatchString(string(r)) {
+				return "", fmt.Errorf("%w: the name %q contains %q, the allowed characters are %s", ErrInvalidName, name, r, p.rules.Chars)
+			}
+		}
+	}
+
+	for _, prefix := range p.rules.ReservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return "", fmt.Errorf("%w: the name %q uses the reserved prefix %q", ErrInvalidName, name, prefix)
+		}
+	}
+
+	return name, nil
+}
+
+// Reserve returns the policy for the names that get a suffix of n characters later: its maximum
+// length leaves room for the suffix, so that a sanitized name is cut before the suffix is added
+// and not the suffix itself. The full names still have to be checked by the policy.
+func (p *NamePolicy) Reserve(n int) *NamePolicy {
+	if p == nil || p.rules.MaxLength == 0 {
+		return p
+	}
+	reserved := *p
+	// 0 означает отсутствие ограничения, слишком короткий предел проверит полная политика
+	reserved.rules.MaxLength = max(p.rules.MaxLength-n, 1)
+	return &reserved
+}
+
+// Filter applies the policy to the names of the metrics in place and drops the metrics whose names
+// are rejected. It returns the metrics kept, the number of the dropped ones and the error of the first of them.
+func (p *NamePolicy) Filter(metrics []models.Metrics) ([]models.Metrics, int, error) {
+	if p == nil {
+		return metrics, 0, nil
+	}
+
+	var first error
+	kept := metrics[:0]
+	for _, m := range metrics {
+		name, err := p.Apply(m.ID)
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			continue
+		}
+		m.ID = name
+		kept = append(kept, m)
+	}
+	return kept, len(metrics) - len(kept), first
+}
+
+// Normalize returns the name the metric would be stored under, for the lookups of the metrics.
+// A name the policy rejects is returned with only its case changed, no metric is stored under it.
+func (p *NamePolicy) Normalize(name string) string {
+	if p == nil {
+		return name
+	}
+	if normalized, err := p.Apply(name); err == nil {
+		return normalized
+	}
+	return p.changeCase(name)
+}
+
+func (p *NamePolicy) changeCase(name string) string {
+	switch p.rules.Case {
+	case CaseLower:
+		return strings.ToLower(name)
+	case CaseUpper:
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+// sanitize replaces the invalid UTF-8 and the characters that are not allowed with sanitizeRune,
+// or drops the latter if it is not allowed either, and cuts the name to the maximum length.
+func (p *NamePolicy) sanitize(name string) string {
+	name = strings.ToValidUTF8(name, string(sanitizeRune))
+
+	if p.name != nil && !p.name.MatchString(name) {
+		replacement := ""
+		if p.char.MatchString(string(sanitizeRune)) {
+			replacement = string(sanitizeRune)
+		}
+
+		var b strings.Builder
+		for _, r := range name {
+			if p.char.MatchString(string(r)) {
+				b.WriteRune(r)
+			} else {
+				b.WriteString(replacement)
+			}
+		}
+		name = b.String()
+	}
+
+	if p.rules.MaxLength > 0 && utf8.RuneCountInString(name) > p.rules.MaxLength {
+		n := 0
+		for i := range name {
+			if n == p.rules.MaxLength {
+				return name[:i]
+			}
+			n++
+		}
+	}
+	return name
+}
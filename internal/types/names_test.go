@@ -0,0 +1,111 @@
+package types
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/plasmatrip/metriq/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNamePolicy_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   NameRules
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Default rules", rules: NameRules{Chars: DefaultNameChars, MaxLength: DefaultNameMaxLength}, value: "_metriq.cpu{core=\"0\"}", want: "_metriq.cpu{core=\"0\"}"},
+		{name: "Slash is not allowed by default", rules: NameRules{Chars: DefaultNameChars}, value: "disk/sda", wantErr: true},
+		{name: "Space is not allowed by default", rules: NameRules{Chars: DefaultNameChars}, value: "free memory", wantErr: true},
+		{name: "Empty name", rules: NameRules{Mode: NameSanitize}, value: "", wantErr: true},
+		{name: "Too long", rules: NameRules{MaxLength: 5}, value: "Alloc1", wantErr: true},
+		{name: "Length in characters", rules: NameRules{MaxLength: 5}, value: "время", want: "время"},
+		{name: "Character class", rules: NameRules{Chars: "[A-Za-z0-9_]"}, value: "cpu.load", wantErr: true},
+		{name: "Reserved prefix", rules: NameRules{ReservedPrefixes: []string{"sys."}}, value: "sys.load", wantErr: true},
+		{name: "Lower case", rules: NameRules{Case: CaseLower}, value: "PollCount", want: "pollcount"},
+		{name: "Upper case", rules: NameRules{Case: CaseUpper}, value: "PollCount", want: "POLLCOUNT"},
+		{name: "Invalid UTF-8", rules: NameRules{}, value: "load\xff", wantErr: true},
+		{name: "Sanitize characters", rules: NameRules{Mode: NameSanitize, Chars: "[a-z_.]"}, value: "Disk/Sda 1", want: "_isk__da__"},
+		{name: "Sanitize without the replacement", rules: NameRules{Mode: NameSanitize, Chars: "[a-z]"}, value: "cpu_load", want: "cpuload"},
+		{name: "Sanitize the length", rules: NameRules{Mode: NameSanitize, MaxLength: 3}, value: "время", want: "вре"},
+		{name: "Sanitize invalid UTF-8", rules: NameRules{Mode: NameSanitize}, value: "load\xff", want: "load_"},
+		{name: "Sanitize after the case", rules: NameRules{Mode: NameSanitize, Chars: "[a-z]", Case: CaseLower}, value: "Alloc", want: "alloc"},
+		{name: "Reserved prefix is not sanitized", rules: NameRules{Mode: NameSanitize, ReservedPrefixes: []string{"sys."}}, value: "sys.load", wantErr: true},
+		{name: "Name of disallowed characters only", rules: NameRules{Mode: NameSanitize, Chars: "[a-z]"}, value: "123", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := NewNamePolicy(test.rules)
+			require.NoError(t, err)
+
+			got, err := p.Apply(test.value)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidName)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestNewNamePolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules NameRules
+	}{
+		{name: "Unknown mode", rules: NameRules{Mode: "drop"}},
+		{name: "Unknown case", rules: NameRules{Case: "title"}},
+		{name: "Negative length", rules: NameRules{MaxLength: -1}},
+		{name: "Invalid expression", rules: NameRules{Chars: "[a-z"}},
+		{name: "Several characters", rules: NameRules{Chars: "[a-z]+"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewNamePolicy(test.rules)
+			assert.Error(t, err)
+		})
+	}
+
+	// без правил принимается любое имя
+	var p *NamePolicy
+	got, err := p.Apply("")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+}
+
+func TestNamePolicy_Filter(t *testing.T) {
+	p, err := NewNamePolicy(NameRules{MaxLength: 10, Case: CaseLower})
+	require.NoError(t, err)
+
+	metrics := []models.Metrics{{ID: "Alloc"}, {ID: strings.Repeat("x", 11)}, {ID: "PollCount"}}
+	kept, dropped, err := p.Filter(metrics)
+	assert.ErrorIs(t, err, ErrInvalidName)
+	assert.Equal(t, 1, dropped)
+	assert.Equal(t, []models.Metrics{{ID: "alloc"}, {ID: "pollcount"}}, kept)
+
+	// отклоненное имя ищется только с приведенным регистром
+	assert.Equal(t, "alloc", p.Normalize("Alloc"))
+	assert.Equal(t, strings.Repeat("x", 11), p.Normalize(strings.Repeat("X", 11)))
+}
+
+func TestNamePolicy_Reserve(t *testing.T) {
+	p, err := NewNamePolicy(NameRules{Mode: NameSanitize, MaxLength: 10})
+	require.NoError(t, err)
+
+	// имя обрезается так, чтобы суффикс поместился целиком
+	name, err := p.Reserve(len(".count")).Apply(strings.Repeat("x", 12))
+	require.NoError(t, err)
+	assert.Equal(t, "xxxx", name)
+	full, err := p.Apply(name + ".count")
+	require.NoError(t, err)
+	assert.Equal(t, "xxxx.count", full)
+
+	var nilPolicy *NamePolicy
+	assert.Nil(t, nilPolicy.Reserve(5))
+}
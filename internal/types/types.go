@@ -27,10 +27,13 @@ func (metric Metric) Check() error {
 	}
 	switch metric.MetricType {
 	case Gauge:
-		_, ok := metric.Value.(float64)
+		value, ok := metric.Value.(float64)
 		if !ok {
 			return errors.New("the value is not float64")
 		}
+		if err := checkFinite(value); err != nil {
+			return err
+		}
 	case Counter:
 		_, ok := metric.Value.(int64)
 		if !ok {
@@ -61,7 +64,10 @@ func CheckValue(mType, mValue string) (any, error) {
 	switch mType {
 	case Gauge:
 		value, err := strconv.ParseFloat(mValue, 64)
-		return value, err
+		if err != nil {
+			return value, err
+		}
+		return value, checkFinite(value)
 	case Counter:
 		value, err := strconv.ParseInt(mValue, 10, 64)
 		return value, err
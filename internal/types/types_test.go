@@ -1,6 +1,7 @@
 package types
 
 import (
+	"math"
 	"testing"
 
 	"github.com/plasmatrip/metriq/internal/models"
@@ -146,6 +147,44 @@ func TestTypes_CheckValue(t *testing.T) {
 		_, err := CheckValue("SomeType", "aa")
 		assert.Error(t, err)
 	})
+	t.Run("Not finite value", func(t *testing.T) {
+		_, err := CheckValue(Gauge, "NaN")
+		assert.ErrorIs(t, err, ErrNotFinite)
+		_, err = CheckValue(Gauge, "+Inf")
+		assert.ErrorIs(t, err, ErrNotFinite)
+	})
+}
+
+func TestTypes_CheckFields(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+	delta := func(v int64) *int64 { return &v }
+
+	tests := []struct {
+		name   string
+		metric models.Metrics
+		field  string
+	}{
+		{name: "Valid gauge", metric: models.Metrics{ID: "load", MType: Gauge, Value: value(1.5)}},
+		{name: "Valid counter", metric: models.Metrics{ID: "requests", MType: Counter, Delta: delta(1)}},
+		{name: "Gauge without value", metric: models.Metrics{ID: "load", MType: Gauge}, field: "value"},
+		{name: "Gauge with delta", metric: models.Metrics{ID: "load", MType: Gauge, Value: value(1.5), Delta: delta(1)}, field: "delta"},
+		{name: "NaN gauge", metric: models.Metrics{ID: "load", MType: Gauge, Value: value(math.NaN())}, field: "value"},
+		{name: "Infinite gauge", metric: models.Metrics{ID: "load", MType: Gauge, Value: value(math.Inf(-1))}, field: "value"},
+		{name: "Counter without delta", metric: models.Metrics{ID: "requests", MType: Counter}, field: "delta"},
+		{name: "Counter with value", metric: models.Metrics{ID: "requests", MType: Counter, Delta: delta(1), Value: value(1)}, field: "value"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckFields(test.metric)
+			if test.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var fieldErr *FieldError
+			assert.ErrorAs(t, err, &fieldErr)
+			assert.Equal(t, test.field, fieldErr.Field)
+		})
+	}
 }
 
 func TestTypes_CheckMetricType(t *testing.T) {